@@ -0,0 +1,360 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultAuthSessionTTL is how long an AuthSession remains valid when no TTL is supplied via WithAuthSessionTTL.
+const defaultAuthSessionTTL = 10 * time.Minute
+
+// defaultMaxMemoryAuthSessions is the number of sessions kept by a MemoryAuthSessionStore created via
+// NewMemoryAuthSessionStore. Once reached, the session closest to expiring is discarded.
+const defaultMaxMemoryAuthSessions = 10000
+
+// ErrAuthSessionExpired is returned by CompleteAuth when the user returns after their AuthSession has expired.
+var ErrAuthSessionExpired = errors.New("authorization session has expired")
+
+// ErrNonceMismatch is returned by CompleteAuth when the ID token returned by Twitch does not carry the session's
+// nonce, which may indicate a replayed response.
+var ErrNonceMismatch = errors.New("nonce returned by twitch does not match the session's nonce")
+
+/*
+AuthSession stores the state of a single login started via BeginAuth. Each session has its own state, nonce and
+PKCE code verifier, so a single AuthorizationCodeGrantAuthenticator can serve many concurrent logins.
+
+Sessions are kept in an AuthSessionStore until the user returns, and can be marshalled to JSON for storage.
+*/
+type AuthSession struct {
+	State string `json:"state"`
+	// Nonce is sent to Twitch and checked against the ID token returned when the openid scope is requested.
+	Nonce string `json:"nonce"`
+	// CodeVerifier is the PKCE code verifier, whose S256 challenge is included in the authorization URL.
+	CodeVerifier     string      `json:"code_verifier"`
+	Scopes           []ScopeType `json:"scopes"`
+	RedirectUri      string      `json:"redirect_uri"`
+	ForceVerify      bool        `json:"force_verify"`
+	AuthorizationUrl string      `json:"authorization_url"`
+	CreatedAt        time.Time   `json:"created_at"`
+	ExpiresAt        time.Time   `json:"expires_at"`
+}
+
+// AuthOptions customizes a single AuthSession started via BeginAuth. Empty fields fall back to the values supplied
+// to the authenticator.
+type AuthOptions struct {
+	Scopes      []ScopeType
+	RedirectUri string
+	ForceVerify bool
+}
+
+// AuthResult stores the result of completing an AuthSession via CompleteAuth. The embedded TokenResponse should
+// be checked for a TokenRequestStatus of StatusSuccess.
+type AuthResult struct {
+	*TokenResponse
+	Session *AuthSession
+}
+
+/*
+AuthSessionStore stores AuthSession instances between BeginAuth and CompleteAuth. Implementations can persist
+sessions (ex. in Redis) so that logins survive restarts or span several instances of an app, and must be safe for
+concurrent use.
+*/
+type AuthSessionStore interface {
+	// Save stores the session until its ExpiresAt.
+	Save(ctx context.Context, s *AuthSession) error
+
+	// Take retrieves and removes the session with the supplied state, returning ErrUnknownState if there is none.
+	Take(ctx context.Context, state string) (*AuthSession, error)
+}
+
+/*
+MemoryAuthSessionStore is an AuthSessionStore that keeps sessions in memory. Expired sessions are discarded as new
+ones are saved, in order of expiry, so saving a session does not scan every session in the store.
+
+The store holds a limited number of sessions (10,000 by default), so that abandoned logins cannot grow it without
+bound. Once full, saving a session discards the one closest to expiring, whose user then receives ErrUnknownState
+when they return. As anyone can start a login, a client that floods BeginAuth can push out the sessions of legitimate
+users; apps exposed to that should rate limit the handler calling BeginAuth, raise the limit via
+NewMemoryAuthSessionStoreWithLimit, or use an AuthSessionStore backed by external storage.
+
+New instances of MemoryAuthSessionStore should be created via NewMemoryAuthSessionStore or
+NewMemoryAuthSessionStoreWithLimit.
+*/
+type MemoryAuthSessionStore struct {
+	mu       sync.Mutex
+	limit    int
+	sessions map[string]*AuthSession
+	// expiry orders the sessions by expiry, so that expired and soonest-expiring sessions are found without a scan.
+	expiry expiryQueue
+}
+
+// NewMemoryAuthSessionStore generates a new MemoryAuthSessionStore instance that holds up to 10,000 sessions.
+func NewMemoryAuthSessionStore() *MemoryAuthSessionStore {
+	return &MemoryAuthSessionStore{limit: defaultMaxMemoryAuthSessions, sessions: map[string]*AuthSession{}}
+}
+
+// NewMemoryAuthSessionStoreWithLimit generates a new MemoryAuthSessionStore instance that holds up to limit sessions.
+// An error is returned if limit is not positive.
+func NewMemoryAuthSessionStoreWithLimit(limit int) (*MemoryAuthSessionStore, error) {
+	if limit <= 0 {
+		return nil, errors.New("NewMemoryAuthSessionStoreWithLimit: limit must be positive")
+	}
+
+	return &MemoryAuthSessionStore{limit: limit, sessions: map[string]*AuthSession{}}, nil
+}
+
+// Save stores the session, discarding any that have expired and, if the store is still full, the one closest to
+// expiring.
+func (m *MemoryAuthSessionStore) Save(_ context.Context, s *AuthSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.sessions == nil {
+		m.sessions = map[string]*AuthSession{}
+	}
+
+	now := time.Now()
+	for e, ok := m.expiry.popExpired(now); ok; e, ok = m.expiry.popExpired(now) {
+		if m.live(e) {
+			delete(m.sessions, e.key)
+		}
+	}
+
+	limit := m.limit
+	if limit <= 0 {
+		limit = defaultMaxMemoryAuthSessions
+	}
+
+	if _, ok := m.sessions[s.State]; !ok {
+		for len(m.sessions) >= limit {
+			e, ok := m.expiry.pop()
+			if !ok {
+				break
+			}
+
+			if m.live(e) {
+				delete(m.sessions, e.key)
+			}
+		}
+	}
+
+	m.sessions[s.State] = s
+	m.expiry.add(s.State, s.ExpiresAt)
+	m.expiry.compact(len(m.sessions), m.live)
+
+	return nil
+}
+
+// Take retrieves and removes the session with the supplied state.
+func (m *MemoryAuthSessionStore) Take(_ context.Context, state string) (*AuthSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[state]
+	if !ok {
+		return nil, ErrUnknownState
+	}
+
+	delete(m.sessions, state)
+	m.expiry.compact(len(m.sessions), m.live)
+
+	return s, nil
+}
+
+// live reports whether a queued expiry still belongs to a stored session, rather than one since taken or replaced.
+// The caller must hold m.mu.
+func (m *MemoryAuthSessionStore) live(e expiryEntry) bool {
+	s, ok := m.sessions[e.key]
+	return ok && s.ExpiresAt.Equal(e.expiresAt)
+}
+
+// WithAuthSessionStore sets the AuthSessionStore used by BeginAuth and CompleteAuth. By default, sessions are kept
+// in a MemoryAuthSessionStore.
+func WithAuthSessionStore(s AuthSessionStore) AuthenticatorOption {
+	return func(o *authenticatorOptions) error {
+		o.applied = append(o.applied, "WithAuthSessionStore")
+		if s == nil {
+			return errors.New("WithAuthSessionStore: store must not be nil")
+		}
+
+		o.sessionStore = s
+		return nil
+	}
+}
+
+// WithAuthSessionTTL sets how long a user has to complete a login started via BeginAuth. Defaults to ten minutes.
+func WithAuthSessionTTL(ttl time.Duration) AuthenticatorOption {
+	return func(o *authenticatorOptions) error {
+		o.applied = append(o.applied, "WithAuthSessionTTL")
+		if ttl <= 0 {
+			return errors.New("WithAuthSessionTTL: ttl must be positive")
+		}
+
+		o.sessionTTL = ttl
+		return nil
+	}
+}
+
+// BeginAuth starts a new login. See BeginAuthWithContext for details.
+func (a *AuthorizationCodeGrantAuthenticator) BeginAuth(opts AuthOptions) (*AuthSession, error) {
+	return a.BeginAuthWithContext(context.Background(), opts)
+}
+
+/*
+BeginAuthWithContext starts a new login, generating an AuthSession with a random state, nonce and PKCE code verifier,
+and saving it to the authenticator's AuthSessionStore. The user should be sent to the session's AuthorizationUrl.
+
+The scopes and redirect URI default to those supplied to the authenticator, and are validated as they are by
+GenerateAuthorizationUrl.
+*/
+func (a *AuthorizationCodeGrantAuthenticator) BeginAuthWithContext(ctx context.Context, opts AuthOptions) (*AuthSession, error) {
+	s := &AuthSession{
+		Scopes:      opts.Scopes,
+		RedirectUri: opts.RedirectUri,
+		ForceVerify: opts.ForceVerify || a.forceVerify,
+		CreatedAt:   time.Now(),
+	}
+	s.ExpiresAt = s.CreatedAt.Add(a.sessionTTL)
+
+	if s.Scopes == nil {
+		s.Scopes = a.requestedScopes.load()
+	}
+	s.Scopes = append([]ScopeType(nil), s.Scopes...)
+
+	if s.RedirectUri == "" {
+		s.RedirectUri = a.redirectUri
+	}
+
+	var err error
+	for _, v := range []*string{&s.State, &s.Nonce, &s.CodeVerifier} {
+		*v, err = randomToken()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	challenge := sha256.Sum256([]byte(s.CodeVerifier))
+	u, err := a.buildAuthorizationUrl(s.RedirectUri, s.State, s.Scopes, url.Values{
+		"force_verify":          {fmt.Sprint(s.ForceVerify)},
+		"nonce":                 {s.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.AuthorizationUrl = u.String()
+
+	err = a.sessionStore.Save(ctx, s)
+	if err != nil {
+		e := fmt.Sprintf("error while saving authorization session: %s", err)
+		return nil, errors.New(e)
+	}
+
+	return s, nil
+}
+
+// CompleteAuth finishes a login started via BeginAuth. See CompleteAuthWithContext for details.
+func (a *AuthorizationCodeGrantAuthenticator) CompleteAuth(callbackUrl *url.URL) (*AuthResult, error) {
+	return a.CompleteAuthWithContext(context.Background(), callbackUrl)
+}
+
+/*
+CompleteAuthWithContext finishes a login started via BeginAuth, using the URL that Twitch redirected the user to.
+The session matching the returned state is taken from the AuthSessionStore - so each session can only be completed
+once - and verified before the code is exchanged using the session's redirect URI and PKCE code verifier.
+
+ErrUnknownState is returned if no session matches the state, and ErrAuthSessionExpired if the session has expired.
+If the user denied access, an error describing Twitch's response is returned.
+*/
+func (a *AuthorizationCodeGrantAuthenticator) CompleteAuthWithContext(ctx context.Context, callbackUrl *url.URL) (*AuthResult, error) {
+	q := callbackUrl.Query()
+
+	state := q.Get("state")
+	if state == "" {
+		return nil, ErrUnknownState
+	}
+
+	s, err := a.sessionStore.Take(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(s.ExpiresAt) {
+		return nil, ErrAuthSessionExpired
+	}
+
+	if q.Get("error") != "" {
+		e := fmt.Sprintf("authorization failed: %s - %s", q.Get("error"), q.Get("error_description"))
+		return nil, errors.New(e)
+	}
+
+	code := q.Get("code")
+	if code == "" {
+		return nil, errors.New("callback does not contain an authorization code")
+	}
+
+	t, err := a.exchangeCode(ctx, code, s.RedirectUri, url.Values{"code_verifier": {s.CodeVerifier}})
+	if err != nil {
+		return nil, err
+	}
+
+	if t.TokenRequestStatus == StatusSuccess && t.TokenData.IdToken != "" {
+		err = verifyNonce(t.TokenData.IdToken, s.Nonce)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &AuthResult{TokenResponse: t, Session: s}, nil
+}
+
+// verifyNonce confirms that the supplied ID token carries the expected nonce. The token's signature is not checked,
+// as it was received directly from Twitch over TLS.
+func verifyNonce(idToken string, nonce string) error {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return errors.New("id token returned by twitch is malformed")
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		e := fmt.Sprintf("error while decoding id token: %s", err)
+		return errors.New(e)
+	}
+
+	var claims struct {
+		Nonce string `json:"nonce"`
+	}
+	err = json.Unmarshal(b, &claims)
+	if err != nil {
+		e := fmt.Sprintf("error while parsing id token: %s", err)
+		return errors.New(e)
+	}
+
+	if claims.Nonce != nonce {
+		return ErrNonceMismatch
+	}
+
+	return nil
+}
+
+// randomToken generates an unguessable, URL-safe value for states, nonces and code verifiers.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
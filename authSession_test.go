@@ -0,0 +1,240 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryAuthSessionStoreIsBounded(t *testing.T) {
+	m := NewMemoryAuthSessionStore()
+	ctx := context.Background()
+	start := time.Now().Add(time.Hour)
+
+	for i := 0; i <= defaultMaxMemoryAuthSessions; i++ {
+		err := m.Save(ctx, &AuthSession{State: strconv.Itoa(i), ExpiresAt: start.Add(time.Duration(i) * time.Second)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(m.sessions) != defaultMaxMemoryAuthSessions {
+		t.Fatalf("store holds %d sessions, want %d", len(m.sessions), defaultMaxMemoryAuthSessions)
+	}
+
+	_, err := m.Take(ctx, "0")
+	if err != ErrUnknownState {
+		t.Errorf("Take() of the session closest to expiring error = %v, want %v", err, ErrUnknownState)
+	}
+
+	_, err = m.Take(ctx, strconv.Itoa(defaultMaxMemoryAuthSessions))
+	if err != nil {
+		t.Errorf("Take() of the newest session error = %v", err)
+	}
+}
+
+func TestPendingRedirectsIsBounded(t *testing.T) {
+	var p pendingRedirects
+	for i := 0; i <= maxPendingRedirects; i++ {
+		p.add(strconv.Itoa(i), "http://localhost/callback")
+	}
+
+	if len(p.entries) != maxPendingRedirects {
+		t.Fatalf("pendingRedirects holds %d entries, want %d", len(p.entries), maxPendingRedirects)
+	}
+
+	if _, ok := p.take(strconv.Itoa(maxPendingRedirects)); !ok {
+		t.Error("take() of the newest state failed")
+	}
+}
+
+func TestMemoryAuthSessionStoreWithLimit(t *testing.T) {
+	_, err := NewMemoryAuthSessionStoreWithLimit(0)
+	if err == nil {
+		t.Fatal("NewMemoryAuthSessionStoreWithLimit(0) error = nil, want an error")
+	}
+
+	m, err := NewMemoryAuthSessionStoreWithLimit(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	save := func(state string, expiresAt time.Time) {
+		t.Helper()
+
+		err := m.Save(ctx, &AuthSession{State: state, ExpiresAt: expiresAt})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Expired sessions are discarded before any unexpired one is evicted.
+	save("expired", now.Add(-time.Second))
+	save("later", now.Add(2*time.Hour))
+	save("sooner", now.Add(time.Hour))
+
+	if _, err = m.Take(ctx, "expired"); !errors.Is(err, ErrUnknownState) {
+		t.Errorf("Take() of an expired session error = %v, want %v", err, ErrUnknownState)
+	}
+
+	// Once full, the session closest to expiring is evicted, regardless of the order sessions were saved in.
+	save("latest", now.Add(3*time.Hour))
+
+	if _, err = m.Take(ctx, "sooner"); !errors.Is(err, ErrUnknownState) {
+		t.Errorf("Take() of the evicted session error = %v, want %v", err, ErrUnknownState)
+	}
+
+	for _, state := range []string{"later", "latest"} {
+		if _, err = m.Take(ctx, state); err != nil {
+			t.Errorf("Take(%q) error = %v", state, err)
+		}
+	}
+
+	// Replacing and taking sessions leaves stale expiries behind, which are compacted away.
+	for i := 0; i < 1000; i++ {
+		save("retried", now.Add(time.Duration(i)*time.Second))
+	}
+
+	if m.expiry.Len() > 2*len(m.sessions)+minExpiryQueueCompaction+1 {
+		t.Errorf("store queues %d expiries for %d sessions", m.expiry.Len(), len(m.sessions))
+	}
+}
+
+// forgeIdToken builds an unsigned ID token carrying the supplied nonce. CompleteAuth only reads the nonce, as ID
+// tokens are received directly from Twitch.
+func forgeIdToken(nonce string) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"aud":"client-id","sub":"42","nonce":"` + nonce + `"}`))
+
+	return header + "." + claims + ".signature"
+}
+
+func TestCompleteAuth(t *testing.T) {
+	tests := map[string]struct {
+		ttl time.Duration
+		// callback modifies the query of the callback URL returned by Twitch.
+		callback func(q url.Values)
+		// idToken builds the ID token returned for the session's nonce.
+		idToken       func(nonce string) string
+		wantErr       error
+		wantErrText   string
+		wantExchanged bool
+		// keepsSession is set when the callback does not identify the session, which can still be completed.
+		keepsSession bool
+	}{
+		"success":      {idToken: forgeIdToken, wantExchanged: true},
+		"without oidc": {wantExchanged: true},
+		"nonce mismatch": {
+			idToken:       func(string) string { return forgeIdToken("another-nonce") },
+			wantErr:       ErrNonceMismatch,
+			wantExchanged: true,
+		},
+		"malformed id token": {
+			idToken:       func(string) string { return "not-a-jwt" },
+			wantErrText:   "id token returned by twitch is malformed",
+			wantExchanged: true,
+		},
+		"expired": {ttl: time.Millisecond, wantErr: ErrAuthSessionExpired},
+		"error callback": {
+			callback: func(q url.Values) {
+				q.Del("code")
+				q.Set("error", "access_denied")
+				q.Set("error_description", "The user denied you access")
+			},
+			wantErrText: "authorization failed: access_denied - The user denied you access",
+		},
+		"missing code":  {callback: func(q url.Values) { q.Del("code") }, wantErrText: "does not contain an authorization code"},
+		"unknown state": {callback: func(q url.Values) { q.Set("state", "forged") }, wantErr: ErrUnknownState, keepsSession: true},
+		"missing state": {callback: func(q url.Values) { q.Del("state") }, wantErr: ErrUnknownState, keepsSession: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var session *AuthSession
+			var exchanges atomic.Int32
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				exchanges.Add(1)
+
+				_ = r.ParseForm()
+				if r.PostForm.Get("code_verifier") != session.CodeVerifier || r.PostForm.Get("redirect_uri") != session.RedirectUri {
+					w.WriteHeader(http.StatusBadRequest)
+					_, _ = w.Write([]byte(`{"status":400,"message":"Invalid code verifier"}`))
+					return
+				}
+
+				body := `{"access_token":"access","refresh_token":"refresh","expires_in":14400,"scope":["chat:read"],"token_type":"bearer"`
+				if tt.idToken != nil {
+					body += `,"id_token":"` + tt.idToken(session.Nonce) + `"`
+				}
+				_, _ = w.Write([]byte(body + "}"))
+			}))
+			t.Cleanup(srv.Close)
+
+			opts := []AuthenticatorOption{WithHTTPClient(srv.Client()), WithEndpoints(Endpoints{TokenUrl: srv.URL})}
+			if tt.ttl > 0 {
+				opts = append(opts, WithAuthSessionTTL(tt.ttl))
+			}
+
+			a, err := NewAuthorizationCodeGrantAuthenticatorWithOptions("client-id", "client-secret", "http://localhost/callback", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			session, err = a.BeginAuth(AuthOptions{Scopes: []ScopeType{ScopeChatRead}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(2 * tt.ttl)
+
+			q := url.Values{"code": {"code"}, "scope": {"chat:read"}, "state": {session.State}}
+			if tt.callback != nil {
+				tt.callback(q)
+			}
+
+			res, err := a.CompleteAuth(&url.URL{Scheme: "http", Host: "localhost", Path: "/callback", RawQuery: q.Encode()})
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("CompleteAuth() error = %v, want %v", err, tt.wantErr)
+				}
+			case tt.wantErrText != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErrText) {
+					t.Errorf("CompleteAuth() error = %v, want an error containing %q", err, tt.wantErrText)
+				}
+			case err != nil:
+				t.Fatalf("CompleteAuth() error = %v", err)
+			default:
+				checkTokenResponse(t, res.TokenResponse, http.StatusOK)
+				if res.Session != session {
+					t.Errorf("CompleteAuth().Session = %+v, want the session started by BeginAuth", res.Session)
+				}
+			}
+
+			if got := exchanges.Load() > 0; got != tt.wantExchanged {
+				t.Errorf("code exchanged = %t, want %t", got, tt.wantExchanged)
+			}
+
+			// A session can only be completed once, whether or not it succeeded.
+			retry := url.Values{"code": {"code"}, "state": {session.State}}
+			_, err = a.CompleteAuth(&url.URL{RawQuery: retry.Encode()})
+			if tt.keepsSession && err != nil {
+				t.Errorf("CompleteAuth() of the untouched session error = %v", err)
+			}
+
+			if !tt.keepsSession && !errors.Is(err, ErrUnknownState) {
+				t.Errorf("second CompleteAuth() error = %v, want %v", err, ErrUnknownState)
+			}
+		})
+	}
+}
@@ -1,12 +1,9 @@
 ﻿package go_twitchAuth
 
 import (
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io"
-	"net/http"
+	"context"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -16,13 +13,15 @@ import (
 AuthorizationCodeGrantAuthenticator allows for the generation of an authorization URL following Twitch's
 OAuth client credentials grant flow.
 
+An AuthorizationCodeGrantAuthenticator is safe for concurrent use by multiple goroutines.
+
 New instances of AuthorizationCodeGrantAuthenticator should be created via
-NewAuthorizationCodeGrantAuthenticator.
+NewAuthorizationCodeGrantAuthenticatorWithOptions or NewAuthorizationCodeGrantAuthenticator.
 
 Twitch docs: https://dev.twitch.tv/docs/authentication/getting-tokens-oauth/#authorization-code-grant-flow
 */
 type AuthorizationCodeGrantAuthenticator struct {
-	requestedScopes []ScopeType
+	requestedScopes scopeSnapshot
 	clientId        string
 	clientSecret    string
 	forceVerify     bool
@@ -31,26 +30,104 @@ type AuthorizationCodeGrantAuthenticator struct {
 	state           string
 	grantType       string
 	responseType    string
+	// registeredRedirectUris is the allow-list supplied via WithRegisteredRedirectUris.
+	registeredRedirectUris []string
+	// pendingRedirects remembers the redirect URI chosen for each state by GenerateAuthorizationUrlFor.
+	pendingRedirects pendingRedirects
+	// sessionStore and sessionTTL configure the sessions started via BeginAuth.
+	sessionStore AuthSessionStore
+	sessionTTL   time.Duration
+	requestConfig
 }
 
-// NewAuthorizationCodeGrantAuthenticator generates a new AuthorizationCodeGrantAuthenticator instance.
+// NewAuthorizationCodeGrantAuthenticator generates a new AuthorizationCodeGrantAuthenticator instance. Unlike
+// NewAuthorizationCodeGrantAuthenticatorWithOptions, the supplied values are not validated.
 func NewAuthorizationCodeGrantAuthenticator(clientId string, clientSecret string, forceVerify bool, redirectUri string, scopes []ScopeType, state string) *AuthorizationCodeGrantAuthenticator {
-	return &AuthorizationCodeGrantAuthenticator{
-		requestedScopes: scopes,
-		clientId:        clientId,
-		clientSecret:    clientSecret,
-		forceVerify:     forceVerify,
-		redirectUri:     redirectUri,
-		state:           state,
-		grantType:       "authorization_code",
-		responseType:    "code",
+	return newAuthorizationCodeGrantAuthenticator(clientId, clientSecret, redirectUri, &authenticatorOptions{
+		forceVerify:   forceVerify,
+		state:         state,
+		scopes:        scopes,
+		requestConfig: requestConfig{endpoints: DefaultEndpoints()},
+	})
+}
+
+/*
+NewAuthorizationCodeGrantAuthenticatorWithOptions generates a new AuthorizationCodeGrantAuthenticator instance,
+configured via WithForceVerify, WithState, WithScopes, WithRegisteredRedirectUris, WithAuthSessionStore,
+WithAuthSessionTTL, WithHTTPClient, WithEndpoints and WithLogger.
+
+An error is returned if clientId is invalid, clientSecret is empty, redirectUri breaks Twitch's rules (see
+ValidateRedirectUri) or isn't registered, or any option is invalid.
+*/
+func NewAuthorizationCodeGrantAuthenticatorWithOptions(clientId string, clientSecret string, redirectUri string, opts ...AuthenticatorOption) (*AuthorizationCodeGrantAuthenticator, error) {
+	err := ValidateClientId(clientId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateClientSecret(clientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := applyOptions("AuthorizationCodeGrantAuthenticator", opts, "WithForceVerify", "WithState", "WithScopes", "WithRegisteredRedirectUris", "WithAuthSessionStore", "WithAuthSessionTTL", "WithHTTPClient", "WithEndpoints", "WithLogger")
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateRegisteredRedirectUri(redirectUri, o.registeredRedirectUris)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAuthorizationCodeGrantAuthenticator(clientId, clientSecret, redirectUri, o), nil
+}
+
+// newAuthorizationCodeGrantAuthenticator generates a new AuthorizationCodeGrantAuthenticator instance from
+// already-collected options.
+func newAuthorizationCodeGrantAuthenticator(clientId string, clientSecret string, redirectUri string, o *authenticatorOptions) *AuthorizationCodeGrantAuthenticator {
+	a := &AuthorizationCodeGrantAuthenticator{
+		clientId:               clientId,
+		clientSecret:           clientSecret,
+		forceVerify:            o.forceVerify,
+		redirectUri:            redirectUri,
+		state:                  o.state,
+		grantType:              "authorization_code",
+		responseType:           "code",
+		registeredRedirectUris: o.registeredRedirectUris,
+		sessionStore:           o.sessionStore,
+		sessionTTL:             o.sessionTTL,
+		requestConfig:          o.requestConfig,
+	}
+	a.requestedScopes.store(o.scopes)
+
+	if a.sessionStore == nil {
+		a.sessionStore = NewMemoryAuthSessionStore()
+	}
+
+	if a.sessionTTL == 0 {
+		a.sessionTTL = defaultAuthSessionTTL
 	}
+
+	return a
 }
 
 // GenerateAuthorizationUrl builds a url.URL that allows a user to authorize a Twitch app and generate
-// a bearer token.
+// a bearer token. An error is returned if the client ID, redirect URI or requested scopes would be rejected by
+// Twitch.
 func (a *AuthorizationCodeGrantAuthenticator) GenerateAuthorizationUrl() (*url.URL, error) {
-	authUrl, err := url.Parse(authorizationUrl)
+	return a.buildAuthorizationUrl(a.redirectUri, a.state, a.requestedScopes.load(), nil)
+}
+
+// buildAuthorizationUrl builds the authorization URL for the supplied redirect URI, state and scopes. Any extra
+// parameters are added to the URL's query.
+func (a *AuthorizationCodeGrantAuthenticator) buildAuthorizationUrl(redirectUri string, state string, scopes []ScopeType, extra url.Values) (*url.URL, error) {
+	err := validateAuthorizationRequest(a.clientId, redirectUri, a.registeredRedirectUris, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	authUrl, err := url.Parse(a.urls().AuthorizationUrl)
 	if err != nil {
 		return nil, err
 	}
@@ -58,12 +135,16 @@ func (a *AuthorizationCodeGrantAuthenticator) GenerateAuthorizationUrl() (*url.U
 	q := authUrl.Query()
 	q.Add("client_id", a.clientId)
 	q.Add("force_verify", strconv.FormatBool(a.forceVerify))
-	q.Add("redirect_uri", a.redirectUri)
+	q.Add("redirect_uri", redirectUri)
 	q.Add("response_type", a.responseType)
-	q.Add("scope", strings.Join(a.getScopeNames(), " "))
+	q.Add("scope", strings.Join(scopeNames(scopes), " "))
 
-	if a.state != "" {
-		q.Add("state", a.state)
+	if state != "" {
+		q.Add("state", state)
+	}
+
+	for k, v := range extra {
+		q[k] = v
 	}
 
 	authUrl.RawQuery = q.Encode()
@@ -71,120 +152,79 @@ func (a *AuthorizationCodeGrantAuthenticator) GenerateAuthorizationUrl() (*url.U
 	return authUrl, err
 }
 
-// getScopeNames retrieves the string version of the ScopeType(s) supplied to the AuthorizationCodeGrantAuthenticator.
-func (a *AuthorizationCodeGrantAuthenticator) getScopeNames() []string {
-	var scopeNames []string
-	for _, s := range a.requestedScopes {
-		scopeNames = append(scopeNames, scopeTypeName[s])
-	}
-
-	return scopeNames
-}
-
 // GetToken retrieves a new bearer token via the Twitch Helix API using the auth code generated when the user
 // follows the authorization URL.
 func (a *AuthorizationCodeGrantAuthenticator) GetToken(code string) (*TokenResponse, error) {
-	var t TokenResponse
-
-	req, err := http.NewRequest("POST", tokenUrl, nil)
-	if err != nil {
-		return nil, err
-	}
+	return a.GetTokenWithContext(context.Background(), code)
+}
 
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+// GetTokenWithContext behaves like GetToken, but stops waiting on the RateLimiter and cancels the request once ctx
+// is done.
+func (a *AuthorizationCodeGrantAuthenticator) GetTokenWithContext(ctx context.Context, code string) (*TokenResponse, error) {
+	return a.exchangeCode(ctx, code, a.redirectUri, nil)
+}
 
-	q := req.URL.Query()
-	q.Add("client_id", a.clientId)
-	q.Add("client_secret", a.clientSecret)
+// exchangeCode exchanges the supplied auth code for a bearer token. redirectUri must match the redirect URI included
+// in the authorization URL that the code was issued for. Any extra parameters are added to the request body.
+func (a *AuthorizationCodeGrantAuthenticator) exchangeCode(ctx context.Context, code string, redirectUri string, extra url.Values) (*TokenResponse, error) {
+	q := url.Values{}
 	q.Add("code", code)
 	q.Add("grant_type", a.grantType)
-	q.Add("redirect_uri", a.redirectUri)
-	req.URL.RawQuery = q.Encode()
+	q.Add("redirect_uri", redirectUri)
 
-	client := http.Client{Timeout: 60 * time.Second}
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	for k, v := range extra {
+		q[k] = v
 	}
 
-	b, err := io.ReadAll(res.Body)
+	res, err := doRequest(ctx, a.apply(apiRequest{
+		endpoint:     EndpointToken,
+		method:       "POST",
+		url:          a.urls().TokenUrl,
+		form:         q,
+		clientId:     a.clientId,
+		clientSecret: a.clientSecret,
+		grantType:    a.grantType,
+	}))
 	if err != nil {
 		return nil, err
 	}
 
-	if res.StatusCode != 200 {
-		t.TokenRequestStatus = StatusFailure
-		err = json.Unmarshal(b, &t.FailureData)
-		if err != nil {
-			e := fmt.Sprintf("error while parsing failed request response: %s", err)
-			return nil, errors.New(e)
-		}
-		return &t, nil
-	}
-
-	t.TokenRequestStatus = StatusSuccess
-	err = json.Unmarshal(b, &t.TokenData)
-	if err != nil {
-		e := fmt.Sprintf("error while parsing token response: %s", err)
-		return nil, errors.New(e)
-	}
-
-	return &t, nil
+	return parseTokenResponse(res, TokenKindUser, a.clientId)
 }
 
 // RefreshToken uses the refresh token provided by the GetToken method to retrieve a new bearer token.
 func (a *AuthorizationCodeGrantAuthenticator) RefreshToken(refreshToken string) (*TokenResponse, error) {
-	var t TokenResponse
-
-	req, err := http.NewRequest("POST", tokenUrl, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	return a.RefreshTokenWithContext(context.Background(), refreshToken)
+}
 
-	q := req.URL.Query()
-	q.Add("client_id", a.clientId)
-	q.Add("client_secret", a.clientSecret)
+// RefreshTokenWithContext behaves like RefreshToken, but stops waiting on the RateLimiter and cancels the request
+// once ctx is done.
+func (a *AuthorizationCodeGrantAuthenticator) RefreshTokenWithContext(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+	q := url.Values{}
 	q.Add("grant_type", "refresh_token")
 	q.Add("refresh_token", refreshToken)
-	req.URL.RawQuery = q.Encode()
 
-	client := http.Client{Timeout: 60 * time.Second}
-	res, err := client.Do(req)
+	res, err := doRequest(ctx, a.apply(apiRequest{
+		endpoint:     EndpointToken,
+		method:       "POST",
+		url:          a.urls().TokenUrl,
+		form:         q,
+		clientId:     a.clientId,
+		clientSecret: a.clientSecret,
+		grantType:    "refresh_token",
+	}))
 	if err != nil {
 		return nil, err
 	}
 
-	b, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if res.StatusCode != 200 {
-		t.TokenRequestStatus = StatusFailure
-		err = json.Unmarshal(b, &t.FailureData)
-		if err != nil {
-			e := fmt.Sprintf("error while parsing failed request response: %s", err)
-			return nil, errors.New(e)
-		}
-		return &t, nil
-	}
-
-	t.TokenRequestStatus = StatusSuccess
-	err = json.Unmarshal(b, &t.TokenData)
-	if err != nil {
-		e := fmt.Sprintf("error while parsing token response: %s", err)
-		return nil, errors.New(e)
-	}
-
-	return &t, nil
+	return parseTokenResponse(res, TokenKindUser, a.clientId)
 }
 
 // UpdateScopes replaces the original array of ScopeType provided during initialization. Call
-// GenerateAuthorizationUrl to reauthorize with new scopes.
+// GenerateAuthorizationUrl to reauthorize with new scopes. The authenticator keeps its own copy of scopes, so the
+// slice may be reused by the caller.
 func (a *AuthorizationCodeGrantAuthenticator) UpdateScopes(scopes []ScopeType) {
-	a.requestedScopes = scopes
+	a.requestedScopes.store(scopes)
 }
 
 /*
@@ -194,5 +234,5 @@ are only what has been supplied to the authenticator - not what the end user has
 To retrieve the scopes that the user has authorized, you can use the ValidateToken function.
 */
 func (a *AuthorizationCodeGrantAuthenticator) GetScopes() []ScopeType {
-	return a.requestedScopes
+	return slices.Clone(a.requestedScopes.load())
 }
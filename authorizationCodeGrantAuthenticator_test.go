@@ -0,0 +1,233 @@
+﻿package go_twitchAuth
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/adamsurek/go-twitchAuth/twitchauthtest"
+)
+
+// authorize follows an authorization URL on the fake, as a user approving the app would, and retrieves the URL that
+// the fake redirected back to.
+func authorize(t *testing.T, s *twitchauthtest.Server, u *url.URL) *url.URL {
+	t.Helper()
+
+	c := s.Client()
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	res, err := c.Get(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorization status code = %d, want %d", res.StatusCode, http.StatusFound)
+	}
+
+	redirect, err := res.Location()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return redirect
+}
+
+func TestAuthorizationCodeGrantAuthenticatorGetToken(t *testing.T) {
+	tests := map[string]struct {
+		code       func(code string) string
+		failure    *twitchauthtest.Failure
+		wantStatus int
+	}{
+		"success":      {wantStatus: http.StatusOK},
+		"invalid code": {code: func(string) string { return "not-a-code" }, wantStatus: http.StatusBadRequest},
+		"server error": {failure: &twitchauthtest.Failure{Status: http.StatusServiceUnavailable, Message: "unavailable"}, wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := useFakeServer(t)
+			s.RegisterApp("client-id", "client-secret", "http://localhost/callback")
+			s.SetUser(twitchauthtest.User{Id: "42", Login: "viewer"})
+
+			a := NewAuthorizationCodeGrantAuthenticator("client-id", "client-secret", false, "http://localhost/callback", []ScopeType{ScopeChatRead}, "state")
+			u, err := a.GenerateAuthorizationUrl()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			redirect := authorize(t, s, u)
+			if redirect.Query().Get("state") != "state" {
+				t.Errorf("redirect state = %q, want %q", redirect.Query().Get("state"), "state")
+			}
+
+			code := redirect.Query().Get("code")
+			if tt.code != nil {
+				code = tt.code(code)
+			}
+
+			if tt.failure != nil {
+				s.InjectFailure("/oauth2/token", *tt.failure)
+			}
+
+			res, err := a.GetToken(code)
+			if err != nil {
+				t.Fatalf("GetToken() error = %v", err)
+			}
+
+			checkTokenResponse(t, res, tt.wantStatus)
+			if tt.wantStatus == http.StatusOK && (res.Token.RefreshToken == "" || !res.Token.HasScopes(ScopeChatRead)) {
+				t.Errorf("GetToken() token = %+v, want a refreshable token granted chat:read", res.Token)
+			}
+		})
+	}
+}
+
+func TestAuthorizationCodeGrantAuthenticatorRefreshToken(t *testing.T) {
+	tests := map[string]struct {
+		revoke     bool
+		failure    *twitchauthtest.Failure
+		wantStatus int
+	}{
+		"success":      {wantStatus: http.StatusOK},
+		"revoked":      {revoke: true, wantStatus: http.StatusBadRequest},
+		"server error": {failure: &twitchauthtest.Failure{Status: http.StatusBadGateway, Message: "bad gateway"}, wantStatus: http.StatusBadGateway},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := useFakeServer(t)
+			s.RegisterApp("client-id", "client-secret", "http://localhost/callback")
+			issued := s.IssueToken(twitchauthtest.TokenOptions{
+				ClientId:    "client-id",
+				User:        &twitchauthtest.User{Id: "42", Login: "viewer"},
+				Scopes:      []string{"chat:read"},
+				Refreshable: true,
+			})
+
+			if tt.revoke {
+				err := s.Revoke(issued.RefreshToken)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.failure != nil {
+				s.InjectFailure("/oauth2/token", *tt.failure)
+			}
+
+			a := NewAuthorizationCodeGrantAuthenticator("client-id", "client-secret", false, "http://localhost/callback", nil, "")
+			res, err := a.RefreshToken(issued.RefreshToken)
+			if err != nil {
+				t.Fatalf("RefreshToken() error = %v", err)
+			}
+
+			checkTokenResponse(t, res, tt.wantStatus)
+			if tt.wantStatus == http.StatusOK && s.Valid(issued.AccessToken) {
+				t.Error("RefreshToken() left the replaced access token valid")
+			}
+		})
+	}
+}
+
+func TestAuthorizationCodeGrantAuthenticatorCompleteAuth(t *testing.T) {
+	tests := map[string]struct {
+		state   string
+		wantErr error
+	}{
+		"success":      {},
+		"forged state": {state: "forged", wantErr: ErrUnknownState},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := useFakeServer(t)
+			s.RegisterApp("client-id", "client-secret", "http://localhost/callback")
+
+			a, err := NewAuthorizationCodeGrantAuthenticatorWithOptions("client-id", "client-secret", "http://localhost/callback")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			session, err := a.BeginAuth(AuthOptions{Scopes: []ScopeType{ScopeChatRead}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			u, err := url.Parse(session.AuthorizationUrl)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			redirect := authorize(t, s, u)
+			if tt.state != "" {
+				q := redirect.Query()
+				q.Set("state", tt.state)
+				redirect.RawQuery = q.Encode()
+			}
+
+			// The fake checks the PKCE code verifier against the challenge sent in the authorization URL.
+			res, err := a.CompleteAuth(redirect)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CompleteAuth() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr == nil {
+				checkTokenResponse(t, res.TokenResponse, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestUpdateScopesConcurrentWithGenerateAuthorizationUrl(t *testing.T) {
+	sets := [][]ScopeType{
+		{ScopeChatRead},
+		{ScopeChatRead, ScopeChatEdit, ScopeUserReadEmail},
+	}
+	want := map[string]bool{
+		"chat:read":                           true,
+		"chat:read chat:edit user:read:email": true,
+	}
+
+	a := NewAuthorizationCodeGrantAuthenticator("abcdefghijklmnopqrstuvwxyz0123", "secret", false, "http://localhost/callback", sets[0], "")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 200; n++ {
+				// The caller's slice is modified after being handed over, which must not affect the authenticator.
+				s := append([]ScopeType(nil), sets[n%2]...)
+				a.UpdateScopes(s)
+				s[0] = ScopeUserEdit
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 200; n++ {
+				u, err := a.GenerateAuthorizationUrl()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				if scope := u.Query().Get("scope"); !want[scope] {
+					t.Errorf("GenerateAuthorizationUrl() scope = %q, want one of the supplied sets", scope)
+					return
+				}
+
+				_ = a.GetScopes()
+			}
+		}()
+	}
+
+	wg.Wait()
+}
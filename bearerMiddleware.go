@@ -0,0 +1,194 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultBearerCacheTTL is how long a successful validation is reused when no TTL is supplied via
+// WithBearerCacheTTL.
+const defaultBearerCacheTTL = time.Minute
+
+// ValidTokenFromContext retrieves the ValidTokenResponse added to ctx by BearerMiddleware.RequireBearerToken.
+func ValidTokenFromContext(ctx context.Context) (*ValidTokenResponse, bool) {
+	v, ok := ctx.Value(validTokenKey{}).(*ValidTokenResponse)
+	return v, ok
+}
+
+// validTokenKey is the context key under which RequireBearerToken stores the ValidTokenResponse.
+type validTokenKey struct{}
+
+// BearerMiddlewareOption configures a BearerMiddleware created via NewBearerMiddleware.
+type BearerMiddlewareOption func(*BearerMiddleware) error
+
+// WithBearerCacheTTL sets how long a successful validation is reused before the token is validated again. Defaults
+// to one minute. Results are never reused beyond the token's expiry. It is ignored if WithBearerValidationCache is
+// supplied.
+func WithBearerCacheTTL(ttl time.Duration) BearerMiddlewareOption {
+	return func(m *BearerMiddleware) error {
+		if ttl <= 0 {
+			return errors.New("WithBearerCacheTTL: ttl must be positive")
+		}
+
+		m.ttl = ttl
+		return nil
+	}
+}
+
+// WithBearerValidationCache sets the ValidationCache used to validate tokens, allowing it to be configured or
+// shared. By default, the BearerMiddleware creates its own.
+func WithBearerValidationCache(c *ValidationCache) BearerMiddlewareOption {
+	return func(m *BearerMiddleware) error {
+		if c == nil {
+			return errors.New("WithBearerValidationCache: cache must not be nil")
+		}
+
+		m.cache = c
+		return nil
+	}
+}
+
+/*
+BearerMiddleware authenticates requests to an app's own APIs (ex. an extension backend or overlay API) that carry a
+Twitch user access token in their Authorization header.
+
+Tokens are checked via ValidateToken, and must have been issued to the app's client ID. Results are kept in a
+ValidationCache, so that a token is not validated on every request.
+
+New instances of BearerMiddleware should be created via NewBearerMiddleware, and closed via Close once they are no
+longer needed.
+*/
+type BearerMiddleware struct {
+	clientId string
+	ttl      time.Duration
+	cache    *ValidationCache
+	// ownsCache is set if the cache was created by NewBearerMiddleware rather than supplied, so should be closed by it.
+	ownsCache bool
+}
+
+// NewBearerMiddleware generates a new BearerMiddleware instance that only accepts tokens issued to clientId.
+func NewBearerMiddleware(clientId string, opts ...BearerMiddlewareOption) (*BearerMiddleware, error) {
+	err := ValidateClientId(clientId)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &BearerMiddleware{
+		clientId: clientId,
+		ttl:      defaultBearerCacheTTL,
+	}
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+
+		err = opt(m)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if m.cache == nil {
+		m.cache, err = NewValidationCache(WithValidationCacheTTL(m.ttl))
+		if err != nil {
+			return nil, err
+		}
+		m.ownsCache = true
+	}
+
+	return m, nil
+}
+
+// Close closes the ValidationCache created by NewBearerMiddleware. A cache supplied via WithBearerValidationCache is
+// left open, as it may be shared.
+func (m *BearerMiddleware) Close() {
+	if m.ownsCache {
+		m.cache.Close()
+	}
+}
+
+/*
+RequireBearerToken wraps a handler so that it is only served to requests carrying a valid token, issued to the
+middleware's client ID, that has been granted every supplied scope. The token's ValidTokenResponse (user ID, login
+and scopes) is added to the request's context, and can be retrieved via ValidTokenFromContext.
+
+Requests without a valid token receive a 401, and those whose token is missing a scope receive a 403, each with a
+WWW-Authenticate header as described by RFC 6750. If Twitch cannot be reached, a 503 is returned.
+*/
+func (m *BearerMiddleware) RequireBearerToken(scopes ...ScopeType) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="twitch"`)
+				http.Error(w, "bearer token required", http.StatusUnauthorized)
+				return
+			}
+
+			v, err := m.validate(r.Context(), token)
+			if err != nil {
+				logWarn(r.Context(), "bearer token could not be validated", slog.Any("error", err))
+				http.Error(w, "token could not be validated", http.StatusServiceUnavailable)
+				return
+			}
+
+			if v == nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="twitch", error="invalid_token"`)
+				http.Error(w, "invalid bearer token", http.StatusUnauthorized)
+				return
+			}
+
+			t := Token{Scopes: v.Scopes}
+			if !t.HasScopes(scopes...) {
+				e := fmt.Sprintf(`Bearer realm="twitch", error="insufficient_scope", scope="%s"`, strings.Join(scopeNames(scopes), " "))
+				w.Header().Set("WWW-Authenticate", e)
+				http.Error(w, "missing required twitch scopes", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), validTokenKey{}, v)))
+		})
+	}
+}
+
+// validate retrieves the token's ValidTokenResponse via the ValidationCache. A nil ValidTokenResponse is returned if
+// the token is invalid or was issued to another client ID.
+func (m *BearerMiddleware) validate(ctx context.Context, token string) (*ValidTokenResponse, error) {
+	res, err := m.cache.ValidateToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.ValidationStatus != StatusSuccess {
+		if res.FailureData == nil || res.FailureData.Status != 401 {
+			e := fmt.Sprintf("token validation failed: %d", res.Meta.StatusCode)
+			return nil, errors.New(e)
+		}
+
+		return nil, nil
+	}
+
+	if res.ValidationData.ClientId != m.clientId {
+		return nil, nil
+	}
+
+	return res.ValidationData, nil
+}
+
+// bearerToken retrieves the token from the request's Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+
+	return token, token != ""
+}
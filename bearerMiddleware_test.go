@@ -0,0 +1,135 @@
+﻿package go_twitchAuth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBearerMiddlewareClose(t *testing.T) {
+	registered := func(c *ValidationCache) bool {
+		validationCaches.mu.Lock()
+		defer validationCaches.mu.Unlock()
+
+		_, ok := validationCaches.caches[c.validationCacheStore]
+		return ok
+	}
+
+	owned, err := NewBearerMiddleware("abcdefghijklmnopqrstuvwxyz0123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owned.Close()
+	if registered(owned.cache) {
+		t.Error("Close() left the middleware's own ValidationCache open")
+	}
+
+	shared, err := NewValidationCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(shared.Close)
+
+	m, err := NewBearerMiddleware("abcdefghijklmnopqrstuvwxyz0123", WithBearerValidationCache(shared))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Close()
+	if !registered(shared) {
+		t.Error("Close() closed a ValidationCache supplied via WithBearerValidationCache")
+	}
+}
+
+func TestRequireBearerToken(t *testing.T) {
+	const clientId = "abcdefghijklmnopqrstuvwxyz0123"
+
+	tests := map[string]struct {
+		authorization string
+		stubClientId  string
+		scopes        []ScopeType
+		wantStatus    int
+		wantChallenge string
+	}{
+		"valid": {
+			authorization: "Bearer valid-token",
+			scopes:        []ScopeType{ScopeChatRead},
+			wantStatus:    http.StatusOK,
+		},
+		"missing token": {
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: `Bearer realm="twitch"`,
+		},
+		"other scheme": {
+			authorization: "Basic valid-token",
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: `Bearer realm="twitch"`,
+		},
+		"invalid token": {
+			authorization: "Bearer invalid-token",
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: `Bearer realm="twitch", error="invalid_token"`,
+		},
+		"other client id": {
+			authorization: "Bearer valid-token",
+			stubClientId:  "other-client-id",
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: `Bearer realm="twitch", error="invalid_token"`,
+		},
+		"missing scope": {
+			authorization: "Bearer valid-token",
+			scopes:        []ScopeType{ScopeChatRead, ScopeChatEdit},
+			wantStatus:    http.StatusForbidden,
+			wantChallenge: `Bearer realm="twitch", error="insufficient_scope", scope="chat:read chat:edit"`,
+		},
+		"twitch unavailable": {
+			authorization: "Bearer unavailable-token",
+			wantStatus:    http.StatusServiceUnavailable,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			v, rc := newValidationStub(t)
+			v.clientId = clientId
+			if tt.stubClientId != "" {
+				v.clientId = tt.stubClientId
+			}
+
+			m, err := NewBearerMiddleware(clientId, WithBearerValidationCache(newStubValidationCache(t, rc)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got *ValidTokenResponse
+			h := m.RequireBearerToken(tt.scopes...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = ValidTokenFromContext(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/api", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			if challenge := w.Header().Get("WWW-Authenticate"); challenge != tt.wantChallenge {
+				t.Errorf("WWW-Authenticate = %q, want %q", challenge, tt.wantChallenge)
+			}
+
+			if (got != nil) != (tt.wantStatus == http.StatusOK) {
+				t.Fatalf("ValidTokenFromContext() = %+v with status %d", got, w.Code)
+			}
+
+			if got != nil && (got.ClientId != clientId || got.UserId != "12345") {
+				t.Errorf("ValidTokenFromContext() = %+v, want the validated token of user 12345", got)
+			}
+		})
+	}
+}
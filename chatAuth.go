@@ -0,0 +1,220 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// chatLoginFailures are the NOTICE messages sent by Twitch IRC when it rejects the PASS command.
+var chatLoginFailures = []string{
+	"Login authentication failed",
+	"Improperly formatted auth",
+}
+
+// ErrMissingChatScopes is returned by ChatAuthenticator when the user's token has not been granted the scopes needed
+// to connect to Twitch IRC.
+var ErrMissingChatScopes = errors.New("token is missing the scopes required for chat")
+
+// errChatTokenRejected is returned by ChatAuthenticator.credentials when Twitch rejects the token during validation.
+var errChatTokenRejected = errors.New("token was rejected by twitch")
+
+// ChatCredentials stores the credentials sent to Twitch IRC when connecting.
+type ChatCredentials struct {
+	// Login is the user's login name, sent via NICK.
+	Login string
+	// Pass is the user's access token, prefixed with "oauth:" and sent via PASS.
+	Pass string
+}
+
+// Commands retrieves the PASS and NICK commands that authenticate an IRC connection, in the order they should be
+// sent.
+func (c *ChatCredentials) Commands() []string {
+	return []string{"PASS " + c.Pass, "NICK " + c.Login}
+}
+
+/*
+ChatAuthenticator supplies the credentials used to connect a bot to Twitch IRC (TMI) with a managed user access
+token, ex. a UserTokenSource.
+
+Before credentials are issued for a connection, the token is checked via ValidateToken, which also supplies the
+login name sent via NICK. Validation requests are sent with the HTTP client, endpoints and logger of the source's
+authenticator if source is a UserTokenSource or AppTokenSource, and with the package-level defaults otherwise.
+Reading chat requires the chat:read or user:read:chat scope; sending messages additionally
+requires chat:edit or user:write:chat.
+
+Twitch docs: https://dev.twitch.tv/docs/chat/irc/#connecting-to-the-twitch-irc-server
+
+New instances of ChatAuthenticator should be created via NewChatAuthenticator.
+*/
+type ChatAuthenticator struct {
+	source TokenSource
+	send   bool
+	requestConfig
+
+	mu    sync.Mutex
+	token *Token
+}
+
+// NewChatAuthenticator generates a new ChatAuthenticator instance that retrieves tokens from source. If send is true,
+// tokens must also be allowed to send chat messages.
+func NewChatAuthenticator(source TokenSource, send bool) *ChatAuthenticator {
+	return &ChatAuthenticator{source: source, send: send, requestConfig: sourceRequestConfig(source)}
+}
+
+// sourceRequestConfig retrieves the requestConfig of the authenticator behind a UserTokenSource or AppTokenSource,
+// falling back to Twitch's production endpoints and the package-level http.Client for any other TokenSource.
+func sourceRequestConfig(source TokenSource) requestConfig {
+	switch s := source.(type) {
+	case *UserTokenSource:
+		if s != nil && s.authenticator != nil {
+			return s.authenticator.requestConfig
+		}
+	case *AppTokenSource:
+		if s != nil && s.authenticator != nil {
+			return s.authenticator.requestConfig
+		}
+	}
+
+	return requestConfig{endpoints: DefaultEndpoints()}
+}
+
+// Credentials retrieves the credentials for a new IRC connection, refreshing the token if it has expired, is about
+// to, or is rejected by ValidateToken. ErrMissingChatScopes is returned if the token lacks the required scopes.
+func (a *ChatAuthenticator) Credentials(ctx context.Context) (*ChatCredentials, error) {
+	t, err := a.source.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := a.credentials(ctx, t)
+	if !errors.Is(err, errChatTokenRejected) {
+		return c, err
+	}
+
+	t, err = a.source.RenewToken(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.credentials(ctx, t)
+}
+
+/*
+RenewCredentials replaces the token rejected by Twitch IRC, ex. once IsChatLoginFailure reports that a server message
+indicates a failed login, and retrieves credentials for reconnecting.
+
+The connection should be closed, as Twitch IRC disconnects after a failed login, and a new one opened using the
+returned credentials. If Credentials has not yet succeeded, the source's current token is the one replaced.
+*/
+func (a *ChatAuthenticator) RenewCredentials(ctx context.Context) (*ChatCredentials, error) {
+	a.mu.Lock()
+	stale := a.token
+	a.mu.Unlock()
+
+	if stale == nil {
+		var err error
+		stale, err = a.source.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t, err := a.source.RenewToken(ctx, stale)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.credentials(ctx, t)
+}
+
+// credentials validates the supplied token and builds its credentials. Tokens are validated for every connection, as
+// connections are infrequent and Twitch expects apps using IRC to validate their tokens regularly.
+func (a *ChatAuthenticator) credentials(ctx context.Context, t *Token) (*ChatCredentials, error) {
+	res, err := validateToken(ctx, t.AccessToken, a.requestConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.ValidationStatus != StatusSuccess {
+		// Only a 401 means the token is invalid. Other failures (ex. rate limiting) are transient, and must not cause
+		// the refresh token to be spent.
+		if res.Meta.StatusCode == http.StatusUnauthorized {
+			return nil, errChatTokenRejected
+		}
+
+		e := fmt.Sprintf("token validation failed: %d", res.Meta.StatusCode)
+		return nil, errors.New(e)
+	}
+
+	if res.ValidationData.Login == "" {
+		return nil, errors.New("chat requires a user access token")
+	}
+
+	err = checkChatScopes(res.ValidationData.Scopes, a.send)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	a.token = t
+	a.mu.Unlock()
+
+	return &ChatCredentials{Login: res.ValidationData.Login, Pass: "oauth:" + t.AccessToken}, nil
+}
+
+// checkChatScopes confirms that the supplied scopes allow reading chat and, if send is true, sending messages.
+func checkChatScopes(scopes []ScopeType, send bool) error {
+	t := Token{Scopes: scopes}
+
+	if !t.HasScopes(ScopeChatRead) && !t.HasScopes(ScopeUserReadChat) {
+		return fmt.Errorf("%w: %s or %s is required to read chat", ErrMissingChatScopes, ScopeChatRead, ScopeUserReadChat)
+	}
+
+	if send && !t.HasScopes(ScopeChatEdit) && !t.HasScopes(ScopeUserWriteChat) {
+		return fmt.Errorf("%w: %s or %s is required to send messages", ErrMissingChatScopes, ScopeChatEdit, ScopeUserWriteChat)
+	}
+
+	return nil
+}
+
+/*
+IsChatLoginFailure reports whether the supplied line received from Twitch IRC is a NOTICE from the server rejecting
+the connection's credentials, ex. ":tmi.twitch.tv NOTICE * :Login authentication failed".
+
+The line is parsed rather than searched, so chat messages quoting the notice (ex. a PRIVMSG containing "NOTICE Login
+authentication failed") are not mistaken for it.
+*/
+func IsChatLoginFailure(line string) bool {
+	line = strings.TrimRight(line, "\r\n")
+
+	// Skip IRCv3 message tags, ex. "@msg-id=... :tmi.twitch.tv NOTICE ...".
+	if strings.HasPrefix(line, "@") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+
+	prefix, rest, ok := strings.Cut(line, " ")
+	if !ok || prefix != ":tmi.twitch.tv" {
+		return false
+	}
+
+	command, params, ok := strings.Cut(rest, " ")
+	if !ok || command != "NOTICE" {
+		return false
+	}
+
+	var trailing string
+	if strings.HasPrefix(params, ":") {
+		trailing = params[1:]
+	} else if _, t, ok := strings.Cut(params, " :"); ok {
+		trailing = t
+	} else {
+		return false
+	}
+
+	return slices.Contains(chatLoginFailures, trailing)
+}
@@ -0,0 +1,134 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/adamsurek/go-twitchAuth/twitchauthtest"
+)
+
+// fakeTokenSource is a TokenSource that hands out fixed tokens and records renewals.
+type fakeTokenSource struct {
+	token   *Token
+	renewed *Token
+	renews  []*Token
+}
+
+func (s *fakeTokenSource) Token(context.Context) (*Token, error) {
+	return s.token, nil
+}
+
+func (s *fakeTokenSource) RenewToken(_ context.Context, stale *Token) (*Token, error) {
+	s.renews = append(s.renews, stale)
+	s.token = s.renewed
+	return s.renewed, nil
+}
+
+func TestIsChatLoginFailure(t *testing.T) {
+	tests := map[string]bool{
+		":tmi.twitch.tv NOTICE * :Login authentication failed":                                        true,
+		":tmi.twitch.tv NOTICE * :Login authentication failed\r\n":                                    true,
+		":tmi.twitch.tv NOTICE * :Improperly formatted auth":                                          true,
+		"@msg-id=login_failed :tmi.twitch.tv NOTICE * :Login authentication failed":                   true,
+		":tmi.twitch.tv NOTICE #channel :This room is now in slow mode.":                              false,
+		":viewer!viewer@viewer.tmi.twitch.tv PRIVMSG #channel :NOTICE * :Login authentication failed": false,
+		":viewer!viewer@viewer.tmi.twitch.tv NOTICE * :Login authentication failed":                   false,
+		":tmi.twitch.tv PRIVMSG #channel :Login authentication failed":                                false,
+		":tmi.twitch.tv NOTICE * :Login authentication failed, try again":                             false,
+		"": false,
+	}
+
+	for line, want := range tests {
+		if got := IsChatLoginFailure(line); got != want {
+			t.Errorf("IsChatLoginFailure(%q) = %t, want %t", line, got, want)
+		}
+	}
+}
+
+func TestChatAuthenticatorCredentials(t *testing.T) {
+	tests := map[string]struct {
+		failure    *twitchauthtest.Failure
+		wantErr    bool
+		wantRenews int
+	}{
+		"valid token":  {},
+		"rejected":     {failure: &twitchauthtest.Failure{Status: http.StatusUnauthorized, Message: "invalid access token"}, wantRenews: 1},
+		"rate limited": {failure: &twitchauthtest.Failure{Status: http.StatusTooManyRequests, Message: "too many requests"}, wantErr: true},
+		"server error": {failure: &twitchauthtest.Failure{Status: http.StatusServiceUnavailable, Message: "unavailable"}, wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := useFakeServer(t)
+			user := &twitchauthtest.User{Id: "1", Login: "bot"}
+			first := s.IssueToken(twitchauthtest.TokenOptions{ClientId: "client-id", User: user, Scopes: []string{"chat:read"}})
+			second := s.IssueToken(twitchauthtest.TokenOptions{ClientId: "client-id", User: user, Scopes: []string{"chat:read"}})
+
+			if tt.failure != nil {
+				s.InjectFailure("/oauth2/validate", *tt.failure)
+			}
+
+			source := &fakeTokenSource{token: &Token{AccessToken: first.AccessToken}, renewed: &Token{AccessToken: second.AccessToken}}
+			c, err := NewChatAuthenticator(source, false).Credentials(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Credentials() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if len(source.renews) != tt.wantRenews {
+				t.Errorf("RenewToken called %d times, want %d", len(source.renews), tt.wantRenews)
+			}
+
+			if err == nil && c.Login != "bot" {
+				t.Errorf("Credentials().Login = %q, want %q", c.Login, "bot")
+			}
+		})
+	}
+}
+
+func TestChatAuthenticatorRenewCredentialsBeforeCredentials(t *testing.T) {
+	s := useFakeServer(t)
+	user := &twitchauthtest.User{Id: "1", Login: "bot"}
+	first := s.IssueToken(twitchauthtest.TokenOptions{ClientId: "client-id", User: user, Scopes: []string{"chat:read"}})
+	second := s.IssueToken(twitchauthtest.TokenOptions{ClientId: "client-id", User: user, Scopes: []string{"chat:read"}})
+
+	source := &fakeTokenSource{token: &Token{AccessToken: first.AccessToken}, renewed: &Token{AccessToken: second.AccessToken}}
+	c, err := NewChatAuthenticator(source, false).RenewCredentials(context.Background())
+	if err != nil {
+		t.Fatalf("RenewCredentials() error = %v", err)
+	}
+
+	if len(source.renews) != 1 || source.renews[0] == nil || source.renews[0].AccessToken != first.AccessToken {
+		t.Fatalf("RenewToken was not called with the source's current token: %v", source.renews)
+	}
+
+	if c.Pass != "oauth:"+second.AccessToken {
+		t.Errorf("RenewCredentials().Pass = %q, want the renewed token", c.Pass)
+	}
+}
+
+func TestChatAuthenticatorUsesSourceAuthenticator(t *testing.T) {
+	// The package-level client is left pointing at Twitch, so validation only reaches the fake via the client of the
+	// source's authenticator.
+	s := twitchauthtest.NewServer()
+	t.Cleanup(s.Close)
+	s.RegisterApp("client-id", "client-secret", "http://localhost/callback")
+
+	a, err := NewAuthorizationCodeGrantAuthenticatorWithOptions("client-id", "client-secret", "http://localhost/callback", WithHTTPClient(s.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issued := s.IssueToken(twitchauthtest.TokenOptions{ClientId: "client-id", User: &twitchauthtest.User{Id: "1", Login: "bot"}, Scopes: []string{"chat:read"}})
+	token := &Token{AccessToken: issued.AccessToken, ExpiresAt: time.Now().Add(time.Hour)}
+
+	c, err := NewChatAuthenticator(NewUserTokenSource(a, token, nil), false).Credentials(context.Background())
+	if err != nil {
+		t.Fatalf("Credentials() error = %v", err)
+	}
+
+	if c.Login != "bot" || c.Pass != "oauth:"+issued.AccessToken {
+		t.Errorf("Credentials() = %+v, want the credentials of bot", c)
+	}
+}
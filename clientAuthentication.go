@@ -0,0 +1,33 @@
+﻿package go_twitchAuth
+
+import "sync/atomic"
+
+// clientAuthMethod stores the ClientAuthMethod used by every request that includes the client secret.
+var clientAuthMethod atomic.Int32
+
+// ClientAuthMethod determines how an app's client ID and secret are sent to the token endpoint.
+type ClientAuthMethod int32
+
+const (
+	// ClientAuthBody sends the client ID and secret as client_id and client_secret in the form-encoded request body.
+	// This is the method documented by Twitch, and the default.
+	ClientAuthBody ClientAuthMethod = iota
+
+	// ClientAuthBasic sends the client ID and secret using HTTP Basic authentication, as described in RFC 6749.
+	ClientAuthBasic
+)
+
+var clientAuthMethodName = map[ClientAuthMethod]string{
+	ClientAuthBody:  "body",
+	ClientAuthBasic: "basic",
+}
+
+func (m ClientAuthMethod) String() string {
+	return clientAuthMethodName[m]
+}
+
+// SetClientAuthMethod sets how the client ID and secret are sent by every authenticator that requests or refreshes
+// tokens.
+func SetClientAuthMethod(m ClientAuthMethod) {
+	clientAuthMethod.Store(int32(m))
+}
@@ -1,20 +1,19 @@
 ﻿package go_twitchAuth
 
 import (
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io"
-	"net/http"
-	"time"
+	"context"
+	"net/url"
 )
 
 /*
 ClientCredentialsGrantAuthenticator allows for the generation of an authorization URL following Twitch's
 OAuth client credentials grant flow.
 
+A ClientCredentialsGrantAuthenticator is safe for concurrent use by multiple goroutines, provided its exported
+fields are not modified once it's in use.
+
 New instances of ClientCredentialsGrantAuthenticator should be created via
-NewClientCredentialsGrantAuthenticator.
+NewClientCredentialsGrantAuthenticatorWithOptions or NewClientCredentialsGrantAuthenticator.
 
 Twitch docs: https://dev.twitch.tv/docs/authentication/getting-tokens-oauth/#client-credentials-grant-flow
 */
@@ -22,61 +21,76 @@ type ClientCredentialsGrantAuthenticator struct {
 	ClientId     string `json:"client_id"`
 	ClientSecret string `json:"client_secret"`
 	GrantType    string `json:"grant_type"`
+	requestConfig
 }
 
-// NewClientCredentialsGrantAuthenticator generates a new ClientCredentialsGrantAuthenticator instance.
+// NewClientCredentialsGrantAuthenticator generates a new ClientCredentialsGrantAuthenticator instance. Unlike
+// NewClientCredentialsGrantAuthenticatorWithOptions, the supplied values are not validated.
 func NewClientCredentialsGrantAuthenticator(clientId string, clientSecret string) *ClientCredentialsGrantAuthenticator {
-	return &ClientCredentialsGrantAuthenticator{
-		ClientId:     clientId,
-		ClientSecret: clientSecret,
-		GrantType:    "client_credentials",
-	}
+	return newClientCredentialsGrantAuthenticator(clientId, clientSecret, &authenticatorOptions{
+		requestConfig: requestConfig{endpoints: DefaultEndpoints()},
+	})
 }
 
-// GetToken retrieves a new bearer token via the Twitch Helix API.
-func (a *ClientCredentialsGrantAuthenticator) GetToken() (*TokenResponse, error) {
-	var t TokenResponse
+/*
+NewClientCredentialsGrantAuthenticatorWithOptions generates a new ClientCredentialsGrantAuthenticator instance,
+configured via WithHTTPClient, WithEndpoints and WithLogger.
 
-	req, err := http.NewRequest("POST", tokenUrl, nil)
+An error is returned if clientId is invalid, clientSecret is empty, or any option is invalid or unsupported.
+*/
+func NewClientCredentialsGrantAuthenticatorWithOptions(clientId string, clientSecret string, opts ...AuthenticatorOption) (*ClientCredentialsGrantAuthenticator, error) {
+	err := ValidateClientId(clientId)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-
-	q := req.URL.Query()
-	q.Add("client_id", a.ClientId)
-	q.Add("client_secret", a.ClientSecret)
-	q.Add("grant_type", a.GrantType)
-	req.URL.RawQuery = q.Encode()
-
-	client := http.Client{Timeout: 60 * time.Second}
-	res, err := client.Do(req)
+	err = validateClientSecret(clientSecret)
 	if err != nil {
 		return nil, err
 	}
 
-	b, err := io.ReadAll(res.Body)
+	o, err := applyOptions("ClientCredentialsGrantAuthenticator", opts, "WithHTTPClient", "WithEndpoints", "WithLogger")
 	if err != nil {
 		return nil, err
 	}
 
-	if res.StatusCode != 200 {
-		t.TokenRequestStatus = StatusFailure
-		err = json.Unmarshal(b, &t.FailureData)
-		if err != nil {
-			e := fmt.Sprintf("error while parsing failed request response: %s", err)
-			return nil, errors.New(e)
-		}
-		return &t, nil
+	return newClientCredentialsGrantAuthenticator(clientId, clientSecret, o), nil
+}
+
+// newClientCredentialsGrantAuthenticator generates a new ClientCredentialsGrantAuthenticator instance from
+// already-collected options.
+func newClientCredentialsGrantAuthenticator(clientId string, clientSecret string, o *authenticatorOptions) *ClientCredentialsGrantAuthenticator {
+	return &ClientCredentialsGrantAuthenticator{
+		ClientId:      clientId,
+		ClientSecret:  clientSecret,
+		GrantType:     "client_credentials",
+		requestConfig: o.requestConfig,
 	}
+}
+
+// GetToken retrieves a new bearer token via the Twitch Helix API.
+func (a *ClientCredentialsGrantAuthenticator) GetToken() (*TokenResponse, error) {
+	return a.GetTokenWithContext(context.Background())
+}
 
-	t.TokenRequestStatus = StatusSuccess
-	err = json.Unmarshal(b, &t.TokenData)
+// GetTokenWithContext behaves like GetToken, but stops waiting on the RateLimiter and cancels the request once ctx
+// is done.
+func (a *ClientCredentialsGrantAuthenticator) GetTokenWithContext(ctx context.Context) (*TokenResponse, error) {
+	q := url.Values{}
+	q.Add("grant_type", a.GrantType)
+
+	res, err := doRequest(ctx, a.apply(apiRequest{
+		endpoint:     EndpointToken,
+		method:       "POST",
+		url:          a.urls().TokenUrl,
+		form:         q,
+		clientId:     a.ClientId,
+		clientSecret: a.ClientSecret,
+		grantType:    a.GrantType,
+	}))
 	if err != nil {
-		e := fmt.Sprintf("error while parsing token response: %s", err)
-		return nil, errors.New(e)
+		return nil, err
 	}
 
-	return &t, nil
+	return parseTokenResponse(res, TokenKindApp, a.ClientId)
 }
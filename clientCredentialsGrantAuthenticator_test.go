@@ -0,0 +1,105 @@
+﻿package go_twitchAuth
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/adamsurek/go-twitchAuth/twitchauthtest"
+)
+
+// useFakeServer routes requests sent via the package-level http.Client to a new fake Twitch server.
+func useFakeServer(t *testing.T) *twitchauthtest.Server {
+	t.Helper()
+
+	s := twitchauthtest.NewServer()
+	SetHTTPClient(s.Client())
+	t.Cleanup(func() {
+		SetHTTPClient(nil)
+		s.Close()
+	})
+
+	return s
+}
+
+func TestClientCredentialsGrantAuthenticatorWithoutConstructor(t *testing.T) {
+	s := useFakeServer(t)
+	s.RegisterApp("client-id", "client-secret")
+
+	var decoded ClientCredentialsGrantAuthenticator
+	err := json.Unmarshal([]byte(`{"client_id":"client-id","client_secret":"client-secret","grant_type":"client_credentials"}`), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]*ClientCredentialsGrantAuthenticator{
+		"struct literal": {ClientId: "client-id", ClientSecret: "client-secret", GrantType: "client_credentials"},
+		"decoded json":   &decoded,
+	}
+
+	for name, a := range tests {
+		t.Run(name, func(t *testing.T) {
+			res, err := a.GetToken()
+			if err != nil {
+				t.Fatalf("GetToken() error = %v", err)
+			}
+
+			if res.TokenRequestStatus != StatusSuccess {
+				t.Fatalf("GetToken() status = %s, want %s", res.TokenRequestStatus, StatusSuccess)
+			}
+		})
+	}
+}
+
+func TestClientCredentialsGrantAuthenticatorGetToken(t *testing.T) {
+	tests := map[string]struct {
+		secret     string
+		failure    *twitchauthtest.Failure
+		wantStatus int
+	}{
+		"success":        {secret: "client-secret", wantStatus: http.StatusOK},
+		"invalid secret": {secret: "wrong-secret", wantStatus: http.StatusForbidden},
+		"rate limited":   {secret: "client-secret", failure: &twitchauthtest.Failure{Status: http.StatusTooManyRequests, Message: "too many requests"}, wantStatus: http.StatusTooManyRequests},
+		"server error":   {secret: "client-secret", failure: &twitchauthtest.Failure{Status: http.StatusInternalServerError, Message: "internal error"}, wantStatus: http.StatusInternalServerError},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := useFakeServer(t)
+			s.RegisterApp("client-id", "client-secret")
+			if tt.failure != nil {
+				s.InjectFailure("/oauth2/token", *tt.failure)
+			}
+
+			res, err := NewClientCredentialsGrantAuthenticator("client-id", tt.secret).GetToken()
+			if err != nil {
+				t.Fatalf("GetToken() error = %v", err)
+			}
+
+			checkTokenResponse(t, res, tt.wantStatus)
+			if tt.wantStatus == http.StatusOK && (res.Token.Kind != TokenKindApp || !s.Valid(res.Token.AccessToken)) {
+				t.Errorf("GetToken() token = %+v, want a valid app access token", res.Token)
+			}
+		})
+	}
+}
+
+// checkTokenResponse confirms that res reports the outcome of a request answered with wantStatus.
+func checkTokenResponse(t *testing.T, res *TokenResponse, wantStatus int) {
+	t.Helper()
+
+	if res.Meta.StatusCode != wantStatus {
+		t.Fatalf("status code = %d, want %d", res.Meta.StatusCode, wantStatus)
+	}
+
+	if wantStatus == http.StatusOK {
+		if res.TokenRequestStatus != StatusSuccess || res.Token == nil || res.Token.AccessToken == "" {
+			t.Fatalf("response = %+v, want a successful token response", res)
+		}
+		return
+	}
+
+	if res.TokenRequestStatus != StatusFailure || res.FailureData == nil || res.FailureData.Status != wantStatus {
+		t.Fatalf("response = %+v, want a failed token response", res)
+	}
+}
@@ -0,0 +1,498 @@
+﻿package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	ta "github.com/adamsurek/go-twitchAuth"
+)
+
+// defaultLoginTimeout is how long login waits for the user to authorize the app when -timeout is not supplied.
+const defaultLoginTimeout = 5 * time.Minute
+
+// runLogin authorizes a user and stores the resulting token in a profile.
+func runLogin(ctx context.Context, args []string) error {
+	var f commonFlags
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	f.register(fs)
+	device := fs.Bool("device", false, "use the device code flow instead of a loopback redirect")
+	scopeList := fs.String("scopes", "", "space-separated scopes to request (overrides TWITCH_SCOPES)")
+	forceVerify := fs.Bool("force-verify", false, "force the user to re-authorize the app")
+	redirectUri := fs.String("redirect-uri", "", "loopback redirect URI registered for the app (overrides TWITCH_REDIRECT_URI)")
+	timeout := fs.Duration("timeout", defaultLoginTimeout, "how long to wait for the user to authorize the app")
+	fs.Parse(args)
+
+	c, err := f.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	if *scopeList != "" {
+		c.Scopes = strings.Fields(*scopeList)
+	}
+	override(&c.RedirectUri, defaultRedirectUri, c.RedirectUri, *redirectUri)
+	c.ForceVerify = c.ForceVerify || *forceVerify
+
+	loginCtx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	var p *profile
+	if *device {
+		p, err = loginDevice(loginCtx, c)
+	} else {
+		p, err = loginLoopback(loginCtx, c)
+	}
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		e := fmt.Sprintf("timed out after %s waiting for authorization", *timeout)
+		return errors.New(e)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Token responses don't identify the user, so validate the token to fill in who authorized it.
+	v, err := ta.ValidateTokenWithContext(ctx, p.Token.AccessToken)
+	if err != nil {
+		return err
+	}
+	if v.ValidationStatus == ta.StatusSuccess {
+		p.Token.UserId = v.Token.UserId
+		p.Token.Login = v.Token.Login
+	}
+
+	path, err := saveProfile(f.profile, p)
+	if err != nil {
+		return err
+	}
+
+	return printToken(f, p.Token, path)
+}
+
+// loginDevice authorizes a user via the device code flow.
+func loginDevice(ctx context.Context, c *ta.Config) (*profile, error) {
+	a, err := c.DeviceCodeGrantAuthenticator()
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := a.RequestDeviceCodeWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if d.DeviceCodeRequestStatus != ta.StatusSuccess {
+		return nil, requestError(d.FailureData)
+	}
+
+	fmt.Fprintf(os.Stderr, "To authorize, visit %s and enter the code %s\n", d.DeviceCodeData.VerificationUri, d.DeviceCodeData.UserCode)
+
+	t, err := a.WaitForToken(ctx, d.DeviceCodeData)
+	if err != nil {
+		return nil, err
+	}
+	if t.TokenRequestStatus != ta.StatusSuccess {
+		return nil, requestError(t.FailureData)
+	}
+
+	return &profile{Flow: flowDeviceCode, Token: t.Token}, nil
+}
+
+// loginLoopback authorizes a user via the authorization code flow, receiving the redirect on a local HTTP server.
+func loginLoopback(ctx context.Context, c *ta.Config) (*profile, error) {
+	redirect, err := url.Parse(c.RedirectUri)
+	if err != nil {
+		return nil, err
+	}
+	if redirect.Scheme != "http" || redirect.Hostname() != "localhost" {
+		e := fmt.Sprintf("redirect URI must be an http://localhost loopback address, got %q", c.RedirectUri)
+		return nil, errors.New(e)
+	}
+
+	state, err := randomState()
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := c.AuthorizationCodeGrantAuthenticator(state)
+	if err != nil {
+		return nil, errors.New(err.Error() + " (or use -device)")
+	}
+
+	authUrl, err := a.GenerateAuthorizationUrl()
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", redirect.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make(chan string, 1)
+	failures := make(chan error, 1)
+
+	path := redirect.Path
+	if path == "" {
+		path = "/"
+	}
+
+	srv := &http.Server{Handler: loopbackHandler(path, state, codes, failures), ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	fmt.Fprintf(os.Stderr, "To authorize, visit:\n\n  %s\n\nWaiting for the redirect to %s ...\n", authUrl, c.RedirectUri)
+
+	var code string
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case err = <-failures:
+		return nil, err
+	case code = <-codes:
+	}
+
+	t, err := a.GetTokenWithContext(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if t.TokenRequestStatus != ta.StatusSuccess {
+		return nil, requestError(t.FailureData)
+	}
+
+	return &profile{Flow: flowAuthorizationCode, Token: t.Token}, nil
+}
+
+/*
+loopbackHandler handles the redirect to the loopback server, sending the code or failure it carries. Only requests
+to path are handled, and only the first result is kept: sends never block, so that a repeated redirect (ex. a
+browser refresh) cannot stall the server.
+
+Requests with the wrong state are rejected without ending the login, as anything able to reach the loopback address
+can send them; the login keeps waiting for the real redirect until it times out.
+*/
+func loopbackHandler(path string, state string, codes chan<- string, failures chan<- error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Browsers also request paths such as /favicon.ico, which must not end the login.
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+
+		q := r.URL.Query()
+		switch {
+		case q.Get("state") != state:
+			http.Error(w, "state mismatch", http.StatusBadRequest)
+		case q.Get("error") != "":
+			http.Error(w, "authorization failed", http.StatusBadRequest)
+			e := fmt.Sprintf("authorization failed: %s - %s", q.Get("error"), q.Get("error_description"))
+			select {
+			case failures <- errors.New(e):
+			default:
+			}
+		default:
+			fmt.Fprintln(w, "Authorization complete. You can close this window.")
+			select {
+			case codes <- q.Get("code"):
+			default:
+			}
+		}
+	})
+}
+
+// runAppToken retrieves an app access token and stores it in a profile.
+func runAppToken(ctx context.Context, args []string) error {
+	var f commonFlags
+	fs := flag.NewFlagSet("app-token", flag.ExitOnError)
+	f.register(fs)
+	fs.Parse(args)
+
+	c, err := f.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	p, err := appToken(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	path, err := saveProfile(f.profile, p)
+	if err != nil {
+		return err
+	}
+
+	return printToken(f, p.Token, path)
+}
+
+// appToken retrieves an app access token via the client credentials flow.
+func appToken(ctx context.Context, c *ta.Config) (*profile, error) {
+	a, err := c.ClientCredentialsGrantAuthenticator()
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := a.GetTokenWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if t.TokenRequestStatus != ta.StatusSuccess {
+		return nil, requestError(t.FailureData)
+	}
+
+	return &profile{Flow: flowClientCredentials, Token: t.Token}, nil
+}
+
+// runValidate validates the supplied token, or the token stored in a profile.
+func runValidate(ctx context.Context, args []string) error {
+	var f commonFlags
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	f.register(fs)
+	token := fs.String("token", "", "token to validate (default: the token stored in the profile)")
+	fs.Parse(args)
+
+	accessToken, _, err := resolveToken(f, *token)
+	if err != nil {
+		return err
+	}
+
+	v, err := ta.ValidateTokenWithContext(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+	if v.ValidationStatus != ta.StatusSuccess {
+		return requestError(v.FailureData)
+	}
+
+	return printToken(f, v.Token, "")
+}
+
+// runRevoke revokes the supplied token, or the token stored in a profile, removing the profile if it was used.
+func runRevoke(ctx context.Context, args []string) error {
+	var f commonFlags
+	fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+	f.register(fs)
+	token := fs.String("token", "", "token to revoke (default: the token stored in the profile)")
+	fs.Parse(args)
+
+	accessToken, p, err := resolveToken(f, *token)
+	if err != nil {
+		return err
+	}
+
+	// Tokens are bound to the client they were issued to.
+	if p != nil && p.Token.ClientId != "" && f.clientId == "" {
+		f.clientId = p.Token.ClientId
+	}
+
+	c, err := f.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	r, err := ta.RevokeTokenWithContext(ctx, c.ClientId, accessToken)
+	if err != nil {
+		return err
+	}
+	if r.RevocationStatus != ta.StatusSuccess {
+		return requestError(r.FailureData)
+	}
+
+	if p != nil {
+		err = deleteProfile(f.profile)
+		if err != nil {
+			return err
+		}
+	}
+
+	return printResult(f, map[string]bool{"revoked": true}, "token revoked")
+}
+
+// runRefresh refreshes the token stored in a profile using the flow it was obtained with.
+func runRefresh(ctx context.Context, args []string) error {
+	var f commonFlags
+	fs := flag.NewFlagSet("refresh", flag.ExitOnError)
+	f.register(fs)
+	fs.Parse(args)
+
+	p, err := loadProfile(f.profile)
+	if err != nil {
+		return err
+	}
+
+	// Tokens are bound to the client they were issued to.
+	if p.Token.ClientId != "" && f.clientId == "" {
+		f.clientId = p.Token.ClientId
+	}
+
+	c, err := f.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	if p.Flow == flowClientCredentials {
+		// App access tokens have no refresh token, so a new one is requested instead.
+		p, err = appToken(ctx, c)
+		if err != nil {
+			return err
+		}
+
+		path, err := saveProfile(f.profile, p)
+		if err != nil {
+			return err
+		}
+
+		return printToken(f, p.Token, path)
+	}
+
+	if p.Token.RefreshToken == "" {
+		return ta.ErrNoRefreshToken
+	}
+
+	var t *ta.TokenResponse
+	if p.Flow == flowDeviceCode {
+		t, err = ta.NewDeviceCodeGrantAuthenticator(c.ClientId, nil).RefreshTokenWithContext(ctx, p.Token.RefreshToken)
+	} else {
+		// Refreshing doesn't involve a redirect, so any configured redirect URI will do.
+		override(&c.RedirectUri, defaultRedirectUri, c.RedirectUri)
+
+		var a *ta.AuthorizationCodeGrantAuthenticator
+		a, err = c.AuthorizationCodeGrantAuthenticator("")
+		if err != nil {
+			return err
+		}
+		t, err = a.RefreshTokenWithContext(ctx, p.Token.RefreshToken)
+	}
+	if err != nil {
+		return err
+	}
+	if t.TokenRequestStatus != ta.StatusSuccess {
+		return requestError(t.FailureData)
+	}
+
+	// Refresh responses don't identify the user, so carry over what is already known.
+	t.Token.UserId = p.Token.UserId
+	t.Token.Login = p.Token.Login
+	p.Token = t.Token
+
+	path, err := saveProfile(f.profile, p)
+	if err != nil {
+		return err
+	}
+
+	return printToken(f, p.Token, path)
+}
+
+// runScopes lists every known scope, or those matching a search term.
+func runScopes(_ context.Context, args []string) error {
+	var f commonFlags
+	fs := flag.NewFlagSet("scopes", flag.ExitOnError)
+	f.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: twitch-auth scopes [-json] list | search <term>")
+	}
+	fs.Parse(args)
+
+	var term string
+	switch {
+	case fs.Arg(0) == "list" && fs.NArg() == 1:
+	case fs.Arg(0) == "search" && fs.NArg() == 2:
+		term = strings.ToLower(fs.Arg(1))
+	default:
+		fs.Usage()
+		return fmt.Errorf("%w: expected \"list\" or \"search <term>\"", errUsage)
+	}
+
+	var names []string
+	for _, s := range ta.ScopeTypes() {
+		if strings.Contains(s.String(), term) {
+			names = append(names, s.String())
+		}
+	}
+
+	return printResult(f, names, strings.Join(names, "\n"))
+}
+
+// resolveToken returns the supplied token or, if it's empty, the token stored in the profile along with the profile.
+func resolveToken(f commonFlags, token string) (string, *profile, error) {
+	if token != "" {
+		return strings.TrimPrefix(token, "oauth:"), nil, nil
+	}
+
+	p, err := loadProfile(f.profile)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return p.Token.AccessToken, p, nil
+}
+
+// printToken prints a summary of t, including the path of the profile it was saved to if any, or t itself as JSON.
+func printToken(f commonFlags, t *ta.Token, savedTo string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "kind:       %s\n", t.Kind)
+	if t.Login != "" {
+		fmt.Fprintf(&b, "user:       %s (%s)\n", t.Login, t.UserId)
+	}
+	fmt.Fprintf(&b, "client id:  %s\n", t.ClientId)
+
+	var scopes []string
+	for _, s := range t.Scopes {
+		scopes = append(scopes, s.String())
+	}
+	fmt.Fprintf(&b, "scopes:     %s\n", strings.Join(scopes, " "))
+
+	if t.NonExpiring {
+		fmt.Fprintf(&b, "expires:    never\n")
+	} else {
+		fmt.Fprintf(&b, "expires:    %s (in %s)\n", t.ExpiresAt.Format(time.RFC3339), time.Until(t.ExpiresAt).Round(time.Second))
+	}
+	if savedTo != "" {
+		fmt.Fprintf(&b, "saved to:   %s (profile %s)\n", savedTo, f.profile)
+	}
+
+	return printResult(f, t, strings.TrimSuffix(b.String(), "\n"))
+}
+
+// printResult prints v as JSON if -json was supplied, or text otherwise.
+func printResult(f commonFlags, v any, text string) error {
+	if !f.json {
+		_, err := fmt.Println(text)
+		return err
+	}
+
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "  ")
+
+	return e.Encode(v)
+}
+
+// requestError builds the error returned when Twitch rejects a request.
+func requestError(f *ta.FailedRequestResponse) error {
+	if f == nil {
+		return errors.New("request failed")
+	}
+
+	e := fmt.Sprintf("request failed: %d - %s", f.Status, f.Message)
+	return errors.New(e)
+}
+
+// randomState generates an unguessable state value for the authorization URL.
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,132 @@
+﻿package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	ta "github.com/adamsurek/go-twitchAuth"
+)
+
+func TestLoopbackHandler(t *testing.T) {
+	codes := make(chan string, 1)
+	failures := make(chan error, 1)
+	h := loopbackHandler("/callback", "state", codes, failures)
+
+	requests := []struct {
+		target     string
+		wantStatus int
+	}{
+		{"/favicon.ico", http.StatusNotFound},
+		{"/callback?code=first&state=state", http.StatusOK},
+		// A repeated redirect must not block once the first code has been sent.
+		{"/callback?code=second&state=state", http.StatusOK},
+		// Requests with the wrong state are rejected without ending the login.
+		{"/callback?code=third&state=forged", http.StatusBadRequest},
+		{"/callback?error=access_denied&state=forged", http.StatusBadRequest},
+		{"/callback?error=access_denied&state=state", http.StatusBadRequest},
+	}
+
+	for _, r := range requests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, r.target, nil))
+		if w.Code != r.wantStatus {
+			t.Errorf("GET %s status = %d, want %d", r.target, w.Code, r.wantStatus)
+		}
+	}
+
+	if code := <-codes; code != "first" {
+		t.Errorf("code = %q, want %q", code, "first")
+	}
+
+	if err := <-failures; err == nil || !strings.Contains(err.Error(), "access_denied") {
+		t.Errorf("failure = %v, want the access_denied error", err)
+	}
+}
+
+func TestLoginLoopbackWaitsPastForgedState(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	_, port, _ := net.SplitHostPort(addr)
+	c := &ta.Config{
+		ClientId:     "abcdefghijklmnopqrstuvwxyz0123",
+		ClientSecret: "client-secret",
+		RedirectUri:  "http://localhost:" + port + "/callback",
+		Scopes:       []string{"chat:read"},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := loginLoopback(ctx, c)
+		done <- err
+	}()
+
+	// Send a forged redirect once the loopback server is listening.
+	var status int
+	for status == 0 && ctx.Err() == nil {
+		res, err := http.Get(c.RedirectUri + "?code=forged&state=forged")
+		if err != nil {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		res.Body.Close()
+		status = res.StatusCode
+	}
+
+	if status != http.StatusBadRequest {
+		t.Errorf("forged redirect status = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("loginLoopback() error = %v, want it to wait for the real redirect until %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestPrintTokenShowsProfilePath(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	t.Cleanup(func() { os.Stdout = stdout })
+
+	tok := &ta.Token{Kind: ta.TokenKindApp, ClientId: "client-id", ExpiresAt: time.Now().Add(time.Hour)}
+	err = printToken(commonFlags{profile: "app"}, tok, "/home/user/.config/twitch-auth/profiles/app.json")
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(out), "saved to:   /home/user/.config/twitch-auth/profiles/app.json (profile app)") {
+		t.Errorf("printToken() output = %q, want the path the token was saved to", out)
+	}
+}
+
+func TestRunScopesUsageError(t *testing.T) {
+	err := runScopes(context.Background(), []string{"bogus"})
+	if !errors.Is(err, errUsage) {
+		t.Fatalf("runScopes() error = %v, want %v", err, errUsage)
+	}
+}
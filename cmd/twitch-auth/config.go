@@ -0,0 +1,84 @@
+﻿package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+
+	ta "github.com/adamsurek/go-twitchAuth"
+	// Allow -config to name a YAML or TOML file.
+	_ "github.com/adamsurek/go-twitchAuth/twitchauthconfig"
+)
+
+// defaultRedirectUri is used by login when no redirect URI has been configured.
+const defaultRedirectUri = "http://localhost:3000/callback"
+
+// commonFlags stores the flags shared by every command.
+type commonFlags struct {
+	json         bool
+	profile      string
+	configPath   string
+	clientId     string
+	clientSecret string
+}
+
+// register adds the common flags to fs.
+func (f *commonFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&f.json, "json", false, "print machine-readable JSON output")
+	fs.StringVar(&f.profile, "profile", "default", "name of the profile tokens are stored in")
+	fs.StringVar(&f.configPath, "config", "", "path to a JSON, YAML or TOML config file (default: twitch-auth/config.json in the user config directory)")
+	fs.StringVar(&f.clientId, "client-id", "", "client ID of the Twitch app (overrides TWITCH_CLIENT_ID)")
+	fs.StringVar(&f.clientSecret, "client-secret", "", "client secret of the Twitch app (overrides TWITCH_CLIENT_SECRET)")
+}
+
+// loadConfig loads the config file and environment variables, with flags taking precedence over both.
+func (f *commonFlags) loadConfig() (*ta.Config, error) {
+	path := f.configPath
+	if path == "" {
+		dir, err := configDir()
+		if err != nil {
+			return nil, err
+		}
+
+		// The default config file is optional.
+		path = filepath.Join(dir, "config.json")
+		if _, err = os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			path = ""
+		}
+	}
+
+	c, err := ta.ReadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	override(&c.ClientId, f.clientId)
+	override(&c.ClientSecret, f.clientSecret)
+
+	err = c.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// override replaces *dst with the last non-empty value.
+func override(dst *string, values ...string) {
+	for _, v := range values {
+		if v != "" {
+			*dst = v
+		}
+	}
+}
+
+// configDir returns the directory twitch-auth stores its config and profiles in.
+func configDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "twitch-auth"), nil
+}
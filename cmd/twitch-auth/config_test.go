@@ -0,0 +1,76 @@
+﻿package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	ta "github.com/adamsurek/go-twitchAuth"
+)
+
+func TestLoadConfigFlagsTakePrecedence(t *testing.T) {
+	const (
+		fileClientId = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+		envClientId  = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+		flagClientId = "cccccccccccccccccccccccccccccc"
+	)
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{"client_id":"`+fileClientId+`","client_secret":"file-secret"}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		env          string
+		flags        commonFlags
+		wantClientId string
+		wantSecret   string
+	}{
+		"file":        {flags: commonFlags{configPath: path}, wantClientId: fileClientId, wantSecret: "file-secret"},
+		"environment": {env: envClientId, flags: commonFlags{configPath: path}, wantClientId: envClientId, wantSecret: "file-secret"},
+		"flags": {
+			env:          envClientId,
+			flags:        commonFlags{configPath: path, clientId: flagClientId, clientSecret: "flag-secret"},
+			wantClientId: flagClientId,
+			wantSecret:   "flag-secret",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(ta.EnvClientId, tt.env)
+			t.Setenv(ta.EnvClientSecret, "")
+
+			c, err := tt.flags.loadConfig()
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+
+			if c.ClientId != tt.wantClientId || c.ClientSecret != tt.wantSecret {
+				t.Errorf("loadConfig() = %q/%q, want %q/%q", c.ClientId, c.ClientSecret, tt.wantClientId, tt.wantSecret)
+			}
+
+			// Flags must not leak into the process environment.
+			if got := os.Getenv(ta.EnvClientId); got != tt.env {
+				t.Errorf("%s = %q after loadConfig(), want %q", ta.EnvClientId, got, tt.env)
+			}
+		})
+	}
+}
+
+func TestLoadConfigClientIdFromFlagOnly(t *testing.T) {
+	t.Setenv(ta.EnvClientId, "")
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := commonFlags{configPath: path, clientId: "cccccccccccccccccccccccccccccc"}
+	_, err = f.loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+﻿/*
+Command twitch-auth obtains and inspects Twitch OAuth tokens.
+
+Usage:
+
+	twitch-auth <command> [flags]
+
+Commands:
+
+	login      authorize as a user via the authorization code flow (loopback server) or the device code flow
+	app-token  retrieve an app access token via the client credentials flow
+	validate   validate a token
+	revoke     revoke a token and remove it from its profile
+	refresh    refresh the user access token stored in a profile
+	scopes     list or search the scopes known to go_twitchAuth
+
+Credentials are read from flags, then the TWITCH_CLIENT_ID, TWITCH_CLIENT_SECRET, TWITCH_REDIRECT_URI and
+TWITCH_SCOPES environment variables, then the JSON, YAML or TOML config file supplied via -config (by default
+twitch-auth/config.json in the user's config directory).
+
+Tokens are stored in named profiles under twitch-auth/profiles in the user's config directory. Every command
+accepts -json to print machine-readable output.
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+)
+
+// errUsage is wrapped by errors returned for invalid command-line arguments, which exit with status 2.
+var errUsage = errors.New("invalid arguments")
+
+// command is a single twitch-auth subcommand.
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"login", "authorize as a user and store the token in a profile", runLogin},
+	{"app-token", "retrieve an app access token and store it in a profile", runAppToken},
+	{"validate", "validate a token", runValidate},
+	{"revoke", "revoke a token and remove it from its profile", runRevoke},
+	{"refresh", "refresh the user access token stored in a profile", runRefresh},
+	{"scopes", "list or search scopes (scopes list | scopes search <term>)", runScopes},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			if err := c.run(ctx, os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "twitch-auth %s: %s\n", c.name, err)
+				if errors.Is(err, errUsage) {
+					os.Exit(2)
+				}
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: twitch-auth <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.usage)
+	}
+}
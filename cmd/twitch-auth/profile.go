@@ -0,0 +1,101 @@
+﻿package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ta "github.com/adamsurek/go-twitchAuth"
+)
+
+// Flows recorded in a profile, used to determine how its token is refreshed.
+const (
+	flowAuthorizationCode = "authorization_code"
+	flowDeviceCode        = "device_code"
+	flowClientCredentials = "client_credentials"
+)
+
+// profile is a token stored on disk.
+type profile struct {
+	Flow  string    `json:"flow"`
+	Token *ta.Token `json:"token"`
+}
+
+// profilePath returns the path of the named profile.
+func profilePath(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		e := fmt.Sprintf("invalid profile name: %q", name)
+		return "", errors.New(e)
+	}
+
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "profiles", name+".json"), nil
+}
+
+// loadProfile reads the named profile from disk.
+func loadProfile(name string) (*profile, error) {
+	path, err := profilePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		e := fmt.Sprintf("profile %q does not exist; run login or app-token first", name)
+		return nil, errors.New(e)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var p profile
+	err = json.Unmarshal(b, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// saveProfile writes the named profile to disk, readable only by the current user, returning the path it was
+// written to.
+func saveProfile(name string, p *profile) (string, error) {
+	path, err := profilePath(name)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return path, os.WriteFile(path, append(b, '\n'), 0o600)
+}
+
+// deleteProfile removes the named profile from disk.
+func deleteProfile(name string) error {
+	path, err := profilePath(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,340 @@
+﻿package go_twitchAuth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Environment variables read by LoadConfigEnv and LoadConfig.
+const (
+	EnvClientId     = "TWITCH_CLIENT_ID"
+	EnvClientSecret = "TWITCH_CLIENT_SECRET"
+	EnvRedirectUri  = "TWITCH_REDIRECT_URI"
+	EnvScopes       = "TWITCH_SCOPES"
+	EnvForceVerify  = "TWITCH_FORCE_VERIFY"
+)
+
+// ConfigDecoder parses the contents of a config file into c. Fields that Config does not define should be rejected.
+type ConfigDecoder func(b []byte, c *Config) error
+
+// configDecoders stores the ConfigDecoder registered for each config file extension. JSON is always supported.
+var configDecoders = struct {
+	mu       sync.RWMutex
+	decoders map[string]ConfigDecoder
+}{decoders: map[string]ConfigDecoder{".json": decodeJsonConfig}}
+
+/*
+RegisterConfigFormat makes config files with the supplied extension (ex. ".yaml") loadable via LoadConfig,
+LoadConfigFile and ReadConfig. Extensions are matched case-insensitively, and registering one again replaces its
+ConfigDecoder.
+
+Only JSON is supported by default, so that the package does not depend on any YAML or TOML library. Importing the
+twitchauthconfig package registers YAML (.yaml and .yml) and TOML (.toml) support:
+
+	import _ "github.com/adamsurek/go-twitchAuth/twitchauthconfig"
+*/
+func RegisterConfigFormat(ext string, d ConfigDecoder) {
+	if d == nil {
+		panic("RegisterConfigFormat: decoder must not be nil")
+	}
+
+	configDecoders.mu.Lock()
+	defer configDecoders.mu.Unlock()
+
+	configDecoders.decoders[strings.ToLower(ext)] = d
+}
+
+// configDecoder retrieves the ConfigDecoder registered for the supplied extension.
+func configDecoder(ext string) (ConfigDecoder, error) {
+	configDecoders.mu.RLock()
+	defer configDecoders.mu.RUnlock()
+
+	if d, ok := configDecoders.decoders[strings.ToLower(ext)]; ok {
+		return d, nil
+	}
+
+	exts := make([]string, 0, len(configDecoders.decoders))
+	for e := range configDecoders.decoders {
+		exts = append(exts, e)
+	}
+	slices.Sort(exts)
+
+	e := fmt.Sprintf("unsupported config file extension %q: expected one of %s (YAML and TOML require importing "+
+		"the twitchauthconfig package)", ext, strings.Join(exts, ", "))
+	return nil, errors.New(e)
+}
+
+// decodeJsonConfig is the ConfigDecoder for JSON files.
+func decodeJsonConfig(b []byte, c *Config) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+
+	return d.Decode(c)
+}
+
+/*
+Config stores the values required to build any of the authenticators, allowing them to be loaded from environment
+variables or a JSON file (or a YAML or TOML file, see RegisterConfigFormat) rather than passed positionally.
+
+Scopes are Twitch scope strings (ex. "user:read:chat"). In files they are a list; in TWITCH_SCOPES they are
+separated by spaces or commas.
+
+Configs should be loaded via LoadConfig, LoadConfigFile or LoadConfigEnv, which validate the loaded values, or via
+ReadConfig followed by Validate.
+*/
+type Config struct {
+	ClientId     string `json:"client_id" yaml:"client_id" toml:"client_id"`
+	ClientSecret string `json:"client_secret" yaml:"client_secret" toml:"client_secret"`
+	RedirectUri  string `json:"redirect_uri" yaml:"redirect_uri" toml:"redirect_uri"`
+	// RedirectUris lists every redirect URI registered for the app. If set, RedirectUri must be one of them, and
+	// they are supplied to authenticators via WithRegisteredRedirectUris.
+	RedirectUris []string `json:"redirect_uris" yaml:"redirect_uris" toml:"redirect_uris"`
+	Scopes       []string `json:"scopes" yaml:"scopes" toml:"scopes"`
+	ForceVerify  bool     `json:"force_verify" yaml:"force_verify" toml:"force_verify"`
+}
+
+// LoadConfig loads a Config from the file at path, if path is not empty, then overrides its values with any
+// environment variables that are set.
+func LoadConfig(path string) (*Config, error) {
+	c, err := ReadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ReadConfig behaves like LoadConfig, but does not validate the loaded values. This allows them to be overridden
+// (ex. by command-line flags) before Validate is called.
+func ReadConfig(path string) (*Config, error) {
+	c := &Config{}
+
+	if path != "" {
+		var err error
+		c, err = readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := c.applyEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// LoadConfigFile loads a Config from a JSON file, or a file of any format added via RegisterConfigFormat. The format
+// is determined by the file extension.
+func LoadConfigFile(path string) (*Config, error) {
+	c, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// LoadConfigEnv loads a Config from the TWITCH_CLIENT_ID, TWITCH_CLIENT_SECRET, TWITCH_REDIRECT_URI, TWITCH_SCOPES
+// and TWITCH_FORCE_VERIFY environment variables.
+func LoadConfigEnv() (*Config, error) {
+	return LoadConfig("")
+}
+
+// readConfigFile parses the file at path without validating it.
+func readConfigFile(path string) (*Config, error) {
+	decode, err := configDecoder(filepath.Ext(path))
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Config
+	err = decode(b, &c)
+	if err != nil {
+		e := fmt.Sprintf("error while parsing config file %s: %s", path, err)
+		return nil, errors.New(e)
+	}
+
+	return &c, nil
+}
+
+// applyEnv overrides the Config's values with any environment variables that are set.
+func (c *Config) applyEnv() error {
+	if v := os.Getenv(EnvClientId); v != "" {
+		c.ClientId = v
+	}
+
+	if v := os.Getenv(EnvClientSecret); v != "" {
+		c.ClientSecret = v
+	}
+
+	if v := os.Getenv(EnvRedirectUri); v != "" {
+		c.RedirectUri = v
+	}
+
+	if v := os.Getenv(EnvScopes); v != "" {
+		c.Scopes = strings.FieldsFunc(v, func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+	}
+
+	if v := os.Getenv(EnvForceVerify); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			e := fmt.Sprintf("invalid %s: %q", EnvForceVerify, v)
+			return errors.New(e)
+		}
+		c.ForceVerify = b
+	}
+
+	return nil
+}
+
+// Validate confirms that the Config has a valid client ID, that its redirect URIs (if any) follow Twitch's rules
+// (see ValidateRedirectUri) and that every scope is known. Secrets and redirect URIs required by a particular flow
+// are checked when its authenticator is built.
+func (c *Config) Validate() error {
+	if c.ClientId == "" {
+		return errors.New("config is missing a client ID")
+	}
+
+	err := ValidateClientId(c.ClientId)
+	if err != nil {
+		return fmt.Errorf("config has an invalid client ID: %w", err)
+	}
+
+	for _, u := range c.RedirectUris {
+		err = ValidateRedirectUri(u)
+		if err != nil {
+			return fmt.Errorf("config has an invalid redirect URI: %w", err)
+		}
+	}
+
+	if c.RedirectUri != "" {
+		err = validateRegisteredRedirectUri(c.RedirectUri, c.RedirectUris)
+		if err != nil {
+			return fmt.Errorf("config has an invalid redirect URI: %w", err)
+		}
+	}
+
+	_, err = c.ScopeTypes()
+	return err
+}
+
+// ScopeTypes translates the Config's scope strings to ScopeType values.
+func (c *Config) ScopeTypes() ([]ScopeType, error) {
+	scopes := make([]ScopeType, 0, len(c.Scopes))
+	for _, s := range c.Scopes {
+		t, err := ParseScopeType(s)
+		if err != nil {
+			e := fmt.Sprintf("config has an invalid scope: %s", err)
+			return nil, errors.New(e)
+		}
+		scopes = append(scopes, t)
+	}
+
+	return scopes, nil
+}
+
+// AuthorizationCodeGrantAuthenticator builds an AuthorizationCodeGrantAuthenticator from the Config. A client
+// secret and redirect URI are required. Any supplied options are applied after those derived from the Config.
+func (c *Config) AuthorizationCodeGrantAuthenticator(state string, opts ...AuthenticatorOption) (*AuthorizationCodeGrantAuthenticator, error) {
+	scopes, err := c.validateFor("authorization code", true, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewAuthorizationCodeGrantAuthenticatorWithOptions(c.ClientId, c.ClientSecret, c.RedirectUri, append(c.options(scopes, state), opts...)...)
+}
+
+// ClientCredentialsGrantAuthenticator builds a ClientCredentialsGrantAuthenticator from the Config. A client secret
+// is required. Any supplied options are applied as well.
+func (c *Config) ClientCredentialsGrantAuthenticator(opts ...AuthenticatorOption) (*ClientCredentialsGrantAuthenticator, error) {
+	_, err := c.validateFor("client credentials", true, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClientCredentialsGrantAuthenticatorWithOptions(c.ClientId, c.ClientSecret, opts...)
+}
+
+// ImplicitGrantAuthenticator builds an ImplicitGrantAuthenticator from the Config. A redirect URI is required. Any
+// supplied options are applied after those derived from the Config.
+func (c *Config) ImplicitGrantAuthenticator(state string, opts ...AuthenticatorOption) (*ImplicitGrantAuthenticator, error) {
+	scopes, err := c.validateFor("implicit", false, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewImplicitGrantAuthenticatorWithOptions(c.ClientId, c.RedirectUri, append(c.options(scopes, state), opts...)...)
+}
+
+// DeviceCodeGrantAuthenticator builds a DeviceCodeGrantAuthenticator from the Config. Any supplied options are
+// applied after those derived from the Config.
+func (c *Config) DeviceCodeGrantAuthenticator(opts ...AuthenticatorOption) (*DeviceCodeGrantAuthenticator, error) {
+	scopes, err := c.validateFor("device code", false, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDeviceCodeGrantAuthenticatorWithOptions(c.ClientId, append([]AuthenticatorOption{WithScopes(scopes...)}, opts...)...)
+}
+
+// options builds the AuthenticatorOption list derived from the Config for flows that redirect the user.
+func (c *Config) options(scopes []ScopeType, state string) []AuthenticatorOption {
+	opts := []AuthenticatorOption{
+		WithForceVerify(c.ForceVerify),
+		WithScopes(scopes...),
+		WithState(state),
+	}
+
+	if len(c.RedirectUris) > 0 {
+		opts = append(opts, WithRegisteredRedirectUris(c.RedirectUris...))
+	}
+
+	return opts
+}
+
+// validateFor validates the Config for the named flow, returning its scopes.
+func (c *Config) validateFor(flow string, needSecret bool, needRedirect bool) ([]ScopeType, error) {
+	err := c.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if needSecret && c.ClientSecret == "" {
+		e := fmt.Sprintf("config is missing a client secret, which the %s grant flow requires", flow)
+		return nil, errors.New(e)
+	}
+
+	if needRedirect && c.RedirectUri == "" {
+		e := fmt.Sprintf("config is missing a redirect URI, which the %s grant flow requires", flow)
+		return nil, errors.New(e)
+	}
+
+	return c.ScopeTypes()
+}
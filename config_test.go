@@ -0,0 +1,219 @@
+﻿package go_twitchAuth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeConfigFile writes a config file with the supplied name and contents to a temporary directory.
+func writeConfigFile(t *testing.T, name string, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(contents), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// clearConfigEnv unsets every environment variable read by ReadConfig for the duration of the test.
+func clearConfigEnv(t *testing.T) {
+	for _, k := range []string{EnvClientId, EnvClientSecret, EnvRedirectUri, EnvScopes, EnvForceVerify} {
+		t.Setenv(k, "")
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	clearConfigEnv(t)
+
+	// Registered for this test only, standing in for the formats added by twitchauthconfig.
+	RegisterConfigFormat(".TEST", func(b []byte, c *Config) error {
+		id, ok := strings.CutPrefix(string(b), "client_id=")
+		if !ok {
+			return errors.New("expected client_id=")
+		}
+
+		c.ClientId = id
+		return nil
+	})
+	t.Cleanup(func() {
+		configDecoders.mu.Lock()
+		delete(configDecoders.decoders, ".test")
+		configDecoders.mu.Unlock()
+	})
+
+	tests := map[string]struct {
+		name     string
+		contents string
+		want     Config
+		wantErr  string
+	}{
+		"json": {
+			name: "config.json",
+			contents: `{"client_id":"abcdefghijklmnopqrstuvwxyz0123","client_secret":"secret","redirect_uri":"http://localhost/callback",
+				"redirect_uris":["http://localhost/callback"],"scopes":["chat:read","user:read:chat"],"force_verify":true}`,
+			want: Config{
+				ClientId:     "abcdefghijklmnopqrstuvwxyz0123",
+				ClientSecret: "secret",
+				RedirectUri:  "http://localhost/callback",
+				RedirectUris: []string{"http://localhost/callback"},
+				Scopes:       []string{"chat:read", "user:read:chat"},
+				ForceVerify:  true,
+			},
+		},
+		"json extension is case insensitive": {
+			name:     "config.JSON",
+			contents: `{"client_id":"abcdefghijklmnopqrstuvwxyz0123"}`,
+			want:     Config{ClientId: "abcdefghijklmnopqrstuvwxyz0123"},
+		},
+		"registered format": {
+			name:     "config.test",
+			contents: "client_id=abcdefghijklmnopqrstuvwxyz0123",
+			want:     Config{ClientId: "abcdefghijklmnopqrstuvwxyz0123"},
+		},
+		"json unknown field":       {name: "config.json", contents: `{"client_id":"abcdefghijklmnopqrstuvwxyz0123","scope":[]}`, wantErr: "unknown field"},
+		"json malformed":           {name: "config.json", contents: `{"client_id":`, wantErr: "error while parsing config file"},
+		"registered format failed": {name: "config.test", contents: "id=abc", wantErr: "expected client_id="},
+		"yaml without twitchauthconfig": {
+			name:     "config.yaml",
+			contents: "client_id: abcdefghijklmnopqrstuvwxyz0123",
+			wantErr:  "twitchauthconfig",
+		},
+		"unknown extension": {name: "config.ini", contents: "client_id=abc", wantErr: `unsupported config file extension ".ini"`},
+		"invalid values":    {name: "config.json", contents: `{"client_id":"abcdefghijklmnopqrstuvwxyz0123","scopes":["chat:shout"]}`, wantErr: "invalid scope"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfigFile(t, tt.name, tt.contents)
+
+			for fn, load := range map[string]func(string) (*Config, error){"LoadConfigFile": LoadConfigFile, "LoadConfig": LoadConfig} {
+				c, err := load(path)
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Errorf("%s() error = %v, want an error containing %q", fn, err, tt.wantErr)
+					}
+					continue
+				}
+
+				if err != nil {
+					t.Fatalf("%s() error = %v", fn, err)
+				}
+
+				if !configsEqual(*c, tt.want) {
+					t.Errorf("%s() = %+v, want %+v", fn, *c, tt.want)
+				}
+			}
+		})
+	}
+
+	_, err := LoadConfigFile(filepath.Join(t.TempDir(), "missing.json"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadConfigFile() of a missing file error = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestLoadConfigEnvironment(t *testing.T) {
+	path := writeConfigFile(t, "config.json", `{"client_id":"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaa","client_secret":"file-secret",
+		"redirect_uri":"http://localhost/callback","scopes":["chat:read"]}`)
+
+	tests := map[string]struct {
+		path    string
+		env     map[string]string
+		want    Config
+		wantErr bool
+	}{
+		"file only": {
+			path: path,
+			want: Config{ClientId: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", ClientSecret: "file-secret", RedirectUri: "http://localhost/callback", Scopes: []string{"chat:read"}},
+		},
+		"environment overrides file": {
+			path: path,
+			env: map[string]string{
+				EnvClientId:    "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+				EnvRedirectUri: "https://example.com/callback",
+				EnvScopes:      "chat:edit, user:read:chat",
+				EnvForceVerify: "true",
+			},
+			want: Config{
+				ClientId:     "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+				ClientSecret: "file-secret",
+				RedirectUri:  "https://example.com/callback",
+				Scopes:       []string{"chat:edit", "user:read:chat"},
+				ForceVerify:  true,
+			},
+		},
+		"environment only": {
+			env:  map[string]string{EnvClientId: "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", EnvScopes: "chat:read chat:edit"},
+			want: Config{ClientId: "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", Scopes: []string{"chat:read", "chat:edit"}},
+		},
+		"invalid force verify": {path: path, env: map[string]string{EnvForceVerify: "sometimes"}, wantErr: true},
+		"invalid scope":        {path: path, env: map[string]string{EnvScopes: "chat:read,chat:shout"}, wantErr: true},
+		"missing client id":    {wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			c, err := LoadConfig(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if err == nil && !configsEqual(*c, tt.want) {
+				t.Errorf("LoadConfig() = %+v, want %+v", *c, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	const clientId = "abcdefghijklmnopqrstuvwxyz0123"
+
+	tests := map[string]struct {
+		config  Config
+		wantErr bool
+		// want is the sentinel wrapped by the error, if any.
+		want error
+	}{
+		"valid": {config: Config{ClientId: clientId, RedirectUri: "https://example.com/callback", Scopes: []string{"chat:read"}}},
+		"registered redirect": {
+			config: Config{ClientId: clientId, RedirectUri: "http://localhost/b", RedirectUris: []string{"http://localhost/a", "http://localhost/b"}},
+		},
+		"missing client id":     {config: Config{}, wantErr: true},
+		"client id with space":  {config: Config{ClientId: "abc def"}, wantErr: true, want: ErrInvalidClientId},
+		"http redirect":         {config: Config{ClientId: clientId, RedirectUri: "http://example.com/callback"}, wantErr: true, want: ErrInvalidRedirectUri},
+		"invalid registered":    {config: Config{ClientId: clientId, RedirectUris: []string{"https://example.com/#fragment"}}, wantErr: true, want: ErrInvalidRedirectUri},
+		"unregistered redirect": {config: Config{ClientId: clientId, RedirectUri: "http://localhost/c", RedirectUris: []string{"http://localhost/a"}}, wantErr: true, want: ErrRedirectUriNotRegistered},
+		"unknown scope":         {config: Config{ClientId: clientId, Scopes: []string{"chat:shout"}}, wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("Validate() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// configsEqual reports whether two Configs hold the same values.
+func configsEqual(a Config, b Config) bool {
+	return a.ClientId == b.ClientId && a.ClientSecret == b.ClientSecret && a.RedirectUri == b.RedirectUri &&
+		a.ForceVerify == b.ForceVerify && slices.Equal(a.RedirectUris, b.RedirectUris) && slices.Equal(a.Scopes, b.Scopes)
+}
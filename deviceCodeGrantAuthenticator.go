@@ -0,0 +1,260 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrDeviceCodeExpired is returned by WaitForToken when the device code expires before the user authorizes the app.
+var ErrDeviceCodeExpired = errors.New("device code expired before the user authorized the app")
+
+/*
+DeviceCodeGrantAuthenticator allows for the retrieval of a user access token following Twitch's OAuth device code
+grant flow. The flow is intended for apps that cannot host a redirect URI, such as command-line tools, and does not
+require a client secret.
+
+A DeviceCodeGrantAuthenticator is safe for concurrent use by multiple goroutines.
+
+New instances of DeviceCodeGrantAuthenticator should be created via NewDeviceCodeGrantAuthenticatorWithOptions or
+NewDeviceCodeGrantAuthenticator.
+
+Twitch docs: https://dev.twitch.tv/docs/authentication/getting-tokens-oauth/#device-code-grant-flow
+*/
+type DeviceCodeGrantAuthenticator struct {
+	requestedScopes scopeSnapshot
+	clientId        string
+	grantType       string
+	requestConfig
+}
+
+// DeviceCodeResponse stores the results of a device code request.
+type DeviceCodeResponse struct {
+	DeviceCodeRequestStatus responseStatus
+	DeviceCodeData          *DeviceCodeRequestResponse
+	FailureData             *FailedRequestResponse
+	Meta                    *ResponseMeta
+}
+
+// DeviceCodeRequestResponse stores the parsed JSON response of a device code request. The user must visit
+// VerificationUri and enter UserCode to authorize the app.
+type DeviceCodeRequestResponse struct {
+	DeviceCode      string `json:"device_code"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+	UserCode        string `json:"user_code"`
+	VerificationUri string `json:"verification_uri"`
+}
+
+// NewDeviceCodeGrantAuthenticator generates a new DeviceCodeGrantAuthenticator instance. Unlike
+// NewDeviceCodeGrantAuthenticatorWithOptions, the supplied values are not validated.
+func NewDeviceCodeGrantAuthenticator(clientId string, scopes []ScopeType) *DeviceCodeGrantAuthenticator {
+	return newDeviceCodeGrantAuthenticator(clientId, &authenticatorOptions{
+		scopes:        scopes,
+		requestConfig: requestConfig{endpoints: DefaultEndpoints()},
+	})
+}
+
+/*
+NewDeviceCodeGrantAuthenticatorWithOptions generates a new DeviceCodeGrantAuthenticator instance, configured via
+WithScopes, WithHTTPClient, WithEndpoints and WithLogger.
+
+An error is returned if clientId is invalid, or any option is invalid or unsupported.
+*/
+func NewDeviceCodeGrantAuthenticatorWithOptions(clientId string, opts ...AuthenticatorOption) (*DeviceCodeGrantAuthenticator, error) {
+	err := ValidateClientId(clientId)
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := applyOptions("DeviceCodeGrantAuthenticator", opts, "WithScopes", "WithHTTPClient", "WithEndpoints", "WithLogger")
+	if err != nil {
+		return nil, err
+	}
+
+	return newDeviceCodeGrantAuthenticator(clientId, o), nil
+}
+
+// newDeviceCodeGrantAuthenticator generates a new DeviceCodeGrantAuthenticator instance from already-collected
+// options.
+func newDeviceCodeGrantAuthenticator(clientId string, o *authenticatorOptions) *DeviceCodeGrantAuthenticator {
+	a := &DeviceCodeGrantAuthenticator{
+		clientId:      clientId,
+		grantType:     "urn:ietf:params:oauth:grant-type:device_code",
+		requestConfig: o.requestConfig,
+	}
+	a.requestedScopes.store(o.scopes)
+
+	return a
+}
+
+// RequestDeviceCode starts the device code grant flow, returning the code that the user must enter to authorize
+// the app.
+func (a *DeviceCodeGrantAuthenticator) RequestDeviceCode() (*DeviceCodeResponse, error) {
+	return a.RequestDeviceCodeWithContext(context.Background())
+}
+
+// RequestDeviceCodeWithContext behaves like RequestDeviceCode, but stops waiting on the RateLimiter and cancels the
+// request once ctx is done.
+func (a *DeviceCodeGrantAuthenticator) RequestDeviceCodeWithContext(ctx context.Context) (*DeviceCodeResponse, error) {
+	q := url.Values{}
+	q.Add("client_id", a.clientId)
+	q.Add("scopes", strings.Join(scopeNames(a.requestedScopes.load()), " "))
+
+	res, err := doRequest(ctx, a.apply(apiRequest{
+		endpoint: EndpointDevice,
+		method:   "POST",
+		url:      a.urls().DeviceUrl,
+		form:     q,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	d := DeviceCodeResponse{Meta: res.meta()}
+
+	if res.statusCode != 200 {
+		d.DeviceCodeRequestStatus = StatusFailure
+		err = json.Unmarshal(res.body, &d.FailureData)
+		if err != nil {
+			e := fmt.Sprintf("error while parsing failed request response: %s", err)
+			return nil, errors.New(e)
+		}
+		return &d, nil
+	}
+
+	d.DeviceCodeRequestStatus = StatusSuccess
+	err = json.Unmarshal(res.body, &d.DeviceCodeData)
+	if err != nil {
+		e := fmt.Sprintf("error while parsing device code response: %s", err)
+		return nil, errors.New(e)
+	}
+
+	return &d, nil
+}
+
+// GetToken checks once whether the user has authorized the app, retrieving a bearer token if they have. While
+// authorization is pending, the returned TokenResponse has a FailureData.Message of "authorization_pending".
+func (a *DeviceCodeGrantAuthenticator) GetToken(deviceCode string) (*TokenResponse, error) {
+	return a.GetTokenWithContext(context.Background(), deviceCode)
+}
+
+// GetTokenWithContext behaves like GetToken, but stops waiting on the RateLimiter and cancels the request once ctx
+// is done.
+func (a *DeviceCodeGrantAuthenticator) GetTokenWithContext(ctx context.Context, deviceCode string) (*TokenResponse, error) {
+	q := url.Values{}
+	q.Add("client_id", a.clientId)
+	q.Add("device_code", deviceCode)
+	q.Add("grant_type", a.grantType)
+	q.Add("scopes", strings.Join(scopeNames(a.requestedScopes.load()), " "))
+
+	res, err := doRequest(ctx, a.apply(apiRequest{
+		endpoint:  EndpointToken,
+		method:    "POST",
+		url:       a.urls().TokenUrl,
+		form:      q,
+		grantType: a.grantType,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseTokenResponse(res, TokenKindUser, a.clientId)
+}
+
+/*
+WaitForToken polls Twitch at the interval requested in the DeviceCodeRequestResponse until the user authorizes the
+app, the device code expires, or ctx is done.
+
+The final TokenResponse is returned once Twitch responds with anything other than a pending authorization, so
+callers should still check its TokenRequestStatus (ex. if the user denied access).
+*/
+func (a *DeviceCodeGrantAuthenticator) WaitForToken(ctx context.Context, d *DeviceCodeRequestResponse) (*TokenResponse, error) {
+	interval := time.Duration(d.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	expiresAt := time.Now().Add(time.Duration(d.ExpiresIn) * time.Second)
+
+	for {
+		t, err := a.GetTokenWithContext(ctx, d.DeviceCode)
+		if err != nil {
+			return nil, err
+		}
+
+		if t.TokenRequestStatus == StatusSuccess || t.FailureData == nil {
+			return t, nil
+		}
+
+		switch t.FailureData.Message {
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return t, nil
+		}
+
+		if d.ExpiresIn > 0 && time.Now().Add(interval).After(expiresAt) {
+			return nil, ErrDeviceCodeExpired
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// RefreshToken uses the refresh token provided by the GetToken method to retrieve a new bearer token. As the device
+// code grant flow is intended for public clients, no client secret is sent.
+func (a *DeviceCodeGrantAuthenticator) RefreshToken(refreshToken string) (*TokenResponse, error) {
+	return a.RefreshTokenWithContext(context.Background(), refreshToken)
+}
+
+// RefreshTokenWithContext behaves like RefreshToken, but stops waiting on the RateLimiter and cancels the request
+// once ctx is done.
+func (a *DeviceCodeGrantAuthenticator) RefreshTokenWithContext(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+	q := url.Values{}
+	q.Add("client_id", a.clientId)
+	q.Add("grant_type", "refresh_token")
+	q.Add("refresh_token", refreshToken)
+
+	res, err := doRequest(ctx, a.apply(apiRequest{
+		endpoint:  EndpointToken,
+		method:    "POST",
+		url:       a.urls().TokenUrl,
+		form:      q,
+		grantType: "refresh_token",
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseTokenResponse(res, TokenKindUser, a.clientId)
+}
+
+// UpdateScopes replaces the original array of ScopeType provided during initialization. Call RequestDeviceCode to
+// reauthorize with new scopes. The authenticator keeps its own copy of scopes, so the slice may be reused by the
+// caller.
+func (a *DeviceCodeGrantAuthenticator) UpdateScopes(scopes []ScopeType) {
+	a.requestedScopes.store(scopes)
+}
+
+/*
+GetScopes retrieves the currently requested list of scopes. It's important to note that the scopes returned
+are only what has been supplied to the authenticator - not what the end user has authorized.
+
+To retrieve the scopes that the user has authorized, you can use the ValidateToken function.
+*/
+func (a *DeviceCodeGrantAuthenticator) GetScopes() []ScopeType {
+	return slices.Clone(a.requestedScopes.load())
+}
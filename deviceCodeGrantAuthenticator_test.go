@@ -0,0 +1,74 @@
+﻿package go_twitchAuth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/adamsurek/go-twitchAuth/twitchauthtest"
+)
+
+func TestDeviceCodeGrantAuthenticatorGetToken(t *testing.T) {
+	tests := map[string]struct {
+		approve     bool
+		failure     *twitchauthtest.Failure
+		wantStatus  int
+		wantMessage string
+	}{
+		"approved":     {approve: true, wantStatus: http.StatusOK},
+		"pending":      {wantStatus: http.StatusBadRequest, wantMessage: "authorization_pending"},
+		"rate limited": {approve: true, failure: &twitchauthtest.Failure{Status: http.StatusTooManyRequests, Message: "too many requests"}, wantStatus: http.StatusTooManyRequests},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := useFakeServer(t)
+			s.RegisterApp("client-id", "")
+
+			a := NewDeviceCodeGrantAuthenticator("client-id", []ScopeType{ScopeChatRead})
+			d, err := a.RequestDeviceCode()
+			if err != nil {
+				t.Fatalf("RequestDeviceCode() error = %v", err)
+			}
+
+			if d.DeviceCodeRequestStatus != StatusSuccess || d.DeviceCodeData.DeviceCode == "" || d.DeviceCodeData.UserCode == "" {
+				t.Fatalf("RequestDeviceCode() = %+v, want a device code", d)
+			}
+
+			if tt.approve {
+				err = s.ApproveDevice(d.DeviceCodeData.UserCode)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.failure != nil {
+				s.InjectFailure("/oauth2/token", *tt.failure)
+			}
+
+			res, err := a.GetToken(d.DeviceCodeData.DeviceCode)
+			if err != nil {
+				t.Fatalf("GetToken() error = %v", err)
+			}
+
+			checkTokenResponse(t, res, tt.wantStatus)
+			if tt.wantMessage != "" && res.FailureData.Message != tt.wantMessage {
+				t.Errorf("GetToken() message = %q, want %q", res.FailureData.Message, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestDeviceCodeGrantAuthenticatorRequestDeviceCodeFailure(t *testing.T) {
+	s := useFakeServer(t)
+	s.RegisterApp("client-id", "")
+	s.InjectFailure("/oauth2/device", twitchauthtest.Failure{Status: http.StatusInternalServerError, Message: "internal error"})
+
+	d, err := NewDeviceCodeGrantAuthenticator("client-id", []ScopeType{ScopeChatRead}).RequestDeviceCode()
+	if err != nil {
+		t.Fatalf("RequestDeviceCode() error = %v", err)
+	}
+
+	if d.DeviceCodeRequestStatus != StatusFailure || d.FailureData == nil || d.FailureData.Status != http.StatusInternalServerError {
+		t.Fatalf("RequestDeviceCode() = %+v, want a failed response", d)
+	}
+}
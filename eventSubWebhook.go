@@ -0,0 +1,377 @@
+﻿package go_twitchAuth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers sent by Twitch with every EventSub webhook message.
+const (
+	EventSubHeaderMessageId           = "Twitch-Eventsub-Message-Id"
+	EventSubHeaderMessageRetry        = "Twitch-Eventsub-Message-Retry"
+	EventSubHeaderMessageType         = "Twitch-Eventsub-Message-Type"
+	EventSubHeaderMessageSignature    = "Twitch-Eventsub-Message-Signature"
+	EventSubHeaderMessageTimestamp    = "Twitch-Eventsub-Message-Timestamp"
+	EventSubHeaderSubscriptionType    = "Twitch-Eventsub-Subscription-Type"
+	EventSubHeaderSubscriptionVersion = "Twitch-Eventsub-Subscription-Version"
+)
+
+// Values of the Twitch-Eventsub-Message-Type header.
+const (
+	EventSubMessageTypeNotification = "notification"
+	EventSubMessageTypeVerification = "webhook_callback_verification"
+	EventSubMessageTypeRevocation   = "revocation"
+)
+
+// defaultEventSubMaxAge is the age beyond which messages are rejected, as recommended by Twitch.
+const defaultEventSubMaxAge = 10 * time.Minute
+
+// maxEventSubBodySize is the largest message body accepted by EventSubHandler.
+const maxEventSubBodySize = 1 << 20
+
+/*
+EventSubMessageStore records the IDs of EventSub messages that have been handled, so that messages Twitch delivers
+more than once are only processed once. Implementations can share IDs (ex. via Redis) across several instances of an
+app, and must be safe for concurrent use.
+*/
+type EventSubMessageStore interface {
+	// MarkSeen records the message ID until expiresAt, reporting whether it had already been recorded.
+	MarkSeen(ctx context.Context, messageId string, expiresAt time.Time) (bool, error)
+
+	// Forget removes the message ID, so that Twitch's next delivery of the message is processed.
+	Forget(ctx context.Context, messageId string) error
+}
+
+// MemoryEventSubMessageStore is an EventSubMessageStore that keeps message IDs in memory. Expired IDs are discarded
+// as new ones are recorded, in order of expiry, so recording an ID does not scan every ID in the store.
+//
+// New instances of MemoryEventSubMessageStore should be created via NewMemoryEventSubMessageStore.
+type MemoryEventSubMessageStore struct {
+	mu     sync.Mutex
+	ids    map[string]time.Time
+	expiry expiryQueue
+}
+
+// NewMemoryEventSubMessageStore generates a new MemoryEventSubMessageStore instance.
+func NewMemoryEventSubMessageStore() *MemoryEventSubMessageStore {
+	return &MemoryEventSubMessageStore{ids: map[string]time.Time{}}
+}
+
+// MarkSeen records the message ID, discarding any IDs that have expired.
+func (m *MemoryEventSubMessageStore) MarkSeen(_ context.Context, messageId string, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for {
+		e, ok := m.expiry.popExpired(time.Now())
+		if !ok {
+			break
+		}
+
+		if m.live(e) {
+			delete(m.ids, e.key)
+		}
+	}
+
+	if _, ok := m.ids[messageId]; ok {
+		return true, nil
+	}
+
+	m.ids[messageId] = expiresAt
+	m.expiry.add(messageId, expiresAt)
+
+	return false, nil
+}
+
+// Forget removes the message ID.
+func (m *MemoryEventSubMessageStore) Forget(_ context.Context, messageId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.ids, messageId)
+	m.expiry.compact(len(m.ids), m.live)
+
+	return nil
+}
+
+// live reports whether the queued expiry belongs to a recorded ID, rather than one since forgotten or re-recorded.
+// The caller must hold m.mu.
+func (m *MemoryEventSubMessageStore) live(e expiryEntry) bool {
+	expiresAt, ok := m.ids[e.key]
+	return ok && expiresAt.Equal(e.expiresAt)
+}
+
+// EventSubMessage stores the details of a verified EventSub message. It is added to the context of requests passed on
+// by EventSubHandler, and can be retrieved via EventSubMessageFromContext.
+type EventSubMessage struct {
+	Id                  string
+	Type                string
+	SubscriptionType    string
+	SubscriptionVersion string
+	Timestamp           time.Time
+	// Retry is the value of the Twitch-Eventsub-Message-Retry header.
+	Retry string
+	// Body is the message's raw JSON body, which is also left readable via the request's Body.
+	Body []byte
+}
+
+// eventSubMessageKey is the context key under which EventSubHandler stores the EventSubMessage.
+type eventSubMessageKey struct{}
+
+// EventSubMessageFromContext retrieves the EventSubMessage added to ctx by EventSubHandler.
+func EventSubMessageFromContext(ctx context.Context) (*EventSubMessage, bool) {
+	m, ok := ctx.Value(eventSubMessageKey{}).(*EventSubMessage)
+	return m, ok
+}
+
+// EventSubHandlerOption configures an EventSubHandler created via NewEventSubHandler.
+type EventSubHandlerOption func(*EventSubHandler) error
+
+// WithEventSubMessageStore sets the EventSubMessageStore used to deduplicate messages. By default, message IDs are
+// kept in a MemoryEventSubMessageStore.
+func WithEventSubMessageStore(s EventSubMessageStore) EventSubHandlerOption {
+	return func(h *EventSubHandler) error {
+		if s == nil {
+			return errors.New("WithEventSubMessageStore: store must not be nil")
+		}
+
+		h.store = s
+		return nil
+	}
+}
+
+// WithEventSubMaxAge sets the age beyond which messages are rejected as possible replays. Defaults to ten minutes.
+func WithEventSubMaxAge(d time.Duration) EventSubHandlerOption {
+	return func(h *EventSubHandler) error {
+		if d <= 0 {
+			return errors.New("WithEventSubMaxAge: max age must be positive")
+		}
+
+		h.maxAge = d
+		return nil
+	}
+}
+
+/*
+EventSubHandler is an http.Handler that authenticates EventSub webhook messages before passing them on.
+
+Every message must carry a Twitch-Eventsub-Message-Signature matching the HMAC-SHA256 of its message ID, timestamp
+and body, computed with the secret supplied when the subscription was created, and must be no older than the max age.
+Messages failing either check are rejected with a 403. Messages that have already been handled are acknowledged with
+a 200 without being passed on.
+
+webhook_callback_verification messages are answered with their challenge. Notifications and revocations are passed
+on to the wrapped handler, with their EventSubMessage added to the request's context. If the wrapped handler
+responds with anything other than a 2XX status, or panics, the message ID is forgotten so that Twitch's retry is
+processed.
+
+Message IDs are recorded before the wrapped handler runs, so a duplicate that arrives while the first delivery is
+still being handled is acknowledged with a 200 and dropped. If the first delivery then fails, Twitch does not retry
+the acknowledged duplicate, and the message is only processed again if Twitch delivers it once more. Handlers that
+cannot tolerate this should respond quickly (ex. by queueing the message) to keep the window short.
+
+Twitch docs: https://dev.twitch.tv/docs/eventsub/handling-webhook-events/
+
+New instances of EventSubHandler should be created via NewEventSubHandler.
+*/
+type EventSubHandler struct {
+	secret []byte
+	next   http.Handler
+	store  EventSubMessageStore
+	maxAge time.Duration
+}
+
+// NewEventSubHandler generates a new EventSubHandler instance that passes verified messages to next. The secret is
+// the one supplied when creating the EventSub subscriptions, which Twitch requires to be 10 to 100 ASCII characters.
+func NewEventSubHandler(secret string, next http.Handler, opts ...EventSubHandlerOption) (*EventSubHandler, error) {
+	if len(secret) < 10 || len(secret) > 100 {
+		return nil, errors.New("eventsub secret must be between 10 and 100 characters long")
+	}
+
+	if next == nil {
+		return nil, errors.New("a handler is required")
+	}
+
+	h := &EventSubHandler{
+		secret: []byte(secret),
+		next:   next,
+		store:  NewMemoryEventSubMessageStore(),
+		maxAge: defaultEventSubMaxAge,
+	}
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+
+		err := opt(h)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return h, nil
+}
+
+// ServeHTTP verifies the message and either answers it or passes it on to the wrapped handler.
+func (h *EventSubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxEventSubBodySize))
+	if err != nil {
+		http.Error(w, "message body could not be read", http.StatusBadRequest)
+		return
+	}
+
+	m, err := h.verify(r.Header, body)
+	if err != nil {
+		logWarn(ctx, "eventsub message rejected", slog.String("message_id", r.Header.Get(EventSubHeaderMessageId)), slog.Any("error", err))
+		http.Error(w, "message could not be verified", http.StatusForbidden)
+		return
+	}
+
+	seen, err := h.store.MarkSeen(ctx, m.Id, time.Now().Add(h.maxAge))
+	if err != nil {
+		logWarn(ctx, "eventsub message id could not be recorded", slog.String("message_id", m.Id), slog.Any("error", err))
+		http.Error(w, "message could not be recorded", http.StatusInternalServerError)
+		return
+	}
+
+	if seen {
+		logDebug(ctx, "duplicate eventsub message ignored", slog.String("message_id", m.Id))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if m.Type == EventSubMessageTypeVerification {
+		var v struct {
+			Challenge string `json:"challenge"`
+		}
+		err = json.Unmarshal(body, &v)
+		if err != nil || v.Challenge == "" {
+			h.forget(ctx, m.Id)
+			http.Error(w, "verification message has no challenge", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, v.Challenge)
+		return
+	}
+
+	// A panic in the wrapped handler means the message was not handled, so its ID is forgotten before the panic
+	// continues on to the server.
+	defer func() {
+		if p := recover(); p != nil {
+			h.forget(ctx, m.Id)
+			panic(p)
+		}
+	}()
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	h.next.ServeHTTP(sw, r.WithContext(context.WithValue(ctx, eventSubMessageKey{}, m)))
+
+	if sw.status < 200 || sw.status > 299 {
+		h.forget(ctx, m.Id)
+	}
+}
+
+// forget removes the message ID from the store, so that Twitch's next delivery of the message is processed.
+func (h *EventSubHandler) forget(ctx context.Context, messageId string) {
+	err := h.store.Forget(ctx, messageId)
+	if err != nil {
+		logWarn(ctx, "eventsub message id could not be forgotten", slog.String("message_id", messageId), slog.Any("error", err))
+	}
+}
+
+// verify checks the message's signature and timestamp.
+func (h *EventSubHandler) verify(header http.Header, body []byte) (*EventSubMessage, error) {
+	m := &EventSubMessage{
+		Id:                  header.Get(EventSubHeaderMessageId),
+		Type:                header.Get(EventSubHeaderMessageType),
+		SubscriptionType:    header.Get(EventSubHeaderSubscriptionType),
+		SubscriptionVersion: header.Get(EventSubHeaderSubscriptionVersion),
+		Retry:               header.Get(EventSubHeaderMessageRetry),
+		Body:                body,
+	}
+
+	timestamp := header.Get(EventSubHeaderMessageTimestamp)
+	if m.Id == "" || timestamp == "" {
+		return nil, errors.New("message is missing its id or timestamp")
+	}
+
+	sig, ok := strings.CutPrefix(header.Get(EventSubHeaderMessageSignature), "sha256=")
+	if !ok {
+		return nil, errors.New("message signature is missing or does not use sha256")
+	}
+
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return nil, errors.New("message signature is not valid hex")
+	}
+
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(m.Id))
+	mac.Write([]byte(timestamp))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return nil, errors.New("message signature does not match")
+	}
+
+	m.Timestamp, err = time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		e := fmt.Sprintf("message timestamp %q could not be parsed", timestamp)
+		return nil, errors.New(e)
+	}
+
+	if age := time.Since(m.Timestamp); age > h.maxAge || age < -h.maxAge {
+		e := fmt.Sprintf("message timestamp %s is outside the allowed window of %s", timestamp, h.maxAge)
+		return nil, errors.New(e)
+	}
+
+	return m, nil
+}
+
+// statusWriter records the status written by a handler.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying http.ResponseWriter.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,327 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eventSubRequest builds a notification signed with secret.
+func eventSubRequest(secret string, id string, body string) *http.Request {
+	return signedEventSubRequest(secret, id, EventSubMessageTypeNotification, time.Now(), body)
+}
+
+// signedEventSubRequest builds a message of the supplied type, sent at timestamp and signed with secret.
+func signedEventSubRequest(secret string, id string, messageType string, timestamp time.Time, body string) *http.Request {
+	ts := timestamp.UTC().Format(time.RFC3339Nano)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id + ts + body))
+
+	r := httptest.NewRequest(http.MethodPost, "/eventsub", strings.NewReader(body))
+	r.Header.Set(EventSubHeaderMessageId, id)
+	r.Header.Set(EventSubHeaderMessageTimestamp, ts)
+	r.Header.Set(EventSubHeaderMessageType, messageType)
+	r.Header.Set(EventSubHeaderSubscriptionType, "channel.follow")
+	r.Header.Set(EventSubHeaderSubscriptionVersion, "2")
+	r.Header.Set(EventSubHeaderMessageSignature, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	return r
+}
+
+// countingHandler is an http.Handler that counts the messages passed to it and records the last one.
+type countingHandler struct {
+	calls int
+	last  *EventSubMessage
+}
+
+func (h *countingHandler) ServeHTTP(_ http.ResponseWriter, r *http.Request) {
+	h.calls++
+	h.last, _ = EventSubMessageFromContext(r.Context())
+}
+
+func TestEventSubHandlerRetries(t *testing.T) {
+	const secret = "0123456789abcdef"
+
+	tests := map[string]struct {
+		handler     http.HandlerFunc
+		wantPanic   bool
+		wantHandled int
+	}{
+		"success": {
+			handler:     func(w http.ResponseWriter, r *http.Request) {},
+			wantHandled: 1,
+		},
+		"failure": {
+			handler:     func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) },
+			wantHandled: 2,
+		},
+		"panic": {
+			handler:     func(w http.ResponseWriter, r *http.Request) { panic("handler failed") },
+			wantPanic:   true,
+			wantHandled: 2,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			handled := 0
+			h, err := NewEventSubHandler(secret, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handled++
+				tt.handler(w, r)
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Twitch delivers the message a second time, ex. as a retry after the first delivery failed.
+			for i := 0; i < 2; i++ {
+				func() {
+					defer func() {
+						if p := recover(); (p != nil) != tt.wantPanic {
+							t.Errorf("delivery %d panic = %v, wantPanic %t", i, p, tt.wantPanic)
+						}
+					}()
+
+					h.ServeHTTP(httptest.NewRecorder(), eventSubRequest(secret, "message-id", `{"event":{}}`))
+				}()
+			}
+
+			if handled != tt.wantHandled {
+				t.Errorf("handler ran %d times, want %d", handled, tt.wantHandled)
+			}
+		})
+	}
+}
+
+func TestEventSubHandlerRejectsUnverifiedMessages(t *testing.T) {
+	const secret = "0123456789abcdef"
+	const body = `{"event":{}}`
+
+	tests := map[string]func() *http.Request{
+		"missing signature": func() *http.Request {
+			r := eventSubRequest(secret, "message-id", body)
+			r.Header.Del(EventSubHeaderMessageSignature)
+			return r
+		},
+		"wrong secret": func() *http.Request {
+			return eventSubRequest("another-secret", "message-id", body)
+		},
+		"wrong algorithm": func() *http.Request {
+			r := eventSubRequest(secret, "message-id", body)
+			r.Header.Set(EventSubHeaderMessageSignature, strings.Replace(r.Header.Get(EventSubHeaderMessageSignature), "sha256=", "sha1=", 1))
+			return r
+		},
+		"malformed signature": func() *http.Request {
+			r := eventSubRequest(secret, "message-id", body)
+			r.Header.Set(EventSubHeaderMessageSignature, "sha256=not-hex")
+			return r
+		},
+		"tampered body": func() *http.Request {
+			r := eventSubRequest(secret, "message-id", body)
+			r.Body = io.NopCloser(strings.NewReader(`{"event":{"user_id":"1"}}`))
+			return r
+		},
+		"tampered message id": func() *http.Request {
+			r := eventSubRequest(secret, "message-id", body)
+			r.Header.Set(EventSubHeaderMessageId, "other-message-id")
+			return r
+		},
+		"missing message id": func() *http.Request {
+			return eventSubRequest(secret, "", body)
+		},
+		"older than ten minutes": func() *http.Request {
+			return signedEventSubRequest(secret, "message-id", EventSubMessageTypeNotification, time.Now().Add(-11*time.Minute), body)
+		},
+		"in the future": func() *http.Request {
+			return signedEventSubRequest(secret, "message-id", EventSubMessageTypeNotification, time.Now().Add(11*time.Minute), body)
+		},
+	}
+
+	for name, req := range tests {
+		t.Run(name, func(t *testing.T) {
+			next := &countingHandler{}
+			h, err := NewEventSubHandler(secret, next)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req())
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+
+			if next.calls != 0 {
+				t.Errorf("handler ran %d times for an unverified message", next.calls)
+			}
+		})
+	}
+}
+
+func TestEventSubHandlerAcceptsRecentMessages(t *testing.T) {
+	const secret = "0123456789abcdef"
+
+	next := &countingHandler{}
+	h, err := NewEventSubHandler(secret, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sentAt := time.Now().Add(-9 * time.Minute)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, signedEventSubRequest(secret, "message-id", EventSubMessageTypeNotification, sentAt, `{"event":{}}`))
+
+	if w.Code != http.StatusOK || next.calls != 1 {
+		t.Fatalf("status = %d with %d handler calls, want 200 with 1 call", w.Code, next.calls)
+	}
+
+	m := next.last
+	if m == nil || m.Id != "message-id" || m.Type != EventSubMessageTypeNotification || m.SubscriptionType != "channel.follow" ||
+		m.SubscriptionVersion != "2" || !m.Timestamp.Equal(sentAt) || string(m.Body) != `{"event":{}}` {
+		t.Errorf("EventSubMessageFromContext() = %+v, want the delivered message", m)
+	}
+}
+
+func TestEventSubHandlerVerificationChallenge(t *testing.T) {
+	const secret = "0123456789abcdef"
+
+	tests := map[string]struct {
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		"challenge":         {body: `{"challenge":"pogchamp-kappa-360noscope-vohiyo"}`, wantStatus: http.StatusOK, wantBody: "pogchamp-kappa-360noscope-vohiyo"},
+		"missing challenge": {body: `{"subscription":{}}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			next := &countingHandler{}
+			h, err := NewEventSubHandler(secret, next)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, signedEventSubRequest(secret, "message-id", EventSubMessageTypeVerification, time.Now(), tt.body))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus == http.StatusOK && (w.Body.String() != tt.wantBody || w.Header().Get("Content-Type") != "text/plain") {
+				t.Errorf("response = %q (%s), want %q (text/plain)", w.Body.String(), w.Header().Get("Content-Type"), tt.wantBody)
+			}
+
+			if next.calls != 0 {
+				t.Errorf("handler ran %d times for a verification message", next.calls)
+			}
+		})
+	}
+}
+
+func TestEventSubHandlerRequiresPost(t *testing.T) {
+	next := &countingHandler{}
+	h, err := NewEventSubHandler("0123456789abcdef", next)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodHead} {
+		r := eventSubRequest("0123456789abcdef", "message-id", `{"event":{}}`)
+		r.Method = method
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodPost {
+			t.Errorf("%s = %d (Allow %q), want 405 (Allow POST)", method, w.Code, w.Header().Get("Allow"))
+		}
+	}
+
+	if next.calls != 0 {
+		t.Errorf("handler ran %d times for requests other than POST", next.calls)
+	}
+}
+
+func TestEventSubHandlerAcknowledgesDuplicates(t *testing.T) {
+	const secret = "0123456789abcdef"
+
+	next := &countingHandler{}
+	h, err := NewEventSubHandler(secret, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, eventSubRequest(secret, "message-id", `{"event":{}}`))
+
+		if w.Code != http.StatusOK {
+			t.Errorf("delivery %d status = %d, want %d", i, w.Code, http.StatusOK)
+		}
+	}
+
+	if next.calls != 1 {
+		t.Errorf("handler ran %d times for a message delivered three times, want 1", next.calls)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, eventSubRequest(secret, "other-message-id", `{"event":{}}`))
+
+	if w.Code != http.StatusOK || next.calls != 2 {
+		t.Errorf("new message status = %d with %d handler calls, want 200 with 2 calls", w.Code, next.calls)
+	}
+}
+
+func TestMemoryEventSubMessageStoreExpiry(t *testing.T) {
+	m := NewMemoryEventSubMessageStore()
+	ctx := context.Background()
+	now := time.Now()
+
+	for i := 0; i < 100; i++ {
+		_, err := m.MarkSeen(ctx, "expired-"+strconv.Itoa(i), now.Add(-time.Duration(i+1)*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen, err := m.MarkSeen(ctx, "current", now.Add(time.Hour))
+	if err != nil || seen {
+		t.Fatalf("MarkSeen() of a new ID = %t, %v; want false, nil", seen, err)
+	}
+
+	if len(m.ids) != 1 || m.expiry.Len() != 1 {
+		t.Fatalf("store holds %d IDs and %d queued expiries after pruning, want 1 and 1", len(m.ids), m.expiry.Len())
+	}
+
+	seen, _ = m.MarkSeen(ctx, "current", now.Add(time.Hour))
+	if !seen {
+		t.Error("MarkSeen() of a recorded ID = false, want true")
+	}
+
+	// Forgetting and recording IDs again leaves stale expiries behind, which are compacted away.
+	for i := 0; i < 1000; i++ {
+		_, _ = m.MarkSeen(ctx, "retried", now.Add(time.Hour))
+		_ = m.Forget(ctx, "retried")
+	}
+
+	if m.expiry.Len() > 2*len(m.ids)+minExpiryQueueCompaction+1 {
+		t.Errorf("store queues %d expiries for %d IDs", m.expiry.Len(), len(m.ids))
+	}
+
+	seen, _ = m.MarkSeen(ctx, "current", now.Add(time.Hour))
+	if !seen {
+		t.Error("MarkSeen() of a recorded ID after compaction = false, want true")
+	}
+}
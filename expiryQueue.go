@@ -0,0 +1,84 @@
+﻿package go_twitchAuth
+
+import (
+	"container/heap"
+	"time"
+)
+
+// minExpiryQueueCompaction is the number of stale entries an expiryQueue tolerates before compact rebuilds it.
+const minExpiryQueueCompaction = 64
+
+// expiryEntry is a key queued for removal at expiresAt.
+type expiryEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+/*
+expiryQueue orders the keys of an in-memory store by expiry, so that expired entries, or the entry closest to
+expiring, can be found without scanning the whole store.
+
+Keys are not removed from the queue when their entry is deleted or replaced, so a popped entry may be stale. Callers
+must check that it still matches the store before acting on it, and should call compact after deleting entries to
+keep stale entries from accumulating.
+
+expiryQueue is not safe for concurrent use; callers must hold the lock guarding their store.
+*/
+type expiryQueue []expiryEntry
+
+func (q expiryQueue) Len() int           { return len(q) }
+func (q expiryQueue) Less(i, j int) bool { return q[i].expiresAt.Before(q[j].expiresAt) }
+func (q expiryQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *expiryQueue) Push(x any) {
+	*q = append(*q, x.(expiryEntry))
+}
+
+func (q *expiryQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+
+	return e
+}
+
+// add queues key for removal at expiresAt.
+func (q *expiryQueue) add(key string, expiresAt time.Time) {
+	heap.Push(q, expiryEntry{key: key, expiresAt: expiresAt})
+}
+
+// popExpired removes and returns the entry expiring soonest, if it expired before now.
+func (q *expiryQueue) popExpired(now time.Time) (expiryEntry, bool) {
+	if len(*q) == 0 || !now.After((*q)[0].expiresAt) {
+		return expiryEntry{}, false
+	}
+
+	return heap.Pop(q).(expiryEntry), true
+}
+
+// pop removes and returns the entry expiring soonest.
+func (q *expiryQueue) pop() (expiryEntry, bool) {
+	if len(*q) == 0 {
+		return expiryEntry{}, false
+	}
+
+	return heap.Pop(q).(expiryEntry), true
+}
+
+// compact drops stale entries, as reported by live, once they outnumber the live entries of a store holding n.
+func (q *expiryQueue) compact(n int, live func(e expiryEntry) bool) {
+	if len(*q) <= 2*n+minExpiryQueueCompaction {
+		return
+	}
+
+	kept := (*q)[:0]
+	for _, e := range *q {
+		if live(e) {
+			kept = append(kept, e)
+		}
+	}
+
+	clear((*q)[len(kept):])
+	*q = kept
+	heap.Init(q)
+}
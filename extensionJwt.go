@@ -0,0 +1,271 @@
+﻿package go_twitchAuth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultExternalJwtExpiresIn is the lifetime of JWTs signed via SignExternalExtensionJwt when no lifetime is
+// supplied.
+const defaultExternalJwtExpiresIn = 3 * time.Minute
+
+// extensionJwtHeader is the header of every JWT signed by this package.
+var extensionJwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Errors returned by VerifyExtensionJwt. ErrInvalidExtensionJwt is wrapped with details of the problem, so should be
+// checked for via errors.Is.
+var (
+	ErrInvalidExtensionJwt = errors.New("invalid extension JWT")
+	ErrExtensionJwtExpired = errors.New("extension JWT has expired")
+)
+
+/*
+ExtensionRole represents the role of the user an Extension JWT was issued for.
+
+Twitch docs: https://dev.twitch.tv/docs/extensions/reference/#jwt-schema
+*/
+type ExtensionRole int
+
+const (
+	// ExtensionRoleBroadcaster represents the broadcaster of the channel the Extension is running on.
+	ExtensionRoleBroadcaster ExtensionRole = iota + 1
+
+	// ExtensionRoleModerator represents a moderator of the channel the Extension is running on.
+	ExtensionRoleModerator
+
+	// ExtensionRoleViewer represents any other viewer, whether or not they are logged in.
+	ExtensionRoleViewer
+
+	// ExtensionRoleExternal represents an Extension Backend Service (EBS) calling the Extensions API.
+	ExtensionRoleExternal
+)
+
+var extensionRoleId = map[string]ExtensionRole{
+	"broadcaster": ExtensionRoleBroadcaster,
+	"moderator":   ExtensionRoleModerator,
+	"viewer":      ExtensionRoleViewer,
+	"external":    ExtensionRoleExternal,
+}
+
+var extensionRoleName = map[ExtensionRole]string{
+	ExtensionRoleBroadcaster: "broadcaster",
+	ExtensionRoleModerator:   "moderator",
+	ExtensionRoleViewer:      "viewer",
+	ExtensionRoleExternal:    "external",
+}
+
+func (r ExtensionRole) String() string {
+	return extensionRoleName[r]
+}
+
+func (r ExtensionRole) MarshalJSON() ([]byte, error) {
+	buffer := bytes.NewBufferString(`"`)
+	buffer.WriteString(extensionRoleName[r])
+	buffer.WriteString(`"`)
+	return buffer.Bytes(), nil
+}
+
+func (r *ExtensionRole) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+
+	*r = extensionRoleId[s]
+	return nil
+}
+
+// ExtensionPubSubPerms lists the Extension PubSub targets (ex. "broadcast", "global" or "whisper-<opaque user ID>")
+// that the holder of an Extension JWT may listen or send to.
+type ExtensionPubSubPerms struct {
+	Listen []string `json:"listen,omitempty"`
+	Send   []string `json:"send,omitempty"`
+}
+
+/*
+ExtensionClaims stores the claims of an Extension JWT, either received from the Extension frontend and verified via
+VerifyExtensionJwt, or signed by an Extension Backend Service via SignExtensionJwt.
+
+Twitch docs: https://dev.twitch.tv/docs/extensions/reference/#jwt-schema
+*/
+type ExtensionClaims struct {
+	// ExpiresAt is the Unix time at which the JWT expires.
+	ExpiresAt int64 `json:"exp"`
+	// OpaqueUserId identifies the user without revealing their Twitch account. IDs starting with "U" are stable for
+	// users who have shared their identity; those starting with "A" identify logged-out viewers and change per session.
+	OpaqueUserId string `json:"opaque_user_id,omitempty"`
+	// UserId is the Twitch user ID, and is only present if the user has shared their identity with the Extension. For
+	// JWTs signed by an EBS, it is the ID of the Extension's owner.
+	UserId      string                `json:"user_id,omitempty"`
+	ChannelId   string                `json:"channel_id,omitempty"`
+	Role        ExtensionRole         `json:"role"`
+	IsUnlinked  bool                  `json:"is_unlinked,omitempty"`
+	PubSubPerms *ExtensionPubSubPerms `json:"pubsub_perms,omitempty"`
+}
+
+// Expiry retrieves the time at which the JWT expires.
+func (c *ExtensionClaims) Expiry() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// IsIdentityShared reports whether the user has shared their Twitch identity with the Extension, in which case
+// UserId is populated.
+func (c *ExtensionClaims) IsIdentityShared() bool {
+	return c.UserId != ""
+}
+
+// ExternalJwtOptions customizes a JWT signed via SignExternalExtensionJwt.
+type ExternalJwtOptions struct {
+	// ChannelId restricts the JWT to a single channel. Some Extensions API endpoints require it.
+	ChannelId string
+	// PubSubPerms grants permission to send Extension PubSub messages, ex. Send: []string{"broadcast"}.
+	PubSubPerms *ExtensionPubSubPerms
+	// ExpiresIn is the lifetime of the JWT. Defaults to three minutes.
+	ExpiresIn time.Duration
+}
+
+/*
+VerifyExtensionJwt verifies a JWT sent by an Extension frontend (ex. via the Twitch Extension helper's onAuthorized
+callback) and retrieves its claims. The secret is the base64-encoded Extension secret shown in the Twitch developer
+console.
+
+The JWT must be signed with HS256 using the secret and carry a known role. ErrExtensionJwtExpired is returned once it
+has expired, and an error wrapping ErrInvalidExtensionJwt for any other problem.
+*/
+func VerifyExtensionJwt(token string, secret string) (*ExtensionClaims, error) {
+	key, err := decodeExtensionSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: expected 3 parts, got %d", ErrInvalidExtensionJwt, len(parts))
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: header could not be decoded: %s", ErrInvalidExtensionJwt, err)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	err = json.Unmarshal(b, &header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: header could not be parsed: %s", ErrInvalidExtensionJwt, err)
+	}
+
+	if header.Alg != "HS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidExtensionJwt, header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, signExtensionJwt(key, parts[0]+"."+parts[1])) {
+		return nil, fmt.Errorf("%w: signature does not match", ErrInvalidExtensionJwt)
+	}
+
+	b, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: claims could not be decoded: %s", ErrInvalidExtensionJwt, err)
+	}
+
+	var c ExtensionClaims
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return nil, fmt.Errorf("%w: claims could not be parsed: %s", ErrInvalidExtensionJwt, err)
+	}
+
+	if c.ExpiresAt == 0 {
+		return nil, fmt.Errorf("%w: missing exp claim", ErrInvalidExtensionJwt)
+	}
+
+	if !time.Now().Before(c.Expiry()) {
+		return nil, ErrExtensionJwtExpired
+	}
+
+	if _, ok := extensionRoleName[c.Role]; !ok {
+		return nil, fmt.Errorf("%w: unknown role", ErrInvalidExtensionJwt)
+	}
+
+	return &c, nil
+}
+
+// SignExtensionJwt signs the supplied claims with HS256 using the base64-encoded Extension secret.
+func SignExtensionJwt(c ExtensionClaims, secret string) (string, error) {
+	key, err := decodeExtensionSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		e := fmt.Sprintf("error while encoding extension JWT claims: %s", err)
+		return "", errors.New(e)
+	}
+
+	unsigned := extensionJwtHeader + "." + base64.RawURLEncoding.EncodeToString(b)
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signExtensionJwt(key, unsigned)), nil
+}
+
+/*
+SignExternalExtensionJwt signs a JWT with the external role, allowing an Extension Backend Service to call the
+Extensions API (ex. to send Extension PubSub messages or set configuration). The ownerUserId is the Twitch user ID of
+the Extension's owner, and secret is the base64-encoded Extension secret.
+
+The JWT should be sent in the Authorization header as "Bearer <jwt>", alongside the Extension's client ID.
+
+Twitch docs: https://dev.twitch.tv/docs/extensions/building/#signing-the-jwt
+*/
+func SignExternalExtensionJwt(secret string, ownerUserId string, o ExternalJwtOptions) (string, error) {
+	if ownerUserId == "" {
+		return "", errors.New("an owner user ID is required")
+	}
+
+	if o.ExpiresIn < 0 {
+		return "", errors.New("expiresIn must not be negative")
+	}
+
+	if o.ExpiresIn == 0 {
+		o.ExpiresIn = defaultExternalJwtExpiresIn
+	}
+
+	return SignExtensionJwt(ExtensionClaims{
+		ExpiresAt:   time.Now().Add(o.ExpiresIn).Unix(),
+		UserId:      ownerUserId,
+		ChannelId:   o.ChannelId,
+		Role:        ExtensionRoleExternal,
+		PubSubPerms: o.PubSubPerms,
+	}, secret)
+}
+
+// decodeExtensionSecret decodes the base64-encoded Extension secret.
+func decodeExtensionSecret(secret string) ([]byte, error) {
+	if secret == "" {
+		return nil, errors.New("extension secret must not be empty")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		e := fmt.Sprintf("extension secret is not valid base64: %s", err)
+		return nil, errors.New(e)
+	}
+
+	return key, nil
+}
+
+// signExtensionJwt computes the HS256 signature of an unsigned JWT.
+func signExtensionJwt(key []byte, unsigned string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(unsigned))
+	return h.Sum(nil)
+}
@@ -0,0 +1,173 @@
+﻿package go_twitchAuth
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testExtensionSecret is the base64-encoded Extension secret used to sign test JWTs.
+var testExtensionSecret = base64.StdEncoding.EncodeToString([]byte("extension-secret"))
+
+// forgeExtensionJwt builds a JWT from a raw header and claims, signed with HS256 using the supplied secret.
+func forgeExtensionJwt(t *testing.T, header string, claims string, secret string) string {
+	t.Helper()
+
+	key, err := decodeExtensionSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signExtensionJwt(key, unsigned))
+}
+
+func TestExtensionJwtRoundTrip(t *testing.T) {
+	claims := ExtensionClaims{
+		ExpiresAt:    time.Now().Add(time.Hour).Unix(),
+		OpaqueUserId: "U12345",
+		UserId:       "12345",
+		ChannelId:    "67890",
+		Role:         ExtensionRoleViewer,
+		PubSubPerms:  &ExtensionPubSubPerms{Listen: []string{"broadcast"}},
+	}
+
+	token, err := SignExtensionJwt(claims, testExtensionSecret)
+	if err != nil {
+		t.Fatalf("SignExtensionJwt() error = %v", err)
+	}
+
+	got, err := VerifyExtensionJwt(token, testExtensionSecret)
+	if err != nil {
+		t.Fatalf("VerifyExtensionJwt() error = %v", err)
+	}
+
+	if got.OpaqueUserId != claims.OpaqueUserId || got.UserId != claims.UserId || got.ChannelId != claims.ChannelId ||
+		got.Role != claims.Role || got.ExpiresAt != claims.ExpiresAt || !got.IsIdentityShared() {
+		t.Errorf("VerifyExtensionJwt() = %+v, want %+v", got, claims)
+	}
+
+	if got.PubSubPerms == nil || len(got.PubSubPerms.Listen) != 1 || got.PubSubPerms.Listen[0] != "broadcast" {
+		t.Errorf("VerifyExtensionJwt().PubSubPerms = %+v, want %+v", got.PubSubPerms, claims.PubSubPerms)
+	}
+}
+
+func TestSignExternalExtensionJwt(t *testing.T) {
+	token, err := SignExternalExtensionJwt(testExtensionSecret, "12345", ExternalJwtOptions{
+		ChannelId:   "67890",
+		PubSubPerms: &ExtensionPubSubPerms{Send: []string{"broadcast"}},
+	})
+	if err != nil {
+		t.Fatalf("SignExternalExtensionJwt() error = %v", err)
+	}
+
+	got, err := VerifyExtensionJwt(token, testExtensionSecret)
+	if err != nil {
+		t.Fatalf("VerifyExtensionJwt() error = %v", err)
+	}
+
+	if got.Role != ExtensionRoleExternal || got.UserId != "12345" || got.ChannelId != "67890" {
+		t.Errorf("VerifyExtensionJwt() = %+v, want an external JWT for owner 12345 on channel 67890", got)
+	}
+
+	lifetime := time.Until(got.Expiry())
+	if lifetime <= 0 || lifetime > defaultExternalJwtExpiresIn {
+		t.Errorf("JWT expires in %s, want at most %s", lifetime, defaultExternalJwtExpiresIn)
+	}
+
+	tests := map[string]struct {
+		owner string
+		opts  ExternalJwtOptions
+	}{
+		"missing owner":     {opts: ExternalJwtOptions{}},
+		"negative lifetime": {owner: "12345", opts: ExternalJwtOptions{ExpiresIn: -time.Minute}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := SignExternalExtensionJwt(testExtensionSecret, tt.owner, tt.opts)
+			if err == nil {
+				t.Error("SignExternalExtensionJwt() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestVerifyExtensionJwtRejects(t *testing.T) {
+	header := `{"alg":"HS256","typ":"JWT"}`
+	exp := time.Now().Add(time.Hour).Unix()
+	claims := func(role string, exp int64) string {
+		return `{"exp":` + strconv.FormatInt(exp, 10) + `,"opaque_user_id":"U12345","channel_id":"67890","role":"` + role + `"}`
+	}
+
+	valid := forgeExtensionJwt(t, header, claims("viewer", exp), testExtensionSecret)
+	parts := strings.Split(valid, ".")
+	forgedClaims := base64.RawURLEncoding.EncodeToString([]byte(claims("broadcaster", exp)))
+	otherSecret := base64.StdEncoding.EncodeToString([]byte("other-secret"))
+
+	tests := map[string]struct {
+		token string
+		want  error
+	}{
+		"tampered claims":    {token: parts[0] + "." + forgedClaims + "." + parts[2], want: ErrInvalidExtensionJwt},
+		"tampered signature": {token: parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2])), want: ErrInvalidExtensionJwt},
+		"empty signature":    {token: parts[0] + "." + parts[1] + ".", want: ErrInvalidExtensionJwt},
+		"wrong secret":       {token: forgeExtensionJwt(t, header, claims("viewer", exp), otherSecret), want: ErrInvalidExtensionJwt},
+		"alg none":           {token: forgeExtensionJwt(t, `{"alg":"none","typ":"JWT"}`, claims("viewer", exp), testExtensionSecret), want: ErrInvalidExtensionJwt},
+		"alg none unsigned": {
+			token: base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + ".",
+			want:  ErrInvalidExtensionJwt,
+		},
+		"alg HS512":        {token: forgeExtensionJwt(t, `{"alg":"HS512","typ":"JWT"}`, claims("viewer", exp), testExtensionSecret), want: ErrInvalidExtensionJwt},
+		"alg RS256":        {token: forgeExtensionJwt(t, `{"alg":"RS256","typ":"JWT"}`, claims("viewer", exp), testExtensionSecret), want: ErrInvalidExtensionJwt},
+		"missing alg":      {token: forgeExtensionJwt(t, `{"typ":"JWT"}`, claims("viewer", exp), testExtensionSecret), want: ErrInvalidExtensionJwt},
+		"expired":          {token: forgeExtensionJwt(t, header, claims("viewer", time.Now().Add(-time.Second).Unix()), testExtensionSecret), want: ErrExtensionJwtExpired},
+		"missing exp":      {token: forgeExtensionJwt(t, header, `{"role":"viewer"}`, testExtensionSecret), want: ErrInvalidExtensionJwt},
+		"unknown role":     {token: forgeExtensionJwt(t, header, claims("admin", exp), testExtensionSecret), want: ErrInvalidExtensionJwt},
+		"empty role":       {token: forgeExtensionJwt(t, header, claims("", exp), testExtensionSecret), want: ErrInvalidExtensionJwt},
+		"missing role":     {token: forgeExtensionJwt(t, header, `{"exp":`+strconv.FormatInt(exp, 10)+`}`, testExtensionSecret), want: ErrInvalidExtensionJwt},
+		"empty token":      {token: "", want: ErrInvalidExtensionJwt},
+		"one segment":      {token: parts[0], want: ErrInvalidExtensionJwt},
+		"two segments":     {token: parts[0] + "." + parts[1], want: ErrInvalidExtensionJwt},
+		"four segments":    {token: valid + "." + parts[2], want: ErrInvalidExtensionJwt},
+		"malformed header": {token: "!!!." + parts[1] + "." + parts[2], want: ErrInvalidExtensionJwt},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c, err := VerifyExtensionJwt(tt.token, testExtensionSecret)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("VerifyExtensionJwt() error = %v, want %v", err, tt.want)
+			}
+
+			if c != nil {
+				t.Errorf("VerifyExtensionJwt() claims = %+v, want nil", c)
+			}
+		})
+	}
+
+	_, err := VerifyExtensionJwt(valid, testExtensionSecret)
+	if err != nil {
+		t.Fatalf("VerifyExtensionJwt() of the untampered JWT error = %v", err)
+	}
+}
+
+func TestExtensionJwtSecret(t *testing.T) {
+	claims := ExtensionClaims{ExpiresAt: time.Now().Add(time.Hour).Unix(), Role: ExtensionRoleViewer}
+
+	for _, secret := range []string{"", "not base64!"} {
+		_, err := SignExtensionJwt(claims, secret)
+		if err == nil {
+			t.Errorf("SignExtensionJwt() with secret %q error = nil, want an error", secret)
+		}
+
+		_, err = VerifyExtensionJwt("a.b.c", secret)
+		if err == nil {
+			t.Errorf("VerifyExtensionJwt() with secret %q error = nil, want an error", secret)
+		}
+	}
+}
@@ -5,4 +5,79 @@ const (
 	tokenUrl         = "https://id.twitch.tv/oauth2/token"
 	validationUrl    = "https://id.twitch.tv/oauth2/validate"
 	revocationUrl    = "https://id.twitch.tv/oauth2/revoke"
+	deviceUrl        = "https://id.twitch.tv/oauth2/device"
 )
+
+/*
+Endpoints stores the URLs of the Twitch OAuth endpoints that an authenticator sends requests to. Empty fields fall
+back to Twitch's production endpoints.
+
+Endpoints can be supplied via WithEndpoints, ex. to point an authenticator at a mock server.
+*/
+type Endpoints struct {
+	AuthorizationUrl string
+	TokenUrl         string
+	ValidationUrl    string
+	RevocationUrl    string
+	DeviceUrl        string
+}
+
+// DefaultEndpoints retrieves Twitch's production OAuth endpoints.
+func DefaultEndpoints() Endpoints {
+	return Endpoints{
+		AuthorizationUrl: authorizationUrl,
+		TokenUrl:         tokenUrl,
+		ValidationUrl:    validationUrl,
+		RevocationUrl:    revocationUrl,
+		DeviceUrl:        deviceUrl,
+	}
+}
+
+// withDefaults fills any empty fields with Twitch's production endpoints.
+func (e Endpoints) withDefaults() Endpoints {
+	d := DefaultEndpoints()
+	for _, f := range []struct {
+		dst *string
+		def string
+	}{
+		{&e.AuthorizationUrl, d.AuthorizationUrl},
+		{&e.TokenUrl, d.TokenUrl},
+		{&e.ValidationUrl, d.ValidationUrl},
+		{&e.RevocationUrl, d.RevocationUrl},
+		{&e.DeviceUrl, d.DeviceUrl},
+	} {
+		if *f.dst == "" {
+			*f.dst = f.def
+		}
+	}
+
+	return e
+}
+
+// EndpointType identifies one of the Twitch OAuth endpoints that this package sends requests to.
+type EndpointType int
+
+const (
+	// EndpointToken represents the endpoint used to request and refresh bearer tokens.
+	EndpointToken EndpointType = iota + 1
+
+	// EndpointValidation represents the endpoint used to validate bearer tokens.
+	EndpointValidation
+
+	// EndpointRevocation represents the endpoint used to revoke bearer tokens.
+	EndpointRevocation
+
+	// EndpointDevice represents the endpoint used to start the device code grant flow.
+	EndpointDevice
+)
+
+var endpointTypeName = map[EndpointType]string{
+	EndpointToken:      "token",
+	EndpointValidation: "validate",
+	EndpointRevocation: "revoke",
+	EndpointDevice:     "device",
+}
+
+func (e EndpointType) String() string {
+	return endpointTypeName[e]
+}
@@ -0,0 +1,113 @@
+﻿package go_twitchAuth
+
+import (
+	"io"
+	"net/http"
+)
+
+// helixHost is the host serving the Twitch Helix API.
+const helixHost = "api.twitch.tv"
+
+/*
+Transport is an http.RoundTripper that authenticates requests to the Twitch Helix API. The Authorization and
+Client-Id headers of every request to api.twitch.tv are set using a token retrieved from Source. Requests to any other
+host, ex. after a redirect, are sent unchanged so that the token is never disclosed to them.
+
+If the Helix API rejects a request with HTTP 401, the token is renewed via Source and the request is retried once.
+Requests whose body cannot be replayed (see http.Request.GetBody) are not retried.
+
+Transport can be used with any http.Client, allowing it to be passed to third-party Helix client libraries.
+*/
+type Transport struct {
+	// Source supplies the app or user access token used to authenticate requests.
+	Source TokenSource
+
+	// ClientId is sent in the Client-Id header. If empty, the ClientId of the token is used instead.
+	ClientId string
+
+	// Base is the http.RoundTripper used to send requests. If nil, http.DefaultTransport is used.
+	Base http.RoundTripper
+}
+
+// NewHelixClient generates an http.Client that authenticates every request using tokens retrieved from source.
+func NewHelixClient(source TokenSource, clientId string) *http.Client {
+	return &http.Client{
+		Transport: &Transport{
+			Source:   source,
+			ClientId: clientId,
+		},
+	}
+}
+
+// RoundTrip sends the supplied request, setting the Authorization and Client-Id headers if it targets the Helix API.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != helixHost {
+		return t.base().RoundTrip(req)
+	}
+
+	tok, err := t.Source.Token(req.Context())
+	if err != nil {
+		closeBody(req)
+		return nil, err
+	}
+
+	res, err := t.base().RoundTrip(t.authorize(req, tok))
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusUnauthorized {
+		return res, nil
+	}
+
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return res, nil
+	}
+
+	renewed, err := t.Source.RenewToken(req.Context(), tok)
+	if err != nil {
+		return res, nil
+	}
+
+	retry := t.authorize(req, renewed)
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return res, nil
+		}
+	}
+
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+
+	return t.base().RoundTrip(retry)
+}
+
+// authorize clones the supplied request, setting its Authorization and Client-Id headers.
+func (t *Transport) authorize(req *http.Request, tok *Token) *http.Request {
+	r := req.Clone(req.Context())
+	tok.SetAuthHeader(r)
+
+	clientId := t.ClientId
+	if clientId == "" {
+		clientId = tok.ClientId
+	}
+	r.Header.Set("Client-Id", clientId)
+
+	return r
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+
+	return http.DefaultTransport
+}
+
+// closeBody closes the body of a request that will not be sent, as required of an http.RoundTripper.
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
@@ -0,0 +1,115 @@
+﻿package go_twitchAuth
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// helixStub is an http.RoundTripper that records the requests it receives and answers them via respond.
+type helixStub struct {
+	requests []*http.Request
+	respond  func(req *http.Request) *http.Response
+}
+
+func (s *helixStub) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.requests = append(s.requests, req)
+	return s.respond(req), nil
+}
+
+// stubResponse builds a response with the supplied status code and headers.
+func stubResponse(req *http.Request, status int, header http.Header) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}
+}
+
+func TestTransportRetriesOnceOnUnauthorized(t *testing.T) {
+	stub := &helixStub{respond: func(req *http.Request) *http.Response {
+		return stubResponse(req, http.StatusUnauthorized, nil)
+	}}
+	source := &fakeTokenSource{
+		token:   &Token{AccessToken: "first", ClientId: "token-client-id"},
+		renewed: &Token{AccessToken: "second", ClientId: "token-client-id"},
+	}
+	client := &http.Client{Transport: &Transport{Source: source, Base: stub}}
+
+	res, err := client.Get("https://api.twitch.tv/helix/users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+
+	if len(stub.requests) != 2 || len(source.renews) != 1 {
+		t.Fatalf("sent %d requests and renewed %d times, want 2 requests and 1 renewal", len(stub.requests), len(source.renews))
+	}
+
+	for n, want := range []string{"Bearer first", "Bearer second"} {
+		req := stub.requests[n]
+		if got := req.Header.Get("Authorization"); got != want {
+			t.Errorf("request %d Authorization = %q, want %q", n, got, want)
+		}
+
+		if got := req.Header.Get("Client-Id"); got != "token-client-id" {
+			t.Errorf("request %d Client-Id = %q, want %q", n, got, "token-client-id")
+		}
+	}
+}
+
+func TestTransportOnlyAuthenticatesHelixRequests(t *testing.T) {
+	stub := &helixStub{respond: func(req *http.Request) *http.Response {
+		if req.URL.Host == helixHost {
+			return stubResponse(req, http.StatusFound, http.Header{"Location": {"https://example.com/elsewhere"}})
+		}
+
+		return stubResponse(req, http.StatusUnauthorized, nil)
+	}}
+	source := &fakeTokenSource{token: &Token{AccessToken: "secret-tok"}, renewed: &Token{AccessToken: "renewed-tok"}}
+	client := &http.Client{Transport: &Transport{Source: source, ClientId: "client-id", Base: stub}}
+
+	tests := map[string]string{
+		"redirect from helix": "https://api.twitch.tv/helix/users",
+		"other host":          "https://example.com/users",
+	}
+
+	for name, u := range tests {
+		t.Run(name, func(t *testing.T) {
+			stub.requests = nil
+
+			res, err := client.Get(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			last := stub.requests[len(stub.requests)-1]
+			if last.URL.Host != "example.com" {
+				t.Fatalf("last request host = %q, want %q", last.URL.Host, "example.com")
+			}
+
+			if got := last.Header.Get("Authorization"); got != "" {
+				t.Errorf("request to %s sent Authorization = %q", last.URL, got)
+			}
+
+			if got := last.Header.Get("Client-Id"); got != "" {
+				t.Errorf("request to %s sent Client-Id = %q", last.URL, got)
+			}
+		})
+	}
+
+	if len(source.renews) != 0 {
+		t.Errorf("a 401 from a non-Helix host renewed the token %d times", len(source.renews))
+	}
+}
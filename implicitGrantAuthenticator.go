@@ -1,46 +1,108 @@
 ﻿package go_twitchAuth
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 )
 
+// ErrStateMismatch is returned when the state returned by Twitch does not match the state supplied to the
+// authenticator, which may indicate a cross-site request forgery attempt.
+var ErrStateMismatch = errors.New("state returned by twitch does not match the requested state")
+
 /*
 ImplicitGrantAuthenticator allows for the generation of an authorization URL following Twitch's
 OAuth implicit grant flow.
 
+An ImplicitGrantAuthenticator is safe for concurrent use by multiple goroutines.
+
 New instances of ImplicitGrantAuthenticator should be created via
-NewImplicitGrantAuthenticator.
+NewImplicitGrantAuthenticatorWithOptions or NewImplicitGrantAuthenticator.
 
 Twitch docs: https://dev.twitch.tv/docs/authentication/getting-tokens-oauth/#implicit-grant-flow
 */
 type ImplicitGrantAuthenticator struct {
-	requestedScopes []ScopeType
+	requestedScopes scopeSnapshot
 	clientId        string
 	forceVerify     bool
 	redirectUri     string
 	scopeNames      []string
 	state           string
 	responseType    string
+	// registeredRedirectUris is the allow-list supplied via WithRegisteredRedirectUris.
+	registeredRedirectUris []string
+	requestConfig
 }
 
-// NewImplicitGrantAuthenticator generates a new ImplicitGrantAuthenticator instance.
+// NewImplicitGrantAuthenticator generates a new ImplicitGrantAuthenticator instance. Unlike
+// NewImplicitGrantAuthenticatorWithOptions, the supplied values are not validated.
 func NewImplicitGrantAuthenticator(clientId string, forceVerify bool, redirectUri string, scopes []ScopeType, state string) *ImplicitGrantAuthenticator {
-	return &ImplicitGrantAuthenticator{
-		requestedScopes: scopes,
-		clientId:        clientId,
-		forceVerify:     forceVerify,
-		redirectUri:     redirectUri,
-		state:           state,
-		responseType:    "token",
+	return newImplicitGrantAuthenticator(clientId, redirectUri, &authenticatorOptions{
+		forceVerify:   forceVerify,
+		state:         state,
+		scopes:        scopes,
+		requestConfig: requestConfig{endpoints: DefaultEndpoints()},
+	})
+}
+
+/*
+NewImplicitGrantAuthenticatorWithOptions generates a new ImplicitGrantAuthenticator instance, configured via
+WithForceVerify, WithState, WithScopes, WithRegisteredRedirectUris, WithHTTPClient, WithEndpoints and WithLogger.
+The HTTP client, endpoints and logger are used to validate the token in ParseRedirect.
+
+An error is returned if clientId is invalid, redirectUri breaks Twitch's rules (see ValidateRedirectUri) or isn't
+registered, or any option is invalid.
+*/
+func NewImplicitGrantAuthenticatorWithOptions(clientId string, redirectUri string, opts ...AuthenticatorOption) (*ImplicitGrantAuthenticator, error) {
+	err := ValidateClientId(clientId)
+	if err != nil {
+		return nil, err
 	}
+
+	o, err := applyOptions("ImplicitGrantAuthenticator", opts, "WithForceVerify", "WithState", "WithScopes", "WithRegisteredRedirectUris", "WithHTTPClient", "WithEndpoints", "WithLogger")
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateRegisteredRedirectUri(redirectUri, o.registeredRedirectUris)
+	if err != nil {
+		return nil, err
+	}
+
+	return newImplicitGrantAuthenticator(clientId, redirectUri, o), nil
+}
+
+// newImplicitGrantAuthenticator generates a new ImplicitGrantAuthenticator instance from already-collected options.
+func newImplicitGrantAuthenticator(clientId string, redirectUri string, o *authenticatorOptions) *ImplicitGrantAuthenticator {
+	a := &ImplicitGrantAuthenticator{
+		clientId:               clientId,
+		forceVerify:            o.forceVerify,
+		redirectUri:            redirectUri,
+		state:                  o.state,
+		responseType:           "token",
+		registeredRedirectUris: o.registeredRedirectUris,
+		requestConfig:          o.requestConfig,
+	}
+	a.requestedScopes.store(o.scopes)
+
+	return a
 }
 
 // GenerateAuthorizationUrl builds a url.URL that allows a user to authorize a Twitch app and generate
-// a bearer token.
+// a bearer token. An error is returned if the client ID, redirect URI or requested scopes would be rejected by
+// Twitch.
 func (a *ImplicitGrantAuthenticator) GenerateAuthorizationUrl() (*url.URL, error) {
-	authUrl, err := url.Parse(authorizationUrl)
+	scopes := a.requestedScopes.load()
+	err := validateAuthorizationRequest(a.clientId, a.redirectUri, a.registeredRedirectUris, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	authUrl, err := url.Parse(a.urls().AuthorizationUrl)
 	if err != nil {
 		return nil, err
 	}
@@ -49,7 +111,7 @@ func (a *ImplicitGrantAuthenticator) GenerateAuthorizationUrl() (*url.URL, error
 	q.Add("force_verify", strconv.FormatBool(a.forceVerify))
 	q.Add("redirect_uri", a.redirectUri)
 	q.Add("response_type", a.responseType)
-	q.Add("scope", strings.Join(a.getScopeNames(), " "))
+	q.Add("scope", strings.Join(scopeNames(scopes), " "))
 
 	if a.state != "" {
 		q.Add("state", a.state)
@@ -60,19 +122,10 @@ func (a *ImplicitGrantAuthenticator) GenerateAuthorizationUrl() (*url.URL, error
 	return authUrl, err
 }
 
-// getScopeNames retrieves the string version of the ScopeType(s) supplied to the ImplicitGrantAuthenticator.
-func (a *ImplicitGrantAuthenticator) getScopeNames() []string {
-	var scopeNames []string
-	for _, s := range a.requestedScopes {
-		scopeNames = append(scopeNames, scopeTypeName[s])
-	}
-
-	return scopeNames
-}
-
-// UpdateScopes replaces the original array of ScopeType provided during initialization
+// UpdateScopes replaces the original array of ScopeType provided during initialization. The authenticator keeps its
+// own copy of scopes, so the slice may be reused by the caller.
 func (a *ImplicitGrantAuthenticator) UpdateScopes(scopes []ScopeType) {
-	a.requestedScopes = scopes
+	a.requestedScopes.store(scopes)
 }
 
 /*
@@ -82,5 +135,67 @@ are only what has been supplied to the authenticator - not what the end user has
 To retrieve the scopes that the user has authorized, you can use the ValidateToken function.
 */
 func (a *ImplicitGrantAuthenticator) GetScopes() []ScopeType {
-	return a.requestedScopes
+	return slices.Clone(a.requestedScopes.load())
+}
+
+// ParseRedirect builds a Token from the URL that Twitch redirected the user to after they authorized the app. See
+// ParseRedirectWithContext for details.
+func (a *ImplicitGrantAuthenticator) ParseRedirect(redirectUrl *url.URL) (*Token, error) {
+	return a.ParseRedirectWithContext(context.Background(), redirectUrl)
+}
+
+/*
+ParseRedirectWithContext builds a Token from the URL that Twitch redirected the user to after they authorized the
+app. Twitch returns the token in the URL fragment, which is never sent to a server - the fragment must be forwarded
+by the app's frontend and included in redirectUrl.
+
+As Twitch does not include the token's lifetime in the redirect, the token is validated via the Twitch Helix API to
+determine its expiry and owner.
+*/
+func (a *ImplicitGrantAuthenticator) ParseRedirectWithContext(ctx context.Context, redirectUrl *url.URL) (*Token, error) {
+	q := redirectUrl.Query()
+	if redirectUrl.Fragment != "" {
+		f, err := url.ParseQuery(redirectUrl.Fragment)
+		if err != nil {
+			e := fmt.Sprintf("error while parsing redirect fragment: %s", err)
+			return nil, errors.New(e)
+		}
+		q = f
+	}
+
+	if q.Get("error") != "" {
+		e := fmt.Sprintf("authorization failed: %s - %s", q.Get("error"), q.Get("error_description"))
+		return nil, errors.New(e)
+	}
+
+	if a.state != "" && q.Get("state") != a.state {
+		return nil, ErrStateMismatch
+	}
+
+	accessToken := q.Get("access_token")
+	if accessToken == "" {
+		return nil, errors.New("redirect does not contain an access token")
+	}
+
+	v, err := validateToken(ctx, accessToken, a.requestConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.ValidationStatus != StatusSuccess {
+		if v.FailureData == nil {
+			e := fmt.Sprintf("token returned by twitch failed validation: %d", v.Meta.StatusCode)
+			return nil, errors.New(e)
+		}
+
+		e := fmt.Sprintf("token returned by twitch failed validation: %d - %s", v.FailureData.Status, v.FailureData.Message)
+		return nil, errors.New(e)
+	}
+
+	t := v.Token
+	if tokenType := q.Get("token_type"); tokenType != "" {
+		t.TokenType = tokenType
+	}
+
+	return t, nil
 }
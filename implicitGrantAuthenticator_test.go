@@ -0,0 +1,73 @@
+﻿package go_twitchAuth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/adamsurek/go-twitchAuth/twitchauthtest"
+)
+
+func TestImplicitGrantAuthenticatorParseRedirect(t *testing.T) {
+	tests := map[string]struct {
+		failure *twitchauthtest.Failure
+		wantErr bool
+	}{
+		"success":                {},
+		"validation unavailable": {failure: &twitchauthtest.Failure{Status: http.StatusServiceUnavailable, Message: "unavailable"}, wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := useFakeServer(t)
+			s.RegisterApp("client-id", "", "http://localhost/callback")
+			s.SetUser(twitchauthtest.User{Id: "42", Login: "viewer"})
+
+			a := NewImplicitGrantAuthenticator("client-id", false, "http://localhost/callback", []ScopeType{ScopeChatRead}, "state")
+			u, err := a.GenerateAuthorizationUrl()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			redirect := authorize(t, s, u)
+			if tt.failure != nil {
+				s.InjectFailure("/oauth2/validate", *tt.failure)
+			}
+
+			tok, err := a.ParseRedirect(redirect)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRedirect() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && (tok.UserId != "42" || tok.Login != "viewer" || !tok.HasScopes(ScopeChatRead)) {
+				t.Errorf("ParseRedirect() = %+v, want viewer's token granted chat:read", tok)
+			}
+		})
+	}
+}
+
+func TestImplicitGrantAuthenticatorParseRedirectWithoutFailureData(t *testing.T) {
+	// A failed validation whose body decodes to no FailureData must not cause a nil dereference.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("null"))
+	}))
+	t.Cleanup(srv.Close)
+
+	a, err := NewImplicitGrantAuthenticatorWithOptions("abcdefghijklmnopqrstuvwxyz0123", "http://localhost/callback",
+		WithEndpoints(Endpoints{ValidationUrl: srv.URL}), WithHTTPClient(srv.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redirect, err := url.Parse("http://localhost/callback#access_token=token&token_type=bearer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = a.ParseRedirect(redirect)
+	if err == nil {
+		t.Fatal("ParseRedirect() error = nil, want a validation error")
+	}
+}
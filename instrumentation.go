@@ -0,0 +1,148 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// requestObservers stores the RequestObserver values notified of every outgoing request.
+var requestObservers atomic.Pointer[[]RequestObserver]
+
+/*
+RequestObserver is notified of every request sent to the Twitch OAuth endpoints. It allows tracing and metrics
+libraries to instrument ValidateToken, RevokeToken and every authenticator without wrapping them.
+
+Implementations must be safe for concurrent use.
+*/
+type RequestObserver interface {
+	// RequestStarted is called before a request is sent. The returned context is used for the remainder of the
+	// request, allowing spans to be attached to it.
+	RequestStarted(ctx context.Context, info RequestInfo) context.Context
+
+	// RequestFinished is called once a request has completed, whether it succeeded or not.
+	RequestFinished(ctx context.Context, info RequestInfo, result RequestResult)
+}
+
+// RequestInfo describes a request sent to one of the Twitch OAuth endpoints.
+type RequestInfo struct {
+	Endpoint EndpointType
+	// GrantType is the OAuth grant type of a token request (ex. "refresh_token"). It is empty for requests sent to
+	// any other endpoint.
+	GrantType string
+}
+
+// RequestResult describes the outcome of a request sent to one of the Twitch OAuth endpoints.
+type RequestResult struct {
+	// StatusCode is the HTTP status code returned by Twitch. It is 0 if no response was received.
+	StatusCode int
+	// Latency is the total time spent on the request, including any time spent waiting on the RateLimiter.
+	Latency   time.Duration
+	Err       error
+	ErrorKind ErrorKind
+}
+
+// ErrorKind classifies the reason a request sent to the Twitch OAuth endpoints did not succeed.
+type ErrorKind int
+
+const (
+	// ErrorKindNone signifies a successful request.
+	ErrorKindNone ErrorKind = iota
+	// ErrorKindCanceled signifies a request whose context was cancelled or timed out.
+	ErrorKindCanceled
+	// ErrorKindTransport signifies a request that failed before a response was received.
+	ErrorKindTransport
+	// ErrorKindRateLimited signifies a request rejected with HTTP 429.
+	ErrorKindRateLimited
+	// ErrorKindUnauthorized signifies a request rejected with HTTP 401 (ex. an invalid token).
+	ErrorKindUnauthorized
+	// ErrorKindClient signifies a request rejected with any other HTTP 4xx status.
+	ErrorKindClient
+	// ErrorKindServer signifies a request that failed with an HTTP 5xx status.
+	ErrorKindServer
+)
+
+var errorKindName = map[ErrorKind]string{
+	ErrorKindNone:         "",
+	ErrorKindCanceled:     "canceled",
+	ErrorKindTransport:    "transport",
+	ErrorKindRateLimited:  "rate_limited",
+	ErrorKindUnauthorized: "unauthorized",
+	ErrorKindClient:       "client_error",
+	ErrorKindServer:       "server_error",
+}
+
+func (k ErrorKind) String() string {
+	return errorKindName[k]
+}
+
+// SetRequestObservers installs the RequestObserver values notified of every outgoing request, replacing any that
+// were installed previously. Calling SetRequestObservers with no arguments removes every observer.
+func SetRequestObservers(observers ...RequestObserver) {
+	o := append([]RequestObserver(nil), observers...)
+	requestObservers.Store(&o)
+}
+
+// TokenExpiry describes the expiry of a single token held by a token cache.
+type TokenExpiry struct {
+	// UserId is the ID of the user the token belongs to. It is empty for app access tokens.
+	UserId   string
+	ClientId string
+	// ExpiresAt is zero for NonExpiring tokens.
+	ExpiresAt time.Time
+}
+
+// TokenExpirySource is implemented by token caches that expose the expiry of the tokens they manage to metrics
+// collectors.
+type TokenExpirySource interface {
+	TokenExpiries() []TokenExpiry
+}
+
+// classifyResult determines the ErrorKind of a completed request.
+func classifyResult(statusCode int, err error) ErrorKind {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ErrorKindCanceled
+	case err != nil:
+		return ErrorKindTransport
+	case statusCode == http.StatusTooManyRequests:
+		return ErrorKindRateLimited
+	case statusCode == http.StatusUnauthorized:
+		return ErrorKindUnauthorized
+	case statusCode >= 500:
+		return ErrorKindServer
+	case statusCode >= 400:
+		return ErrorKindClient
+	}
+
+	return ErrorKindNone
+}
+
+// observeRequest notifies every installed RequestObserver that a request has started. The returned function must be
+// called once the request has completed.
+func observeRequest(ctx context.Context, info RequestInfo) (context.Context, func(statusCode int, latency time.Duration, err error)) {
+	p := requestObservers.Load()
+	if p == nil || len(*p) == 0 {
+		return ctx, func(int, time.Duration, error) {}
+	}
+
+	observers := *p
+	for _, o := range observers {
+		ctx = o.RequestStarted(ctx, info)
+	}
+
+	return ctx, func(statusCode int, latency time.Duration, err error) {
+		result := RequestResult{
+			StatusCode: statusCode,
+			Latency:    latency,
+			Err:        err,
+			ErrorKind:  classifyResult(statusCode, err),
+		}
+
+		for _, o := range observers {
+			o.RequestFinished(ctx, info, result)
+		}
+	}
+}
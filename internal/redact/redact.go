@@ -0,0 +1,59 @@
+﻿/*
+Package redact removes secrets from the requests and responses exchanged with Twitch. It is shared by go_twitchAuth's
+logging and raw body capture and by twitchauthtest's Recorder, so that each redacts the same fields.
+*/
+package redact
+
+import "net/url"
+
+// Value replaces any secret removed from a request or response.
+const Value = "[REDACTED]"
+
+// sensitiveFields lists the form parameters and JSON fields whose values are secrets.
+var sensitiveFields = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+	"client_secret": true,
+	"code":          true,
+	"code_verifier": true,
+	"device_code":   true,
+	"token":         true,
+	"id_token":      true,
+}
+
+// Sensitive reports whether the value of the named form parameter or JSON field is a secret.
+func Sensitive(name string) bool {
+	return sensitiveFields[name]
+}
+
+// Values returns a copy of v with the values of sensitive parameters replaced.
+func Values(v url.Values) url.Values {
+	r := make(url.Values, len(v))
+	for k, vals := range v {
+		if sensitiveFields[k] {
+			r[k] = []string{Value}
+			continue
+		}
+		r[k] = vals
+	}
+
+	return r
+}
+
+// Decoded recursively replaces the values of sensitive fields in a decoded JSON value, in place.
+func Decoded(v any) {
+	switch c := v.(type) {
+	case map[string]any:
+		for k, e := range c {
+			if sensitiveFields[k] {
+				c[k] = Value
+				continue
+			}
+			Decoded(e)
+		}
+	case []any:
+		for _, e := range c {
+			Decoded(e)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+﻿package redact
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+)
+
+func TestValues(t *testing.T) {
+	v := url.Values{"client_id": {"id"}, "client_secret": {"s"}, "code": {"c1", "c2"}, "grant_type": {"authorization_code"}}
+
+	got := Values(v).Encode()
+	want := "client_id=id&client_secret=%5BREDACTED%5D&code=%5BREDACTED%5D&grant_type=authorization_code"
+	if got != want {
+		t.Errorf("Values() = %s, want %s", got, want)
+	}
+
+	if v.Get("client_secret") != "s" {
+		t.Error("Values() modified the supplied values")
+	}
+}
+
+func TestDecoded(t *testing.T) {
+	tests := map[string]struct {
+		body string
+		want string
+	}{
+		"object":           {body: `{"access_token":"a","expires_in":3600}`, want: `{"access_token":"[REDACTED]","expires_in":3600}`},
+		"nested object":    {body: `{"data":{"token":"t"},"status":400}`, want: `{"data":{"token":"[REDACTED]"},"status":400}`},
+		"array":            {body: `[{"refresh_token":"r"}]`, want: `[{"refresh_token":"[REDACTED]"}]`},
+		"nested arrays":    {body: `{"data":[[{"id_token":"i"}]]}`, want: `{"data":[[{"id_token":"[REDACTED]"}]]}`},
+		"sensitive object": {body: `{"token":{"value":"t"}}`, want: `{"token":"[REDACTED]"}`},
+		"no secrets":       {body: `{"scope":["chat:read"]}`, want: `{"scope":["chat:read"]}`},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var v any
+			err := json.Unmarshal([]byte(tt.body), &v)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			Decoded(v)
+
+			got, err := json.Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("Decoded() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+// logger is the slog.Logger used to log request and response summaries. A nil value disables logging.
+var logger atomic.Pointer[slog.Logger]
+
+/*
+SetLogger installs the slog.Logger used by ValidateToken, RevokeToken and every authenticator. Summaries of each
+request and response are logged at slog.LevelDebug. Passing nil disables logging.
+
+Access tokens, refresh tokens, client secrets and authorization codes are redacted from every logged URL, query
+string and body.
+*/
+func SetLogger(l *slog.Logger) {
+	logger.Store(l)
+}
+
+// logDebug logs msg at slog.LevelDebug using the installed logger, if any.
+func logDebug(ctx context.Context, msg string, args ...any) {
+	logTo(ctx, nil, slog.LevelDebug, msg, args...)
+}
+
+// logWarn logs msg at slog.LevelWarn using the installed logger, if any.
+func logWarn(ctx context.Context, msg string, args ...any) {
+	logTo(ctx, nil, slog.LevelWarn, msg, args...)
+}
+
+// logTo logs msg at the supplied level using l or, if l is nil, the installed logger, if any.
+func logTo(ctx context.Context, l *slog.Logger, level slog.Level, msg string, args ...any) {
+	if l == nil {
+		l = logger.Load()
+	}
+
+	if l == nil || !l.Enabled(ctx, level) {
+		return
+	}
+
+	l.Log(ctx, level, msg, args...)
+}
@@ -0,0 +1,640 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults used by LoginMiddleware when the matching option is not supplied.
+const (
+	defaultLoginCookieName      = "twitch_session"
+	defaultLoginSessionLifetime = 7 * 24 * time.Hour
+	defaultLoginPath            = "/login"
+)
+
+// minLoginKeyLength is the minimum length of the key used to sign LoginMiddleware cookies.
+const minLoginKeyLength = 32
+
+// ErrLoginSessionNotFound is returned by a LoginSessionStore when no token is stored for a session.
+var ErrLoginSessionNotFound = errors.New("login session not found")
+
+/*
+LoginSessionStore stores the token of each user logged in via a LoginMiddleware, keyed by a random session ID that
+is carried in the session cookie. Tokens never leave the server. Implementations can persist tokens (ex. in Redis) so
+that sessions survive restarts, and must be safe for concurrent use.
+*/
+type LoginSessionStore interface {
+	// Save stores the token for the session until expiresAt, replacing any token already stored.
+	Save(ctx context.Context, sessionId string, t *Token, expiresAt time.Time) error
+
+	// Load retrieves the token for the session, returning ErrLoginSessionNotFound if there is none.
+	Load(ctx context.Context, sessionId string) (*Token, error)
+
+	// Delete removes the token for the session, if any.
+	Delete(ctx context.Context, sessionId string) error
+}
+
+// MemoryLoginSessionStore is a LoginSessionStore that keeps tokens in memory. Expired sessions are discarded as new
+// ones are saved.
+//
+// New instances of MemoryLoginSessionStore should be created via NewMemoryLoginSessionStore.
+type MemoryLoginSessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]memoryLoginSession
+}
+
+// memoryLoginSession is a single token stored by a MemoryLoginSessionStore.
+type memoryLoginSession struct {
+	token     *Token
+	expiresAt time.Time
+}
+
+// NewMemoryLoginSessionStore generates a new MemoryLoginSessionStore instance.
+func NewMemoryLoginSessionStore() *MemoryLoginSessionStore {
+	return &MemoryLoginSessionStore{sessions: map[string]memoryLoginSession{}}
+}
+
+// Save stores the token for the session, discarding any sessions that have expired.
+func (m *MemoryLoginSessionStore) Save(_ context.Context, sessionId string, t *Token, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for id, s := range m.sessions {
+		if now.After(s.expiresAt) {
+			delete(m.sessions, id)
+		}
+	}
+
+	m.sessions[sessionId] = memoryLoginSession{token: t, expiresAt: expiresAt}
+
+	return nil
+}
+
+// Load retrieves the token for the session.
+func (m *MemoryLoginSessionStore) Load(_ context.Context, sessionId string) (*Token, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sessionId]
+	if !ok || time.Now().After(s.expiresAt) {
+		return nil, ErrLoginSessionNotFound
+	}
+
+	return s.token, nil
+}
+
+// Delete removes the token for the session.
+func (m *MemoryLoginSessionStore) Delete(_ context.Context, sessionId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, sessionId)
+
+	return nil
+}
+
+// TwitchUser is the user logged in via a LoginMiddleware. It is added to the context of requests passed through
+// RequireTwitchUser, and can be retrieved via TwitchUserFromContext.
+type TwitchUser struct {
+	UserId string
+	Login  string
+	Scopes []ScopeType
+	// TokenSource supplies the user's access token, refreshing it as needed. It can be passed to NewHelixClient to
+	// call the Helix API on the user's behalf.
+	TokenSource *UserTokenSource
+}
+
+// twitchUserKey is the context key under which RequireTwitchUser stores the TwitchUser.
+type twitchUserKey struct{}
+
+// TwitchUserFromContext retrieves the TwitchUser added to ctx by RequireTwitchUser.
+func TwitchUserFromContext(ctx context.Context) (*TwitchUser, bool) {
+	u, ok := ctx.Value(twitchUserKey{}).(*TwitchUser)
+	return u, ok
+}
+
+// LoginMiddlewareOption configures a LoginMiddleware created via NewLoginMiddleware.
+type LoginMiddlewareOption func(*LoginMiddleware) error
+
+// WithLoginSessionStore sets the LoginSessionStore that user tokens are kept in. By default, tokens are kept in a
+// MemoryLoginSessionStore.
+func WithLoginSessionStore(s LoginSessionStore) LoginMiddlewareOption {
+	return func(m *LoginMiddleware) error {
+		if s == nil {
+			return errors.New("WithLoginSessionStore: store must not be nil")
+		}
+
+		m.store = s
+		return nil
+	}
+}
+
+// WithLoginCookieName sets the name of the session cookie. Defaults to "twitch_session".
+func WithLoginCookieName(name string) LoginMiddlewareOption {
+	return func(m *LoginMiddleware) error {
+		if name == "" || strings.ContainsAny(name, " \t\r\n;=,") {
+			e := fmt.Sprintf("WithLoginCookieName: invalid cookie name %q", name)
+			return errors.New(e)
+		}
+
+		m.cookieName = name
+		return nil
+	}
+}
+
+// WithLoginSessionLifetime sets how long a user stays logged in. Defaults to seven days.
+func WithLoginSessionLifetime(d time.Duration) LoginMiddlewareOption {
+	return func(m *LoginMiddleware) error {
+		if d <= 0 {
+			return errors.New("WithLoginSessionLifetime: lifetime must be positive")
+		}
+
+		m.lifetime = d
+		return nil
+	}
+}
+
+// WithLoginPath sets the path that RequireTwitchUser redirects browsers to when no user is logged in. It should be
+// the path that LoginHandler is served on. Defaults to "/login".
+func WithLoginPath(path string) LoginMiddlewareOption {
+	return func(m *LoginMiddleware) error {
+		if !isLocalPath(path) {
+			e := fmt.Sprintf("WithLoginPath: %q is not an absolute path", path)
+			return errors.New(e)
+		}
+
+		m.loginPath = path
+		return nil
+	}
+}
+
+// WithInsecureCookies allows cookies to be sent over plain HTTP, which is required when serving an app from
+// http://localhost during development. Cookies are marked Secure by default.
+func WithInsecureCookies() LoginMiddlewareOption {
+	return func(m *LoginMiddleware) error {
+		m.insecureCookies = true
+		return nil
+	}
+}
+
+/*
+LoginMiddleware adds "login with Twitch" to a net/http app using an AuthorizationCodeGrantAuthenticator.
+
+LoginHandler, CallbackHandler and LogoutHandler should be served on the app's login path, redirect URI path and
+logout path respectively. Once a user has logged in, their user ID and login (as reported by ValidateToken) are
+carried in a session cookie signed with HMAC-SHA256, while their token is kept in a LoginSessionStore. Routes wrapped
+with RequireTwitchUser are only served to logged-in users, and the user's token is refreshed behind the scenes as it
+nears expiry.
+
+New instances of LoginMiddleware should be created via NewLoginMiddleware.
+*/
+type LoginMiddleware struct {
+	authenticator   *AuthorizationCodeGrantAuthenticator
+	key             []byte
+	store           LoginSessionStore
+	cookieName      string
+	lifetime        time.Duration
+	loginPath       string
+	insecureCookies bool
+
+	mu      sync.Mutex
+	sources map[string]loginTokenSource
+}
+
+// loginTokenSource is the UserTokenSource cached for a single session, so that concurrent requests share refreshes.
+type loginTokenSource struct {
+	source    *UserTokenSource
+	expiresAt time.Time
+}
+
+// loginCookie is the signed payload of the session cookie.
+type loginCookie struct {
+	SessionId string `json:"sid"`
+	UserId    string `json:"uid"`
+	Login     string `json:"login"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// pendingLoginCookie is the signed payload of the cookie that binds a login in progress to the browser that started
+// it, preventing an attacker from completing a login in a victim's browser.
+type pendingLoginCookie struct {
+	State     string `json:"state"`
+	ReturnTo  string `json:"return_to"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+/*
+NewLoginMiddleware generates a new LoginMiddleware instance for the supplied authenticator. The key is used to sign
+cookies, must be at least 32 bytes long and should be kept secret and stable across restarts.
+*/
+func NewLoginMiddleware(a *AuthorizationCodeGrantAuthenticator, key []byte, opts ...LoginMiddlewareOption) (*LoginMiddleware, error) {
+	if a == nil {
+		return nil, errors.New("an authenticator is required")
+	}
+
+	if len(key) < minLoginKeyLength {
+		e := fmt.Sprintf("cookie signing key must be at least %d bytes long", minLoginKeyLength)
+		return nil, errors.New(e)
+	}
+
+	m := &LoginMiddleware{
+		authenticator: a,
+		key:           append([]byte(nil), key...),
+		store:         NewMemoryLoginSessionStore(),
+		cookieName:    defaultLoginCookieName,
+		lifetime:      defaultLoginSessionLifetime,
+		loginPath:     defaultLoginPath,
+		sources:       map[string]loginTokenSource{},
+	}
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+
+		err := opt(m)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+/*
+LoginHandler starts a login via BeginAuth and redirects the user to Twitch. The optional return_to query parameter
+sets the local path the user is sent to once logged in, defaulting to "/".
+*/
+func (m *LoginMiddleware) LoginHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		returnTo := r.URL.Query().Get("return_to")
+		if !isLocalPath(returnTo) {
+			returnTo = "/"
+		}
+
+		s, err := m.authenticator.BeginAuthWithContext(r.Context(), AuthOptions{})
+		if err != nil {
+			logTo(r.Context(), m.authenticator.logger, slog.LevelWarn, "twitch login could not be started", slog.Any("error", err))
+			http.Error(w, "twitch login could not be started", http.StatusInternalServerError)
+			return
+		}
+
+		m.setCookie(w, m.pendingCookieName(), pendingLoginCookie{
+			State:     s.State,
+			ReturnTo:  returnTo,
+			ExpiresAt: s.ExpiresAt.Unix(),
+		}, s.ExpiresAt)
+
+		http.Redirect(w, r, s.AuthorizationUrl, http.StatusFound)
+	})
+}
+
+/*
+CallbackHandler completes a login when Twitch redirects the user back. The code is exchanged via CompleteAuth and
+the token validated via ValidateToken to identify the user, before the session cookie is set and the user is sent on
+to the path supplied to LoginHandler.
+
+The callback is rejected with a 401 if it was not started by LoginHandler in the same browser, or if the user
+denied access.
+*/
+func (m *LoginMiddleware) CallbackHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var p pendingLoginCookie
+		ok := m.readCookie(r, m.pendingCookieName(), &p)
+		m.clearCookie(w, m.pendingCookieName())
+		if !ok || p.State == "" || p.State != r.URL.Query().Get("state") {
+			http.Error(w, "twitch login was not started by this browser", http.StatusUnauthorized)
+			return
+		}
+
+		t, err := m.completeLogin(ctx, r.URL)
+		if err != nil {
+			logTo(ctx, m.authenticator.logger, slog.LevelWarn, "twitch login failed", slog.Any("error", err))
+			http.Error(w, "twitch login failed", http.StatusUnauthorized)
+			return
+		}
+
+		sessionId, err := randomToken()
+		if err != nil {
+			http.Error(w, "twitch login failed", http.StatusInternalServerError)
+			return
+		}
+
+		expiresAt := time.Now().Add(m.lifetime)
+		err = m.store.Save(ctx, sessionId, t, expiresAt)
+		if err != nil {
+			logTo(ctx, m.authenticator.logger, slog.LevelWarn, "twitch login session could not be saved", slog.Any("error", err))
+			http.Error(w, "twitch login failed", http.StatusInternalServerError)
+			return
+		}
+
+		m.setCookie(w, m.cookieName, loginCookie{
+			SessionId: sessionId,
+			UserId:    t.UserId,
+			Login:     t.Login,
+			ExpiresAt: expiresAt.Unix(),
+		}, expiresAt)
+
+		http.Redirect(w, r, p.ReturnTo, http.StatusFound)
+	})
+}
+
+// completeLogin exchanges the code in the callback URL and identifies the user the resulting token belongs to.
+func (m *LoginMiddleware) completeLogin(ctx context.Context, callbackUrl *url.URL) (*Token, error) {
+	res, err := m.authenticator.CompleteAuthWithContext(ctx, callbackUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.TokenRequestStatus != StatusSuccess {
+		return nil, tokenRequestError(res.FailureData)
+	}
+
+	v, err := validateToken(ctx, res.Token.AccessToken, m.authenticator.requestConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.ValidationStatus != StatusSuccess {
+		return nil, errors.New("token returned by twitch failed validation")
+	}
+
+	t := *res.Token
+	t.UserId = v.ValidationData.UserId
+	t.Login = v.ValidationData.Login
+
+	return &t, nil
+}
+
+/*
+LogoutHandler ends the user's session: their token is revoked and removed from the LoginSessionStore, the session
+cookie is cleared and the user is redirected to "/". Since it changes state, requests other than POST are rejected
+with a 405, so that logouts can't be triggered by links or images on other sites.
+*/
+func (m *LoginMiddleware) LogoutHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var c loginCookie
+		if m.readCookie(r, m.cookieName, &c) {
+			t, err := m.store.Load(ctx, c.SessionId)
+			if err == nil {
+				_, err = revokeToken(ctx, m.authenticator.clientId, t.AccessToken, m.authenticator.requestConfig)
+				if err != nil {
+					logTo(ctx, m.authenticator.logger, slog.LevelWarn, "twitch token could not be revoked on logout", slog.Any("error", err))
+				}
+			}
+
+			m.endSession(ctx, c.SessionId)
+		}
+
+		m.clearCookie(w, m.cookieName)
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
+}
+
+/*
+RequireTwitchUser wraps a handler so that it is only served to logged-in users who have granted every supplied
+scope. The TwitchUser is added to the request's context, and can be retrieved via TwitchUserFromContext.
+
+If no user is logged in, browsers (GET or HEAD requests accepting text/html) are redirected to the login path, and
+other requests receive a 401. Users missing a scope receive a 403. The user's token is refreshed if it has expired
+or is about to. If Twitch rejects the refresh token, the session is ended and the user must log in again; if the
+refresh fails for any other reason (ex. a timeout or an outage at Twitch), or the LoginSessionStore cannot be read,
+the request receives a 503 and the session is kept.
+*/
+func (m *LoginMiddleware) RequireTwitchUser(scopes ...ScopeType) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, err := m.user(r)
+			if err != nil && !errors.Is(err, ErrLoginSessionNotFound) {
+				logTo(r.Context(), m.authenticator.logger, slog.LevelWarn, "twitch login session could not be loaded", slog.Any("error", err))
+				http.Error(w, "twitch login session is unavailable", http.StatusServiceUnavailable)
+				return
+			}
+
+			if err != nil {
+				m.clearCookie(w, m.cookieName)
+				m.unauthorized(w, r)
+				return
+			}
+
+			t, err := u.TokenSource.Token(r.Context())
+			if err != nil {
+				logTo(r.Context(), m.authenticator.logger, slog.LevelWarn, "twitch token could not be refreshed", slog.String("user_id", u.UserId), slog.Any("error", err))
+				if !isRefreshPermanentFailure(err) {
+					http.Error(w, "twitch token could not be refreshed", http.StatusServiceUnavailable)
+					return
+				}
+
+				var c loginCookie
+				if m.readCookie(r, m.cookieName, &c) {
+					m.endSession(r.Context(), c.SessionId)
+				}
+				m.clearCookie(w, m.cookieName)
+				m.unauthorized(w, r)
+				return
+			}
+
+			u.Scopes = append([]ScopeType(nil), t.Scopes...)
+			if !t.HasScopes(scopes...) {
+				http.Error(w, "missing required twitch scopes", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), twitchUserKey{}, u)))
+		})
+	}
+}
+
+// isRefreshPermanentFailure reports whether err means that a user's token can never be refreshed, as opposed to a
+// failure that may succeed if retried.
+func isRefreshPermanentFailure(err error) bool {
+	return errors.Is(err, ErrRefreshTokenRejected) || errors.Is(err, ErrNoRefreshToken) || errors.Is(err, ErrNoToken)
+}
+
+// user identifies the user logged in via the request's session cookie.
+func (m *LoginMiddleware) user(r *http.Request) (*TwitchUser, error) {
+	var c loginCookie
+	if !m.readCookie(r, m.cookieName, &c) {
+		return nil, ErrLoginSessionNotFound
+	}
+
+	s, err := m.tokenSource(r.Context(), c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TwitchUser{UserId: c.UserId, Login: c.Login, TokenSource: s}, nil
+}
+
+// tokenSource retrieves the UserTokenSource for the session, loading its token from the LoginSessionStore if it is
+// not already cached. Refreshed tokens are saved back to the store.
+func (m *LoginMiddleware) tokenSource(ctx context.Context, c loginCookie) (*UserTokenSource, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if s, ok := m.sources[c.SessionId]; ok && now.Before(s.expiresAt) {
+		return s.source, nil
+	}
+
+	t, err := m.store.Load(ctx, c.SessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	for id, s := range m.sources {
+		if now.After(s.expiresAt) {
+			delete(m.sources, id)
+		}
+	}
+
+	expiresAt := time.Unix(c.ExpiresAt, 0)
+	s := NewUserTokenSource(m.authenticator, t, func(t *Token) {
+		err := m.store.Save(context.Background(), c.SessionId, t, expiresAt)
+		if err != nil {
+			logTo(ctx, m.authenticator.logger, slog.LevelWarn, "refreshed twitch token could not be saved", slog.String("user_id", t.UserId), slog.Any("error", err))
+		}
+	})
+	m.sources[c.SessionId] = loginTokenSource{source: s, expiresAt: expiresAt}
+
+	return s, nil
+}
+
+// endSession removes the session's token from the LoginSessionStore and the cache.
+func (m *LoginMiddleware) endSession(ctx context.Context, sessionId string) {
+	m.mu.Lock()
+	delete(m.sources, sessionId)
+	m.mu.Unlock()
+
+	err := m.store.Delete(ctx, sessionId)
+	if err != nil {
+		logTo(ctx, m.authenticator.logger, slog.LevelWarn, "twitch login session could not be deleted", slog.Any("error", err))
+	}
+}
+
+// unauthorized redirects browsers to the login path and rejects other requests with a 401.
+func (m *LoginMiddleware) unauthorized(w http.ResponseWriter, r *http.Request) {
+	isBrowser := (r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
+	if !isBrowser {
+		http.Error(w, "twitch login required", http.StatusUnauthorized)
+		return
+	}
+
+	http.Redirect(w, r, m.loginPath+"?"+url.Values{"return_to": {r.URL.RequestURI()}}.Encode(), http.StatusFound)
+}
+
+// pendingCookieName retrieves the name of the cookie set while a login is in progress.
+func (m *LoginMiddleware) pendingCookieName() string {
+	return m.cookieName + "_login"
+}
+
+// setCookie sets a cookie carrying the signed JSON encoding of v.
+func (m *LoginMiddleware) setCookie(w http.ResponseWriter, name string, v any, expiresAt time.Time) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    payload + "." + m.sign(name, payload),
+		Path:     "/",
+		Expires:  expiresAt,
+		Secure:   !m.insecureCookies,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// readCookie verifies the named cookie's signature and expiry, and decodes its payload into v.
+func (m *LoginMiddleware) readCookie(r *http.Request, name string, v any) bool {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return false
+	}
+
+	payload, sig, ok := strings.Cut(c.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(m.sign(name, payload))) {
+		return false
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return false
+	}
+
+	var exp struct {
+		ExpiresAt int64 `json:"exp"`
+	}
+	if json.Unmarshal(b, &exp) != nil || time.Now().Unix() >= exp.ExpiresAt {
+		return false
+	}
+
+	return json.Unmarshal(b, v) == nil
+}
+
+// clearCookie removes the named cookie from the browser.
+func (m *LoginMiddleware) clearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   !m.insecureCookies,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// sign computes the HMAC-SHA256 signature of a cookie's payload. The cookie name is included so that one cookie
+// cannot be substituted for another.
+func (m *LoginMiddleware) sign(name string, payload string) string {
+	h := hmac.New(sha256.New, m.key)
+	h.Write([]byte(name + "." + payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// isLocalPath reports whether path is an absolute path on the current host, and so safe to redirect to. Control
+// characters and backslashes are rejected outright, as browsers strip or normalize them in ways that can turn a path
+// into a link to another host (ex. "/\t/evil.example" becomes "//evil.example").
+func isLocalPath(path string) bool {
+	unsafe := func(r rune) bool {
+		return r < 0x20 || r == 0x7f || r == '\\'
+	}
+
+	u, err := url.Parse(path)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return false
+	}
+
+	for _, p := range []string{path, u.Path} {
+		if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.IndexFunc(p, unsafe) >= 0 {
+			return false
+		}
+	}
+
+	return true
+}
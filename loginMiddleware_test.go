@@ -0,0 +1,375 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adamsurek/go-twitchAuth/twitchauthtest"
+)
+
+// testLoginKey is the cookie signing key used by newTestLoginMiddleware.
+var testLoginKey = []byte(strings.Repeat("k", minLoginKeyLength))
+
+// newTestLoginMiddleware routes requests to a new fake Twitch server and creates a LoginMiddleware for an app
+// registered with it.
+func newTestLoginMiddleware(t *testing.T) (*twitchauthtest.Server, *LoginMiddleware, *MemoryLoginSessionStore) {
+	t.Helper()
+
+	s := useFakeServer(t)
+	s.RegisterApp("client-id", "client-secret", "http://localhost/callback")
+
+	a := NewAuthorizationCodeGrantAuthenticator("client-id", "client-secret", false, "http://localhost/callback", []ScopeType{ScopeUserReadEmail}, "")
+	store := NewMemoryLoginSessionStore()
+	m, err := NewLoginMiddleware(a, testLoginKey, WithLoginSessionStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, m, store
+}
+
+// startLogin serves LoginHandler, returning the authorization URL it redirected to and the pending login cookie.
+func startLogin(t *testing.T, m *LoginMiddleware) (*url.URL, *http.Cookie) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	m.LoginHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login?return_to=/dashboard", nil))
+
+	res := w.Result()
+	authUrl, err := res.Location()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := findCookie(res, m.pendingCookieName())
+	if c == nil {
+		t.Fatal("LoginHandler did not set the pending login cookie")
+	}
+
+	return authUrl, c
+}
+
+// logIn completes a login, returning the session cookie.
+func logIn(t *testing.T, s *twitchauthtest.Server, m *LoginMiddleware) *http.Cookie {
+	t.Helper()
+
+	authUrl, pending := startLogin(t, m)
+
+	req := httptest.NewRequest(http.MethodGet, authorize(t, s, authUrl).String(), nil)
+	req.AddCookie(pending)
+
+	w := httptest.NewRecorder()
+	m.CallbackHandler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/dashboard" {
+		t.Fatalf("callback = %d (Location %q), want 302 (Location /dashboard)", w.Code, w.Header().Get("Location"))
+	}
+
+	c := findCookie(w.Result(), m.cookieName)
+	if c == nil {
+		t.Fatal("CallbackHandler did not set the session cookie")
+	}
+
+	return c
+}
+
+// findCookie retrieves the named cookie set by the response, if any.
+func findCookie(res *http.Response, name string) *http.Cookie {
+	for _, c := range res.Cookies() {
+		if c.Name == name && c.MaxAge >= 0 {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// sessionId decodes the session ID carried in a session cookie.
+func sessionId(t *testing.T, m *LoginMiddleware, cookie *http.Cookie) string {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+
+	var c loginCookie
+	if !m.readCookie(req, m.cookieName, &c) {
+		t.Fatal("session cookie could not be read")
+	}
+
+	return c.SessionId
+}
+
+func TestIsLocalPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/", true},
+		{"/dashboard?tab=1", true},
+		{"/a/b#c", true},
+		{"", false},
+		{"dashboard", false},
+		{"//evil.example", false},
+		{"/\\evil.example", false},
+		{"/\t/evil.example", false},
+		{"/\n/evil.example", false},
+		{"/%2F/evil.example", false},
+		{"/%09/evil.example", false},
+		{"https://evil.example/", false},
+		{"/\x7f/evil.example", false},
+	}
+
+	for _, tt := range tests {
+		if got := isLocalPath(tt.path); got != tt.want {
+			t.Errorf("isLocalPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestLogoutHandlerRequiresPost(t *testing.T) {
+	a := NewAuthorizationCodeGrantAuthenticator("client-id", "client-secret", false, "http://localhost/callback", []ScopeType{ScopeUserReadEmail}, "")
+	m, err := NewLoginMiddleware(a, []byte(strings.Repeat("k", minLoginKeyLength)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	m.LogoutHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/logout", nil))
+
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("GET /logout = %d (Allow %q), want 405 (Allow POST)", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestLoginMiddlewareCookieSignature(t *testing.T) {
+	m, err := NewLoginMiddleware(NewAuthorizationCodeGrantAuthenticator("client-id", "client-secret", false, "http://localhost/callback", nil, ""), testLoginKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := func(name string, c loginCookie) *http.Cookie {
+		w := httptest.NewRecorder()
+		m.setCookie(w, name, c, time.Unix(c.ExpiresAt, 0))
+		return w.Result().Cookies()[0]
+	}
+
+	valid := loginCookie{SessionId: "session", UserId: "1", Login: "user", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	payload, sig, _ := strings.Cut(signed(m.cookieName, valid).Value, ".")
+	forged := signed(m.cookieName, loginCookie{SessionId: "session", UserId: "2", Login: "admin", ExpiresAt: valid.ExpiresAt})
+	forgedPayload, _, _ := strings.Cut(forged.Value, ".")
+
+	other, err := NewLoginMiddleware(m.authenticator, []byte(strings.Repeat("o", minLoginKeyLength)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	other.setCookie(w, m.cookieName, valid, time.Unix(valid.ExpiresAt, 0))
+	otherKey := w.Result().Cookies()[0].Value
+
+	tests := map[string]struct {
+		value string
+		ok    bool
+	}{
+		"valid":              {value: payload + "." + sig, ok: true},
+		"tampered payload":   {value: forgedPayload + "." + sig},
+		"tampered signature": {value: payload + "." + strings.Repeat("A", len(sig))},
+		"missing signature":  {value: payload},
+		"other key":          {value: otherKey},
+		"other cookie name":  {value: signed(m.pendingCookieName(), valid).Value},
+		"expired":            {value: signed(m.cookieName, loginCookie{SessionId: "session", ExpiresAt: time.Now().Add(-time.Minute).Unix()}).Value},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(&http.Cookie{Name: m.cookieName, Value: tt.value})
+
+			var c loginCookie
+			ok := m.readCookie(req, m.cookieName, &c)
+			if ok != tt.ok {
+				t.Fatalf("readCookie() = %t, want %t", ok, tt.ok)
+			}
+
+			if ok && c != valid {
+				t.Errorf("readCookie() decoded %+v, want %+v", c, valid)
+			}
+		})
+	}
+}
+
+func TestCallbackHandlerRequiresPendingLogin(t *testing.T) {
+	s, m, _ := newTestLoginMiddleware(t)
+
+	t.Run("completed in the starting browser", func(t *testing.T) {
+		logIn(t, s, m)
+	})
+
+	tests := map[string]func(callback *url.URL, pending *http.Cookie, other *http.Cookie) *http.Request{
+		"no pending cookie": func(callback *url.URL, _ *http.Cookie, _ *http.Cookie) *http.Request {
+			return httptest.NewRequest(http.MethodGet, callback.String(), nil)
+		},
+		"cookie from another login": func(callback *url.URL, _ *http.Cookie, other *http.Cookie) *http.Request {
+			req := httptest.NewRequest(http.MethodGet, callback.String(), nil)
+			req.AddCookie(other)
+			return req
+		},
+		"tampered cookie": func(callback *url.URL, pending *http.Cookie, _ *http.Cookie) *http.Request {
+			req := httptest.NewRequest(http.MethodGet, callback.String(), nil)
+			req.AddCookie(&http.Cookie{Name: pending.Name, Value: "x" + pending.Value})
+			return req
+		},
+		"state mismatch": func(callback *url.URL, pending *http.Cookie, _ *http.Cookie) *http.Request {
+			q := callback.Query()
+			q.Set("state", "forged-state")
+			callback.RawQuery = q.Encode()
+
+			req := httptest.NewRequest(http.MethodGet, callback.String(), nil)
+			req.AddCookie(pending)
+			return req
+		},
+	}
+
+	for name, build := range tests {
+		t.Run(name, func(t *testing.T) {
+			authUrl, pending := startLogin(t, m)
+			_, other := startLogin(t, m)
+
+			w := httptest.NewRecorder()
+			m.CallbackHandler().ServeHTTP(w, build(authorize(t, s, authUrl), pending, other))
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("callback status = %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+
+			if findCookie(w.Result(), m.cookieName) != nil {
+				t.Error("callback set a session cookie")
+			}
+		})
+	}
+}
+
+func TestRequireTwitchUser(t *testing.T) {
+	s, m, _ := newTestLoginMiddleware(t)
+	session := logIn(t, s, m)
+
+	var got *TwitchUser
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = TwitchUserFromContext(r.Context())
+	})
+
+	tests := map[string]struct {
+		scopes       []ScopeType
+		cookie       *http.Cookie
+		accept       string
+		wantStatus   int
+		wantLocation string
+	}{
+		"logged in":               {scopes: []ScopeType{ScopeUserReadEmail}, cookie: session, wantStatus: http.StatusOK},
+		"missing scope":           {scopes: []ScopeType{ScopeChannelReadSubscriptions}, cookie: session, wantStatus: http.StatusForbidden},
+		"not logged in (api)":     {wantStatus: http.StatusUnauthorized},
+		"not logged in (browser)": {accept: "text/html", wantStatus: http.StatusFound, wantLocation: "/login?return_to=%2Fprofile%3Ftab%3D1"},
+		"forged cookie":           {cookie: &http.Cookie{Name: session.Name, Value: "x" + session.Value}, wantStatus: http.StatusUnauthorized},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got = nil
+
+			req := httptest.NewRequest(http.MethodGet, "/profile?tab=1", nil)
+			req.Header.Set("Accept", tt.accept)
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+
+			w := httptest.NewRecorder()
+			m.RequireTwitchUser(tt.scopes...)(next).ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			if loc := w.Header().Get("Location"); loc != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", loc, tt.wantLocation)
+			}
+
+			if (got != nil) != (tt.wantStatus == http.StatusOK) {
+				t.Fatalf("next handler called = %t, want %t", got != nil, tt.wantStatus == http.StatusOK)
+			}
+
+			if got != nil && (got.UserId != "12345" || got.Login != "twitchauthtest" || got.TokenSource == nil) {
+				t.Errorf("TwitchUser = %+v, want the user who logged in", got)
+			}
+		})
+	}
+}
+
+func TestRequireTwitchUserRefresh(t *testing.T) {
+	tests := map[string]struct {
+		failure     *twitchauthtest.Failure
+		wantStatus  int
+		wantSession bool
+	}{
+		"refreshed":        {wantStatus: http.StatusOK, wantSession: true},
+		"twitch outage":    {failure: &twitchauthtest.Failure{Status: http.StatusServiceUnavailable, Message: "unavailable"}, wantStatus: http.StatusServiceUnavailable, wantSession: true},
+		"rate limited":     {failure: &twitchauthtest.Failure{Status: http.StatusTooManyRequests, Message: "too many requests"}, wantStatus: http.StatusServiceUnavailable, wantSession: true},
+		"refresh rejected": {failure: &twitchauthtest.Failure{Status: http.StatusBadRequest, Message: "Invalid refresh token"}, wantStatus: http.StatusUnauthorized},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, m, store := newTestLoginMiddleware(t)
+			cookie := logIn(t, s, m)
+			id := sessionId(t, m, cookie)
+
+			stored, err := store.Load(context.Background(), id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stale := stored.AccessToken
+			stored.ExpiresAt = time.Now().Add(tokenRenewalMargin / 2)
+
+			if tt.failure != nil {
+				s.InjectFailure("/oauth2/token", *tt.failure)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+			req.AddCookie(cookie)
+
+			w := httptest.NewRecorder()
+			m.RequireTwitchUser()(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			current, err := store.Load(context.Background(), id)
+			if tt.wantSession != (err == nil) {
+				t.Fatalf("session kept = %t, want %t (Load() error = %v)", err == nil, tt.wantSession, err)
+			}
+
+			if !tt.wantSession && !errors.Is(err, ErrLoginSessionNotFound) {
+				t.Errorf("Load() error = %v, want %v", err, ErrLoginSessionNotFound)
+			}
+
+			cleared := false
+			for _, c := range w.Result().Cookies() {
+				cleared = cleared || (c.Name == m.cookieName && c.MaxAge < 0)
+			}
+
+			if cleared == tt.wantSession {
+				t.Errorf("session cookie cleared = %t, want %t", cleared, !tt.wantSession)
+			}
+
+			if tt.wantStatus == http.StatusOK && current.AccessToken == stale {
+				t.Error("refreshed token was not saved to the store")
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+﻿package go_twitchAuth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+/*
+AuthenticatorOption configures an authenticator created via one of the WithOptions constructors, ex.
+NewAuthorizationCodeGrantAuthenticatorWithOptions.
+
+Options are validated when the authenticator is created. Supplying an option that an authenticator does not support
+(ex. WithState to NewClientCredentialsGrantAuthenticatorWithOptions) is an error.
+*/
+type AuthenticatorOption func(*authenticatorOptions) error
+
+// authenticatorOptions stores the values collected from a list of AuthenticatorOption.
+type authenticatorOptions struct {
+	forceVerify bool
+	state       string
+	scopes      []ScopeType
+	// registeredRedirectUris is the allow-list that redirect URIs are checked against. Empty allows any valid URI.
+	registeredRedirectUris []string
+	sessionStore           AuthSessionStore
+	sessionTTL             time.Duration
+	requestConfig
+	// applied records the name of every supplied option, so that unsupported ones can be rejected.
+	applied []string
+}
+
+// requestConfig stores the per-authenticator overrides of the package-level request configuration.
+type requestConfig struct {
+	httpClient *http.Client
+	endpoints  Endpoints
+	logger     *slog.Logger
+}
+
+// WithForceVerify sets whether the user is forced to re-authorize the app, even if they have already done so.
+func WithForceVerify(forceVerify bool) AuthenticatorOption {
+	return func(o *authenticatorOptions) error {
+		o.applied = append(o.applied, "WithForceVerify")
+		o.forceVerify = forceVerify
+		return nil
+	}
+}
+
+// WithState sets the state included in the authorization URL and checked when Twitch redirects the user back.
+func WithState(state string) AuthenticatorOption {
+	return func(o *authenticatorOptions) error {
+		o.applied = append(o.applied, "WithState")
+		o.state = state
+		return nil
+	}
+}
+
+// WithScopes sets the scopes requested from the user.
+func WithScopes(scopes ...ScopeType) AuthenticatorOption {
+	return func(o *authenticatorOptions) error {
+		o.applied = append(o.applied, "WithScopes")
+		for i, s := range scopes {
+			if _, ok := scopeTypeName[s]; !ok {
+				return fmt.Errorf("WithScopes: %w: scope %d is an unknown ScopeType (%d)", ErrInvalidScopes, i, s)
+			}
+		}
+
+		o.scopes = append([]ScopeType(nil), scopes...)
+		return nil
+	}
+}
+
+// WithRegisteredRedirectUris sets the redirect URIs registered for the app in the Twitch developer console. The
+// authenticator's redirect URI must exactly match one of them.
+func WithRegisteredRedirectUris(uris ...string) AuthenticatorOption {
+	return func(o *authenticatorOptions) error {
+		o.applied = append(o.applied, "WithRegisteredRedirectUris")
+		for _, u := range uris {
+			err := ValidateRedirectUri(u)
+			if err != nil {
+				return fmt.Errorf("WithRegisteredRedirectUris: %w", err)
+			}
+		}
+
+		o.registeredRedirectUris = append([]string(nil), uris...)
+		return nil
+	}
+}
+
+// WithHTTPClient sets the http.Client used by the authenticator in place of the one installed via SetHTTPClient.
+func WithHTTPClient(c *http.Client) AuthenticatorOption {
+	return func(o *authenticatorOptions) error {
+		o.applied = append(o.applied, "WithHTTPClient")
+		if c == nil {
+			return errors.New("WithHTTPClient: client must not be nil")
+		}
+
+		o.httpClient = c
+		return nil
+	}
+}
+
+// WithEndpoints sets the Twitch OAuth endpoints used by the authenticator. Empty fields fall back to Twitch's
+// production endpoints.
+func WithEndpoints(e Endpoints) AuthenticatorOption {
+	return func(o *authenticatorOptions) error {
+		o.applied = append(o.applied, "WithEndpoints")
+		err := checkEndpoints("WithEndpoints", e)
+		if err != nil {
+			return err
+		}
+
+		o.endpoints = e
+		return nil
+	}
+}
+
+// checkEndpoints confirms that every non-empty field of e is an absolute URL. The option name prefixes any error.
+func checkEndpoints(option string, e Endpoints) error {
+	for _, u := range []string{e.AuthorizationUrl, e.TokenUrl, e.ValidationUrl, e.RevocationUrl, e.DeviceUrl} {
+		if u == "" {
+			continue
+		}
+
+		p, err := url.Parse(u)
+		if err != nil || !p.IsAbs() || p.Host == "" {
+			e := fmt.Sprintf("%s: invalid endpoint URL %q", option, u)
+			return errors.New(e)
+		}
+	}
+
+	return nil
+}
+
+// WithLogger sets the slog.Logger used by the authenticator in place of the one installed via SetLogger.
+func WithLogger(l *slog.Logger) AuthenticatorOption {
+	return func(o *authenticatorOptions) error {
+		o.applied = append(o.applied, "WithLogger")
+		if l == nil {
+			return errors.New("WithLogger: logger must not be nil")
+		}
+
+		o.logger = l
+		return nil
+	}
+}
+
+// applyOptions collects the supplied options, rejecting any that are not in supported.
+func applyOptions(authenticator string, opts []AuthenticatorOption, supported ...string) (*authenticatorOptions, error) {
+	o := authenticatorOptions{}
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+
+		err := opt(&o)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range o.applied {
+		if !slices.Contains(supported, name) {
+			e := fmt.Sprintf("%s is not supported by %s", name, authenticator)
+			return nil, errors.New(e)
+		}
+	}
+
+	o.endpoints = o.endpoints.withDefaults()
+
+	return &o, nil
+}
+
+// urls retrieves the requestConfig's endpoints, falling back to Twitch's production endpoints for any that are empty.
+// Authenticators that were not created via a constructor (ex. struct literals, or values decoded from JSON) have no
+// endpoints set at all.
+func (c requestConfig) urls() Endpoints {
+	return c.endpoints.withDefaults()
+}
+
+// apply sets the requestConfig's overrides on the supplied apiRequest.
+func (c requestConfig) apply(r apiRequest) apiRequest {
+	r.client = c.httpClient
+	r.logger = c.logger
+	return r
+}
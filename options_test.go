@@ -0,0 +1,69 @@
+﻿package go_twitchAuth
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticatorOptionsRejectInapplicable(t *testing.T) {
+	const clientId = "abcdefghijklmnopqrstuvwxyz0123"
+
+	build := map[string]func(opts ...AuthenticatorOption) error{
+		"AuthorizationCodeGrantAuthenticator": func(opts ...AuthenticatorOption) error {
+			_, err := NewAuthorizationCodeGrantAuthenticatorWithOptions(clientId, "client-secret", "http://localhost/callback", opts...)
+			return err
+		},
+		"ClientCredentialsGrantAuthenticator": func(opts ...AuthenticatorOption) error {
+			_, err := NewClientCredentialsGrantAuthenticatorWithOptions(clientId, "client-secret", opts...)
+			return err
+		},
+		"DeviceCodeGrantAuthenticator": func(opts ...AuthenticatorOption) error {
+			_, err := NewDeviceCodeGrantAuthenticatorWithOptions(clientId, opts...)
+			return err
+		},
+		"ImplicitGrantAuthenticator": func(opts ...AuthenticatorOption) error {
+			_, err := NewImplicitGrantAuthenticatorWithOptions(clientId, "http://localhost/callback", opts...)
+			return err
+		},
+	}
+
+	options := map[string]struct {
+		opt AuthenticatorOption
+		// supportedBy lists the authenticators that accept the option.
+		supportedBy []string
+	}{
+		"WithForceVerify":            {opt: WithForceVerify(true), supportedBy: []string{"AuthorizationCodeGrantAuthenticator", "ImplicitGrantAuthenticator"}},
+		"WithState":                  {opt: WithState("state"), supportedBy: []string{"AuthorizationCodeGrantAuthenticator", "ImplicitGrantAuthenticator"}},
+		"WithScopes":                 {opt: WithScopes(ScopeChatRead), supportedBy: []string{"AuthorizationCodeGrantAuthenticator", "DeviceCodeGrantAuthenticator", "ImplicitGrantAuthenticator"}},
+		"WithRegisteredRedirectUris": {opt: WithRegisteredRedirectUris("http://localhost/callback"), supportedBy: []string{"AuthorizationCodeGrantAuthenticator", "ImplicitGrantAuthenticator"}},
+		"WithAuthSessionStore":       {opt: WithAuthSessionStore(NewMemoryAuthSessionStore()), supportedBy: []string{"AuthorizationCodeGrantAuthenticator"}},
+		"WithAuthSessionTTL":         {opt: WithAuthSessionTTL(time.Minute), supportedBy: []string{"AuthorizationCodeGrantAuthenticator"}},
+	}
+
+	for authenticator, newAuthenticator := range build {
+		for option, tt := range options {
+			t.Run(authenticator+"/"+option, func(t *testing.T) {
+				err := newAuthenticator(tt.opt)
+				if slices.Contains(tt.supportedBy, authenticator) {
+					if err != nil {
+						t.Errorf("%s error = %v, want %s to be accepted", authenticator, err, option)
+					}
+					return
+				}
+
+				if err == nil || !strings.Contains(err.Error(), option+" is not supported by "+authenticator) {
+					t.Errorf("%s error = %v, want %s to be rejected", authenticator, err, option)
+				}
+			})
+		}
+
+		t.Run(authenticator+"/invalid value", func(t *testing.T) {
+			err := newAuthenticator(WithLogger(nil))
+			if err == nil || !strings.Contains(err.Error(), "WithLogger") {
+				t.Errorf("%s error = %v, want WithLogger(nil) to be rejected", authenticator, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateLimiter is the RateLimiter shared by every outgoing request. A nil value disables client-side rate limiting.
+var rateLimiter atomic.Pointer[RateLimiter]
+
+// SetRateLimiter installs the RateLimiter shared by ValidateToken, RevokeToken and every authenticator. Passing nil
+// disables client-side rate limiting.
+func SetRateLimiter(l *RateLimiter) {
+	rateLimiter.Store(l)
+}
+
+// RateLimit configures the refill rate and capacity of a single RateLimiter bucket.
+type RateLimit struct {
+	// Rate is the number of requests per second added back to the bucket. A Rate of 0 or less disables
+	// client-side limiting, leaving only the limits reported by Twitch in effect.
+	Rate float64
+
+	// Burst is the maximum number of requests that can be sent at once.
+	Burst int
+}
+
+/*
+RateLimiter is a token-bucket limiter for requests sent to the Twitch OAuth endpoints. Every EndpointType has its
+own bucket, which is refilled at the configured RateLimit and adjusted using the Ratelimit-Limit,
+Ratelimit-Remaining and Ratelimit-Reset headers returned by Twitch.
+
+Requests that have no tokens available block until one is available or their context is cancelled.
+
+New instances of RateLimiter should be created via NewRateLimiter and installed with SetRateLimiter.
+*/
+type RateLimiter struct {
+	mu      sync.Mutex
+	limit   RateLimit
+	limits  map[EndpointType]RateLimit
+	buckets map[EndpointType]*tokenBucket
+}
+
+// tokenBucket tracks the available requests for a single EndpointType.
+type tokenBucket struct {
+	limit   RateLimit
+	tokens  float64
+	last    time.Time
+	resetAt time.Time
+}
+
+// NewRateLimiter generates a new RateLimiter instance that applies limit to every endpoint that has not been
+// configured via SetEndpointLimit.
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit,
+		limits:  make(map[EndpointType]RateLimit),
+		buckets: make(map[EndpointType]*tokenBucket),
+	}
+}
+
+// SetEndpointLimit overrides the RateLimit used for requests sent to the supplied endpoint.
+func (l *RateLimiter) SetEndpointLimit(endpoint EndpointType, limit RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if limit.Rate > 0 && limit.Burst < 1 {
+		limit.Burst = 1
+	}
+
+	l.limits[endpoint] = limit
+	if b, ok := l.buckets[endpoint]; ok {
+		b.limit = limit
+		b.tokens = math.Min(b.tokens, float64(limit.Burst))
+	}
+}
+
+// Wait blocks until a request can be sent to the supplied endpoint, or until ctx is done.
+func (l *RateLimiter) Wait(ctx context.Context, endpoint EndpointType) error {
+	for {
+		delay := l.reserve(endpoint, time.Now())
+		if delay <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve takes a token from the endpoint's bucket. If no token is available, the time until one is expected to
+// become available is returned instead.
+func (l *RateLimiter) reserve(endpoint EndpointType, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(endpoint, now)
+
+	if now.Before(b.resetAt) {
+		if b.tokens < 1 {
+			return b.resetAt.Sub(now)
+		}
+	} else if !b.resetAt.IsZero() {
+		b.resetAt = time.Time{}
+		b.tokens = math.Max(b.tokens, float64(b.limit.Burst))
+	}
+
+	if b.limit.Rate <= 0 {
+		return 0
+	}
+
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// update adjusts the endpoint's bucket using the rate limit headers returned by Twitch.
+func (l *RateLimiter) update(endpoint EndpointType, header http.Header) {
+	limit, limitErr := strconv.Atoi(header.Get("Ratelimit-Limit"))
+	remaining, remainingErr := strconv.Atoi(header.Get("Ratelimit-Remaining"))
+	reset, resetErr := strconv.ParseInt(header.Get("Ratelimit-Reset"), 10, 64)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(endpoint, time.Now())
+
+	if limitErr == nil && limit > 0 && (b.limit.Burst <= 0 || limit < b.limit.Burst) {
+		b.limit.Burst = limit
+	}
+
+	if remainingErr == nil {
+		b.tokens = math.Min(b.tokens, float64(remaining))
+		if remaining <= 0 && resetErr == nil {
+			b.resetAt = time.Unix(reset, 0)
+		}
+	}
+}
+
+// bucket retrieves the endpoint's bucket, creating a full one if it does not exist yet. The caller must hold l.mu.
+func (l *RateLimiter) bucket(endpoint EndpointType, now time.Time) *tokenBucket {
+	b, ok := l.buckets[endpoint]
+	if !ok {
+		limit, ok := l.limits[endpoint]
+		if !ok {
+			limit = l.limit
+		}
+
+		if limit.Rate > 0 && limit.Burst < 1 {
+			limit.Burst = 1
+		}
+
+		b = &tokenBucket{
+			limit:  limit,
+			tokens: float64(limit.Burst),
+			last:   now,
+		}
+		l.buckets[endpoint] = b
+	}
+
+	return b
+}
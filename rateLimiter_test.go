@@ -0,0 +1,253 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useRateLimiter installs l as the shared RateLimiter and shortens the backoff applied to HTTP 429 responses.
+func useRateLimiter(t *testing.T, l *RateLimiter) {
+	t.Helper()
+
+	backoff := minRateLimitBackoff
+	minRateLimitBackoff = 5 * time.Millisecond
+	SetRateLimiter(l)
+	t.Cleanup(func() {
+		SetRateLimiter(nil)
+		minRateLimitBackoff = backoff
+	})
+}
+
+// rateLimitedServer starts a validation endpoint that responds with HTTP 429 and the supplied headers to the first
+// limited requests, counting every request it receives.
+func rateLimitedServer(t *testing.T, limited int, header http.Header) (requestConfig, *atomic.Int32) {
+	t.Helper()
+
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(hits.Add(1)) <= limited {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"status":429,"message":"Too Many Requests"}`))
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"client_id":"client-id","scopes":[],"expires_in":3600}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	return requestConfig{httpClient: srv.Client(), endpoints: Endpoints{ValidationUrl: srv.URL}}, &hits
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := NewRateLimiter(RateLimit{Rate: 10, Burst: 2})
+	l.SetEndpointLimit(EndpointRevocation, RateLimit{Rate: 1})
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if d := l.reserve(EndpointValidation, now); d != 0 {
+			t.Fatalf("reserve() %d within the burst = %s, want 0", i, d)
+		}
+	}
+
+	if d := l.reserve(EndpointValidation, now); d != 100*time.Millisecond {
+		t.Errorf("reserve() beyond the burst = %s, want %s", d, 100*time.Millisecond)
+	}
+
+	if d := l.reserve(EndpointValidation, now.Add(100*time.Millisecond)); d != 0 {
+		t.Errorf("reserve() once a token has been refilled = %s, want 0", d)
+	}
+
+	// Endpoints have their own buckets, and overridden limits default to a burst of 1.
+	if d := l.reserve(EndpointToken, now); d != 0 {
+		t.Errorf("reserve() on another endpoint = %s, want 0", d)
+	}
+
+	if d := l.reserve(EndpointRevocation, now); d != 0 {
+		t.Errorf("reserve() on an overridden endpoint = %s, want 0", d)
+	}
+
+	if d := l.reserve(EndpointRevocation, now); d != time.Second {
+		t.Errorf("reserve() beyond an overridden endpoint's burst = %s, want %s", d, time.Second)
+	}
+
+	disabled := NewRateLimiter(RateLimit{})
+	for i := 0; i < 100; i++ {
+		if d := disabled.reserve(EndpointValidation, now); d != 0 {
+			t.Fatalf("reserve() on a disabled limiter = %s, want 0", d)
+		}
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	l := NewRateLimiter(RateLimit{Rate: 20, Burst: 1})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		err := l.Wait(context.Background(), EndpointValidation)
+		if err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("three requests at 20/s with a burst of 1 took %s, want at least 100ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	l.SetEndpointLimit(EndpointValidation, RateLimit{Rate: 0.001, Burst: 1})
+	err := l.Wait(ctx, EndpointValidation)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() with a cancelled context error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestRateLimiterUpdate(t *testing.T) {
+	l := NewRateLimiter(RateLimit{Rate: 100, Burst: 800})
+	now := time.Now()
+	reset := now.Add(30 * time.Second).Truncate(time.Second)
+
+	l.update(EndpointToken, http.Header{
+		"Ratelimit-Limit":     {"5"},
+		"Ratelimit-Remaining": {"0"},
+		"Ratelimit-Reset":     {strconv.FormatInt(reset.Unix(), 10)},
+	})
+
+	b := l.buckets[EndpointToken]
+	if b.limit.Burst != 5 || b.tokens != 0 || !b.resetAt.Equal(reset) {
+		t.Fatalf("bucket = %+v, want a burst of 5, no tokens and a reset at %s", b, reset)
+	}
+
+	if d := l.reserve(EndpointToken, now); d != reset.Sub(now) {
+		t.Errorf("reserve() before the reset = %s, want %s", d, reset.Sub(now))
+	}
+
+	if d := l.reserve(EndpointToken, reset.Add(time.Millisecond)); d != 0 {
+		t.Errorf("reserve() after the reset = %s, want 0", d)
+	}
+
+	if b.tokens != 4 {
+		t.Errorf("tokens after the reset = %v, want the refilled burst of 5 less one", b.tokens)
+	}
+
+	// Headers reporting a higher limit than configured never raise it.
+	l.update(EndpointToken, http.Header{"Ratelimit-Limit": {"1000"}, "Ratelimit-Remaining": {"999"}})
+	if b.limit.Burst != 5 {
+		t.Errorf("burst after a higher reported limit = %d, want 5", b.limit.Burst)
+	}
+}
+
+func TestSendRequestRetriesRateLimitedRequests(t *testing.T) {
+	tests := map[string]struct {
+		limiter    *RateLimiter
+		limited    int
+		header     http.Header
+		wantHits   int32
+		wantStatus int
+		minElapsed time.Duration
+	}{
+		"no limiter": {
+			limited:    1,
+			wantHits:   1,
+			wantStatus: http.StatusTooManyRequests,
+		},
+		"retried until success": {
+			limiter:    NewRateLimiter(RateLimit{Rate: 1000, Burst: 10}),
+			limited:    2,
+			wantHits:   3,
+			wantStatus: http.StatusOK,
+			minElapsed: 15 * time.Millisecond,
+		},
+		"disabled limiter backs off": {
+			limiter:    NewRateLimiter(RateLimit{}),
+			limited:    10,
+			wantHits:   maxRateLimitRetries + 1,
+			wantStatus: http.StatusTooManyRequests,
+			minElapsed: 35 * time.Millisecond,
+		},
+		"reset in the past backs off": {
+			limiter:    NewRateLimiter(RateLimit{}),
+			limited:    10,
+			header:     http.Header{"Ratelimit-Remaining": {"0"}, "Ratelimit-Reset": {"1"}},
+			wantHits:   maxRateLimitRetries + 1,
+			wantStatus: http.StatusTooManyRequests,
+			minElapsed: 35 * time.Millisecond,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			useRateLimiter(t, tt.limiter)
+			c, hits := rateLimitedServer(t, tt.limited, tt.header)
+
+			start := time.Now()
+			res, err := validateToken(context.Background(), "token", c)
+			if err != nil {
+				t.Fatalf("validateToken() error = %v", err)
+			}
+			elapsed := time.Since(start)
+
+			if hits.Load() != tt.wantHits {
+				t.Errorf("server received %d requests, want %d", hits.Load(), tt.wantHits)
+			}
+
+			if res.Meta.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", res.Meta.StatusCode, tt.wantStatus)
+			}
+
+			if elapsed < tt.minElapsed {
+				t.Errorf("request took %s, want at least %s of backoff", elapsed, tt.minElapsed)
+			}
+		})
+	}
+}
+
+func TestSendRequestStopsBackingOffWhenContextIsDone(t *testing.T) {
+	useRateLimiter(t, NewRateLimiter(RateLimit{}))
+	minRateLimitBackoff = time.Hour
+	c, hits := rateLimitedServer(t, 10, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := validateToken(ctx, "token", c)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("validateToken() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if hits.Load() != 1 {
+		t.Errorf("server received %d requests, want 1", hits.Load())
+	}
+}
+
+func TestRateLimitBackoff(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	tests := map[string]struct {
+		header  http.Header
+		attempt int
+		want    time.Duration
+	}{
+		"no reset":      {attempt: 0, want: minRateLimitBackoff},
+		"doubles":       {attempt: 2, want: 4 * minRateLimitBackoff},
+		"future reset":  {header: http.Header{"Ratelimit-Reset": {"1030"}}, attempt: 2, want: 30 * time.Second},
+		"past reset":    {header: http.Header{"Ratelimit-Reset": {"999"}}, attempt: 1, want: 2 * minRateLimitBackoff},
+		"invalid reset": {header: http.Header{"Ratelimit-Reset": {"soon"}}, attempt: 0, want: minRateLimitBackoff},
+	}
+
+	for name, tt := range tests {
+		if got := rateLimitBackoff(tt.header, tt.attempt, now); got != tt.want {
+			t.Errorf("%s: rateLimitBackoff() = %s, want %s", name, got, tt.want)
+		}
+	}
+}
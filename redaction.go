@@ -0,0 +1,91 @@
+﻿package go_twitchAuth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/adamsurek/go-twitchAuth/internal/redact"
+)
+
+// captureRawBody controls whether ResponseMeta.RawBody is populated.
+var captureRawBody atomic.Bool
+
+// SetRawBodyCapture enables or disables capturing the redacted response body in ResponseMeta.RawBody.
+func SetRawBodyCapture(enabled bool) {
+	captureRawBody.Store(enabled)
+}
+
+// redactUrl returns the string version of u with the values of sensitive query parameters replaced.
+func redactUrl(u *url.URL) string {
+	c := *u
+	c.User = nil
+	c.RawQuery = redact.Values(u.Query()).Encode()
+	c.Fragment = ""
+	c.RawFragment = ""
+
+	// The fragment is set in its encoded form, so that String does not escape it a second time.
+	if f, err := url.ParseQuery(u.Fragment); err == nil && len(f) > 0 {
+		c.RawFragment = redact.Values(f).Encode()
+		c.Fragment, _ = url.PathUnescape(c.RawFragment)
+	}
+
+	return c.String()
+}
+
+// redactError returns the message of err with the URL of any url.Error redacted.
+func redactError(err error) string {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		if u, parseErr := url.Parse(ue.URL); parseErr == nil {
+			return fmt.Sprintf("%s %q: %s", ue.Op, redactUrl(u), ue.Err)
+		}
+	}
+
+	return err.Error()
+}
+
+// redactBody replaces the values of sensitive fields in a JSON or form-encoded body. Bodies in any other format are
+// replaced entirely, as there is no way of knowing whether they contain secrets.
+func redactBody(b []byte) []byte {
+	if len(b) == 0 {
+		return b
+	}
+
+	var m map[string]any
+	err := json.Unmarshal(b, &m)
+	if err != nil {
+		if json.Valid(b) || !isFormEncoded(string(b)) {
+			return []byte(redact.Value)
+		}
+
+		f, err := url.ParseQuery(string(b))
+		if err != nil {
+			return []byte(redact.Value)
+		}
+		return []byte(redact.Values(f).Encode())
+	}
+
+	redact.Decoded(m)
+
+	r, err := json.Marshal(m)
+	if err != nil {
+		return b
+	}
+
+	return r
+}
+
+// isFormEncoded reports whether every parameter in s is a key=value pair.
+func isFormEncoded(s string) bool {
+	for _, p := range strings.Split(s, "&") {
+		if !strings.Contains(p, "=") {
+			return false
+		}
+	}
+
+	return true
+}
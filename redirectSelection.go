@@ -0,0 +1,145 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// pendingRedirectTTL is how long the redirect URI chosen for a state is remembered.
+const pendingRedirectTTL = 10 * time.Minute
+
+// maxPendingRedirects is the number of states whose redirect URI is remembered. Once reached, the oldest is forgotten.
+const maxPendingRedirects = 10000
+
+// ErrUnknownState is returned when a code is exchanged for a state that no authorization URL was generated for, or
+// whose authorization URL was generated too long ago.
+var ErrUnknownState = errors.New("state does not match a pending authorization request")
+
+// pendingRedirects remembers the redirect URI used for each state until the code is exchanged.
+type pendingRedirects struct {
+	mu      sync.Mutex
+	entries map[string]pendingRedirect
+	// expiry orders the states by expiry, so that expired and oldest states are found without a scan.
+	expiry expiryQueue
+}
+
+// pendingRedirect is the redirect URI used for a single state.
+type pendingRedirect struct {
+	redirectUri string
+	expiresAt   time.Time
+}
+
+// add remembers the redirect URI used for state, discarding any entries that have expired and, if the limit is still
+// reached, the oldest.
+func (p *pendingRedirects) add(state string, redirectUri string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if p.entries == nil {
+		p.entries = map[string]pendingRedirect{}
+	}
+
+	for e, ok := p.expiry.popExpired(now); ok; e, ok = p.expiry.popExpired(now) {
+		if p.live(e) {
+			delete(p.entries, e.key)
+		}
+	}
+
+	if _, ok := p.entries[state]; !ok {
+		for len(p.entries) >= maxPendingRedirects {
+			e, ok := p.expiry.pop()
+			if !ok {
+				break
+			}
+
+			if p.live(e) {
+				delete(p.entries, e.key)
+			}
+		}
+	}
+
+	expiresAt := now.Add(pendingRedirectTTL)
+	p.entries[state] = pendingRedirect{redirectUri: redirectUri, expiresAt: expiresAt}
+	p.expiry.add(state, expiresAt)
+	p.expiry.compact(len(p.entries), p.live)
+}
+
+// take retrieves and forgets the redirect URI used for state.
+func (p *pendingRedirects) take(state string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.entries[state]
+	if !ok {
+		return "", false
+	}
+
+	delete(p.entries, state)
+	p.expiry.compact(len(p.entries), p.live)
+
+	if time.Now().After(e.expiresAt) {
+		return "", false
+	}
+
+	return e.redirectUri, true
+}
+
+// live reports whether a queued expiry still belongs to a remembered state. The caller must hold p.mu.
+func (p *pendingRedirects) live(e expiryEntry) bool {
+	r, ok := p.entries[e.key]
+	return ok && r.expiresAt.Equal(e.expiresAt)
+}
+
+/*
+GenerateAuthorizationUrlFor behaves like GenerateAuthorizationUrl, but uses the supplied redirect URI and state
+rather than those supplied during initialization. This allows a single authenticator to serve an app registered with
+several redirect URIs (ex. web, desktop loopback and staging).
+
+The redirect URI is remembered for the state, so GetTokenForState can send the matching redirect_uri when the code
+is exchanged. A non-empty state is required. If WithRegisteredRedirectUris was supplied, redirectUri must be one of
+the registered URIs.
+*/
+func (a *AuthorizationCodeGrantAuthenticator) GenerateAuthorizationUrlFor(redirectUri string, state string) (*url.URL, error) {
+	if state == "" {
+		return nil, errors.New("a state is required to remember the redirect URI")
+	}
+
+	u, err := a.buildAuthorizationUrl(redirectUri, state, a.requestedScopes.load(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	a.pendingRedirects.add(state, redirectUri)
+
+	return u, nil
+}
+
+// GetTokenForState behaves like GetToken, but sends the redirect URI that was used to generate the authorization URL
+// for the supplied state. See GetTokenForStateWithContext for details.
+func (a *AuthorizationCodeGrantAuthenticator) GetTokenForState(code string, state string) (*TokenResponse, error) {
+	return a.GetTokenForStateWithContext(context.Background(), code, state)
+}
+
+/*
+GetTokenForStateWithContext exchanges the auth code returned alongside state for a bearer token, sending the
+redirect URI that GenerateAuthorizationUrlFor used for that state. Each state can only be exchanged once.
+
+If the state is the one supplied during initialization, the redirect URI supplied during initialization is sent.
+Otherwise, ErrUnknownState is returned if no authorization URL was generated for the state within the last ten
+minutes.
+*/
+func (a *AuthorizationCodeGrantAuthenticator) GetTokenForStateWithContext(ctx context.Context, code string, state string) (*TokenResponse, error) {
+	redirectUri, ok := a.pendingRedirects.take(state)
+	if !ok {
+		if state == "" || state != a.state {
+			return nil, ErrUnknownState
+		}
+		redirectUri = a.redirectUri
+	}
+
+	return a.exchangeCode(ctx, code, redirectUri, nil)
+}
@@ -0,0 +1,177 @@
+﻿package go_twitchAuth
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testRedirectUris are the redirect URIs registered for the app in redirect selection tests.
+var testRedirectUris = []string{"https://example.com/callback", "http://localhost:3000/callback"}
+
+// newRedirectSelectionAuthenticator creates an authenticator registered with testRedirectUris, initialized with the
+// first of them.
+func newRedirectSelectionAuthenticator(t *testing.T) *AuthorizationCodeGrantAuthenticator {
+	t.Helper()
+
+	a, err := NewAuthorizationCodeGrantAuthenticatorWithOptions("client-id", "client-secret", testRedirectUris[0],
+		WithRegisteredRedirectUris(testRedirectUris...),
+		WithScopes(ScopeChatRead),
+		WithState("initial-state"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a
+}
+
+func TestGenerateAuthorizationUrlFor(t *testing.T) {
+	tests := map[string]struct {
+		redirectUri string
+		state       string
+		want        error
+		wantErr     bool
+	}{
+		"registered":     {redirectUri: testRedirectUris[1], state: "state"},
+		"initial":        {redirectUri: testRedirectUris[0], state: "state"},
+		"not registered": {redirectUri: "http://localhost:4000/callback", state: "state", want: ErrRedirectUriNotRegistered, wantErr: true},
+		"invalid":        {redirectUri: "http://example.com/callback", state: "state", want: ErrInvalidRedirectUri, wantErr: true},
+		"missing state":  {redirectUri: testRedirectUris[1], wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := newRedirectSelectionAuthenticator(t)
+
+			u, err := a.GenerateAuthorizationUrlFor(tt.redirectUri, tt.state)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GenerateAuthorizationUrlFor() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("GenerateAuthorizationUrlFor() error = %v, want %v", err, tt.want)
+			}
+
+			if err != nil {
+				if _, ok := a.pendingRedirects.take(tt.state); ok {
+					t.Error("a rejected redirect URI was remembered for the state")
+				}
+				return
+			}
+
+			if got := u.Query().Get("redirect_uri"); got != tt.redirectUri {
+				t.Errorf("redirect_uri = %q, want %q", got, tt.redirectUri)
+			}
+
+			if got := u.Query().Get("state"); got != tt.state {
+				t.Errorf("state = %q, want %q", got, tt.state)
+			}
+		})
+	}
+}
+
+func TestGetTokenForState(t *testing.T) {
+	s := useFakeServer(t)
+	s.RegisterApp("client-id", "client-secret", testRedirectUris...)
+
+	a := newRedirectSelectionAuthenticator(t)
+
+	// code authorizes a request generated for the supplied redirect URI and state.
+	code := func(redirectUri string, state string) string {
+		u, err := a.GenerateAuthorizationUrlFor(redirectUri, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return authorize(t, s, u).Query().Get("code")
+	}
+
+	desktop := code(testRedirectUris[1], "desktop-state")
+	web := code(testRedirectUris[0], "web-state")
+
+	// Each code is exchanged with the redirect URI bound to its state, regardless of the order of the exchanges.
+	for state, c := range map[string]string{"desktop-state": desktop, "web-state": web} {
+		res, err := a.GetTokenForState(c, state)
+		if err != nil {
+			t.Fatalf("GetTokenForState(%s) error = %v", state, err)
+		}
+
+		checkTokenResponse(t, res, http.StatusOK)
+	}
+
+	// A state can only be exchanged once.
+	_, err := a.GetTokenForState(code(testRedirectUris[1], "reused-state"), "reused-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = a.GetTokenForState("another-code", "reused-state")
+	if !errors.Is(err, ErrUnknownState) {
+		t.Errorf("GetTokenForState() of a used state error = %v, want %v", err, ErrUnknownState)
+	}
+
+	_, err = a.GetTokenForState("another-code", "unknown-state")
+	if !errors.Is(err, ErrUnknownState) {
+		t.Errorf("GetTokenForState() of an unknown state error = %v, want %v", err, ErrUnknownState)
+	}
+
+	// The state supplied during initialization is exchanged with the initial redirect URI.
+	u, err := a.GenerateAuthorizationUrl()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := a.GetTokenForState(authorize(t, s, u).Query().Get("code"), "initial-state")
+	if err != nil {
+		t.Fatalf("GetTokenForState() of the initial state error = %v", err)
+	}
+	checkTokenResponse(t, res, http.StatusOK)
+}
+
+func TestPendingRedirectsExpiry(t *testing.T) {
+	var p pendingRedirects
+
+	// Expired states are discarded by the next add.
+	past := time.Now().Add(-time.Second)
+	p.entries = map[string]pendingRedirect{"expired": {redirectUri: testRedirectUris[0], expiresAt: past}}
+	p.expiry.add("expired", past)
+
+	p.add("current", testRedirectUris[1])
+	if _, ok := p.entries["expired"]; ok || len(p.entries) != 1 || p.expiry.Len() != 1 {
+		t.Fatalf("pendingRedirects holds %d states and %d expiries after expiry, want 1 and 1", len(p.entries), p.expiry.Len())
+	}
+
+	// Once full, the oldest state is forgotten.
+	for i := 1; i < maxPendingRedirects; i++ {
+		p.add("state-"+strconv.Itoa(i), testRedirectUris[0])
+	}
+
+	p.add("newest", testRedirectUris[0])
+	if len(p.entries) != maxPendingRedirects {
+		t.Fatalf("pendingRedirects holds %d states, want %d", len(p.entries), maxPendingRedirects)
+	}
+
+	if _, ok := p.take("current"); ok {
+		t.Error("the oldest state was remembered beyond the limit")
+	}
+
+	if uri, ok := p.take("newest"); !ok || uri != testRedirectUris[0] {
+		t.Errorf("take() of the newest state = %q, %t; want %q, true", uri, ok, testRedirectUris[0])
+	}
+
+	// Replacing and taking states leaves stale expiries behind, which are compacted away.
+	for i := 0; i < 1000; i++ {
+		p.add("state-1", testRedirectUris[1])
+	}
+
+	if p.expiry.Len() > 2*len(p.entries)+minExpiryQueueCompaction+1 {
+		t.Errorf("pendingRedirects queues %d expiries for %d states", p.expiry.Len(), len(p.entries))
+	}
+
+	if uri, ok := p.take("state-1"); !ok || uri != testRedirectUris[1] {
+		t.Errorf("take() of a replaced state = %q, %t; want %q, true", uri, ok, testRedirectUris[1])
+	}
+}
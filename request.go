@@ -0,0 +1,293 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/adamsurek/go-twitchAuth/internal/redact"
+)
+
+// maxRateLimitRetries is the number of times a request rejected with HTTP 429 is retried once the RateLimiter
+// allows it.
+const maxRateLimitRetries = 3
+
+// minRateLimitBackoff is how long a request rejected with HTTP 429 waits before its first retry when Twitch does not
+// report when its rate limit resets. The wait doubles with every retry.
+var minRateLimitBackoff = time.Second
+
+// defaultHttpClient is used to send requests until SetHTTPClient is called.
+var defaultHttpClient = &http.Client{Timeout: 60 * time.Second}
+
+// httpClient is the http.Client used to send every outgoing request.
+var httpClient atomic.Pointer[http.Client]
+
+// SetHTTPClient replaces the http.Client used by ValidateToken, RevokeToken and every authenticator. Passing nil
+// restores the default client, which times out after 60 seconds.
+func SetHTTPClient(c *http.Client) {
+	httpClient.Store(c)
+}
+
+// getHttpClient retrieves the http.Client used to send outgoing requests.
+func getHttpClient() *http.Client {
+	if c := httpClient.Load(); c != nil {
+		return c
+	}
+
+	return defaultHttpClient
+}
+
+// apiRequest describes a single request sent to one of the Twitch OAuth endpoints.
+type apiRequest struct {
+	endpoint EndpointType
+	method   string
+	url      string
+	header   http.Header
+	// form is sent as a form-encoded request body.
+	form url.Values
+	// clientId and clientSecret authenticate the app using the installed ClientAuthMethod. They are only set for
+	// requests that require the client secret.
+	clientId     string
+	clientSecret string
+	// grantType is the OAuth grant type of a token request, reported to any installed RequestObserver.
+	grantType string
+	// client and logger override the package-level http.Client and slog.Logger when set. See requestConfig.
+	client *http.Client
+	logger *slog.Logger
+}
+
+// apiResponse stores the raw result of an apiRequest.
+type apiResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+	latency    time.Duration
+	receivedAt time.Time
+}
+
+// doRequest sends the supplied apiRequest, notifying every installed RequestObserver.
+func doRequest(ctx context.Context, r apiRequest) (*apiResponse, error) {
+	ctx, finish := observeRequest(ctx, RequestInfo{Endpoint: r.endpoint, GrantType: r.grantType})
+
+	start := time.Now()
+	res, err := sendRequest(ctx, r)
+	if err != nil {
+		finish(0, time.Since(start), err)
+		return nil, err
+	}
+
+	finish(res.statusCode, time.Since(start), nil)
+	return res, nil
+}
+
+// sendRequest sends the supplied apiRequest, waiting on the shared RateLimiter before each attempt.
+func sendRequest(ctx context.Context, r apiRequest) (*apiResponse, error) {
+	limiter := rateLimiter.Load()
+
+	for attempt := 0; ; attempt++ {
+		if limiter != nil {
+			err := limiter.Wait(ctx, r.endpoint)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		req, form, err := r.build(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		logTo(ctx, r.logger, slog.LevelDebug, "sending twitch oauth request",
+			slog.String("endpoint", r.endpoint.String()),
+			slog.String("method", req.Method),
+			slog.String("url", redactUrl(req.URL)),
+			slog.String("body", redact.Values(form).Encode()),
+			slog.Int("attempt", attempt+1),
+		)
+
+		start := time.Now()
+		client := r.client
+		if client == nil {
+			client = getHttpClient()
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			logTo(ctx, r.logger, slog.LevelDebug, "twitch oauth request failed",
+				slog.String("endpoint", r.endpoint.String()),
+				slog.String("error", redactError(err)),
+			)
+			return nil, err
+		}
+
+		b, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		latency := time.Since(start)
+
+		logTo(ctx, r.logger, slog.LevelDebug, "received twitch oauth response",
+			slog.String("endpoint", r.endpoint.String()),
+			slog.Int("status", res.StatusCode),
+			slog.Duration("latency", latency),
+			slog.String("ratelimit_remaining", res.Header.Get("Ratelimit-Remaining")),
+			slog.String("body", string(redactBody(b))),
+		)
+
+		if limiter != nil {
+			limiter.update(r.endpoint, res.Header)
+			if res.StatusCode == http.StatusTooManyRequests && attempt < maxRateLimitRetries {
+				err = sleepContext(ctx, rateLimitBackoff(res.Header, attempt, time.Now()))
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		return &apiResponse{
+			statusCode: res.StatusCode,
+			header:     res.Header,
+			body:       b,
+			latency:    latency,
+			receivedAt: start.Add(latency),
+		}, nil
+	}
+}
+
+// rateLimitBackoff computes how long to wait before retrying a request rejected with HTTP 429. Twitch's
+// Ratelimit-Reset header is honoured when it lies in the future; otherwise, the wait starts at minRateLimitBackoff and
+// doubles with every attempt, so that a limiter that is disabled or unaware of the limit does not retry immediately.
+func rateLimitBackoff(header http.Header, attempt int, now time.Time) time.Duration {
+	if reset, err := strconv.ParseInt(header.Get("Ratelimit-Reset"), 10, 64); err == nil && now.Before(time.Unix(reset, 0)) {
+		return time.Unix(reset, 0).Sub(now)
+	}
+
+	return minRateLimitBackoff << attempt
+}
+
+// sleepContext waits for the supplied duration, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// build creates the http.Request for the apiRequest, returning it alongside the form sent as its body.
+func (r apiRequest) build(ctx context.Context) (*http.Request, url.Values, error) {
+	form := url.Values{}
+	for k, v := range r.form {
+		form[k] = v
+	}
+
+	useBasic := r.clientSecret != "" && clientAuthMethod.Load() == int32(ClientAuthBasic)
+	if r.clientSecret != "" && !useBasic {
+		form.Set("client_id", r.clientId)
+		form.Set("client_secret", r.clientSecret)
+	}
+
+	var body io.Reader
+	if len(form) > 0 {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.method, r.url, body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	if useBasic {
+		req.SetBasicAuth(url.QueryEscape(r.clientId), url.QueryEscape(r.clientSecret))
+	}
+
+	return req, form, nil
+}
+
+// meta builds the ResponseMeta describing the apiResponse.
+func (r *apiResponse) meta() *ResponseMeta {
+	m := ResponseMeta{
+		StatusCode: r.statusCode,
+		Header:     r.header,
+		RateLimit:  parseRateLimitStatus(r.header),
+		Latency:    r.latency,
+	}
+
+	if captureRawBody.Load() {
+		m.RawBody = redactBody(r.body)
+	}
+
+	return &m
+}
+
+// parseRateLimitStatus reads the rate limit headers returned by Twitch.
+func parseRateLimitStatus(header http.Header) RateLimitStatus {
+	var s RateLimitStatus
+
+	if limit, err := strconv.Atoi(header.Get("Ratelimit-Limit")); err == nil {
+		s.Limit = limit
+	}
+
+	if remaining, err := strconv.Atoi(header.Get("Ratelimit-Remaining")); err == nil {
+		s.Remaining = remaining
+	}
+
+	if reset, err := strconv.ParseInt(header.Get("Ratelimit-Reset"), 10, 64); err == nil {
+		s.Reset = time.Unix(reset, 0)
+	}
+
+	return s
+}
+
+// parseTokenResponse converts the result of a token request into a TokenResponse.
+func parseTokenResponse(res *apiResponse, kind TokenKind, clientId string) (*TokenResponse, error) {
+	t := TokenResponse{Meta: res.meta()}
+
+	if res.statusCode != 200 {
+		t.TokenRequestStatus = StatusFailure
+		err := json.Unmarshal(res.body, &t.FailureData)
+		if err != nil {
+			e := fmt.Sprintf("error while parsing failed request response: %s", err)
+			return nil, errors.New(e)
+		}
+		return &t, nil
+	}
+
+	t.TokenRequestStatus = StatusSuccess
+	err := json.Unmarshal(res.body, &t.TokenData)
+	if err != nil {
+		e := fmt.Sprintf("error while parsing token response: %s", err)
+		return nil, errors.New(e)
+	}
+
+	t.Token = newToken(t.TokenData, kind, clientId, res.receivedAt)
+
+	return &t, nil
+}
@@ -1,5 +1,10 @@
 ﻿package go_twitchAuth
 
+import (
+	"net/http"
+	"time"
+)
+
 type responseStatus int
 
 const (
@@ -23,6 +28,9 @@ type TokenResponse struct {
 	TokenRequestStatus responseStatus
 	TokenData          *AccessTokenRequestResponse
 	FailureData        *FailedRequestResponse
+	Meta               *ResponseMeta
+	// Token is populated when TokenRequestStatus is StatusSuccess.
+	Token *Token
 }
 
 // TokenValidationResponse stores the results of a token validation request.
@@ -30,6 +38,9 @@ type TokenValidationResponse struct {
 	ValidationStatus responseStatus
 	ValidationData   *ValidTokenResponse
 	FailureData      *FailedRequestResponse
+	Meta             *ResponseMeta
+	// Token is populated when ValidationStatus is StatusSuccess.
+	Token *Token
 }
 
 // TokenRevocationResponse stores the results of a token revocation request. A successful revocation request returns
@@ -37,15 +48,43 @@ type TokenValidationResponse struct {
 type TokenRevocationResponse struct {
 	RevocationStatus responseStatus
 	FailureData      *FailedRequestResponse
+	Meta             *ResponseMeta
+}
+
+/*
+ResponseMeta stores details of the HTTP exchange behind a TokenResponse, TokenValidationResponse or
+TokenRevocationResponse.
+
+RawBody is only populated once raw body capture has been enabled via SetRawBodyCapture. Any access or refresh
+tokens contained in the captured body are redacted.
+*/
+type ResponseMeta struct {
+	StatusCode int
+	Header     http.Header
+	RateLimit  RateLimitStatus
+	Latency    time.Duration
+	RawBody    []byte
+}
+
+// RateLimitStatus stores the parsed rate limit headers returned by Twitch. Fields are left empty when the matching
+// header is missing from the response.
+type RateLimitStatus struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
 }
 
 // AccessTokenRequestResponse stores the parsed JSON response of an access token request.
 type AccessTokenRequestResponse struct {
-	AccessToken  string      `json:"access_token"`
-	RefreshToken string      `json:"refresh_token"`
-	ExpiresIn    int         `json:"expires_in"`
-	TokenType    string      `json:"token_type"`
-	Scopes       []ScopeType `json:"scopes"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	TokenType    string `json:"token_type"`
+	// Scopes is read from the "scope" field, which is what Twitch's token endpoint returns. Earlier versions read a
+	// "scopes" field that Twitch never sends (only the validation endpoint uses that name), leaving Scopes empty.
+	Scopes []ScopeType `json:"scope"`
+	// IdToken is only returned when the openid scope is requested.
+	IdToken string `json:"id_token,omitempty"`
 }
 
 // ValidTokenResponse stores the parsed JSON response of a token validation request on a valid token.
@@ -57,6 +96,11 @@ type ValidTokenResponse struct {
 	ExpiresIn int         `json:"expires_in"`
 }
 
+// IsNonExpiring reports whether the validated token was reported without an expiry. See Token.NonExpiring.
+func (v *ValidTokenResponse) IsNonExpiring() bool {
+	return v.ExpiresIn == 0
+}
+
 // FailedRequestResponse stores the parsed JSON response of a failed Helix API response.
 type FailedRequestResponse struct {
 	Status  int    `json:"status"`
@@ -0,0 +1,49 @@
+﻿package go_twitchAuth
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestAccessTokenRequestResponseScopes pins the JSON name of AccessTokenRequestResponse.Scopes, which changed from
+// "scopes" to "scope" to match Twitch's token endpoint. See the README's note on the change.
+func TestAccessTokenRequestResponseScopes(t *testing.T) {
+	tests := map[string]struct {
+		body string
+		want []ScopeType
+	}{
+		// The token endpoint returns granted scopes in "scope", unlike the validation endpoint's "scopes".
+		"scope": {
+			body: `{"access_token":"a","refresh_token":"r","expires_in":14400,"scope":["chat:read","chat:edit"],"token_type":"bearer"}`,
+			want: []ScopeType{ScopeChatRead, ScopeChatEdit},
+		},
+		"scopes is no longer read": {
+			body: `{"access_token":"a","refresh_token":"r","expires_in":14400,"scopes":["chat:read"],"token_type":"bearer"}`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var res AccessTokenRequestResponse
+			err := json.Unmarshal([]byte(tt.body), &res)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tok := Token{Scopes: res.Scopes}
+			if len(res.Scopes) != len(tt.want) || !tok.HasScopes(tt.want...) {
+				t.Errorf("Scopes = %v, want %v", res.Scopes, tt.want)
+			}
+		})
+	}
+
+	b, err := json.Marshal(AccessTokenRequestResponse{Scopes: []ScopeType{ScopeChatRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(b), `"scope":["chat:read"]`) || strings.Contains(string(b), `"scopes"`) {
+		t.Errorf("json.Marshal() = %s, want scopes encoded as \"scope\"", b)
+	}
+}
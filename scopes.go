@@ -3,6 +3,11 @@
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync/atomic"
 )
 
 /*
@@ -259,6 +264,12 @@ const (
 
 	// ScopeUserWriteChat allows app to send chat messages as the authenticated user.
 	ScopeUserWriteChat
+
+	// ScopeChatRead allows app to view live chat and room messages via Twitch IRC.
+	ScopeChatRead
+
+	// ScopeChatEdit allows app to send live chat messages via Twitch IRC.
+	ScopeChatEdit
 )
 
 // scopeTypeId translates the string version of an access scope to its enum value.
@@ -338,6 +349,8 @@ var scopeTypeId = map[string]ScopeType{
 	"user:read:whispers":                ScopeUserReadWhispers,
 	"user:manage:whispers":              ScopeUserManageWhispers,
 	"user:write:chat":                   ScopeUserWriteChat,
+	"chat:read":                         ScopeChatRead,
+	"chat:edit":                         ScopeChatEdit,
 }
 
 // scopeTypeName translates the enum value version of an access scope to its string value.
@@ -417,6 +430,37 @@ var scopeTypeName = map[ScopeType]string{
 	ScopeUserReadWhispers:               "user:read:whispers",
 	ScopeUserManageWhispers:             "user:manage:whispers",
 	ScopeUserWriteChat:                  "user:write:chat",
+	ScopeChatRead:                       "chat:read",
+	ScopeChatEdit:                       "chat:edit",
+}
+
+func (t ScopeType) String() string {
+	return scopeTypeName[t]
+}
+
+// ParseScopeType translates the string version of an access scope (ex. "user:read:chat") to its ScopeType.
+func ParseScopeType(name string) (ScopeType, error) {
+	t, ok := scopeTypeId[name]
+	if !ok {
+		e := fmt.Sprintf("unknown scope: %q", name)
+		return 0, errors.New(e)
+	}
+
+	return t, nil
+}
+
+// ScopeTypes retrieves every ScopeType known to this package, in the order they are declared.
+func ScopeTypes() []ScopeType {
+	scopes := make([]ScopeType, 0, len(scopeTypeName))
+	for t := range scopeTypeName {
+		scopes = append(scopes, t)
+	}
+
+	sort.Slice(scopes, func(i, j int) bool {
+		return scopes[i] < scopes[j]
+	})
+
+	return scopes
 }
 
 func (t *ScopeType) MarshalJSON() ([]byte, error) {
@@ -436,3 +480,34 @@ func (t *ScopeType) UnmarshalJSON(b []byte) error {
 	*t = scopeTypeId[s]
 	return nil
 }
+
+// scopeNames retrieves the string version of the supplied ScopeType(s).
+func scopeNames(scopes []ScopeType) []string {
+	var names []string
+	for _, s := range scopes {
+		names = append(names, scopeTypeName[s])
+	}
+
+	return names
+}
+
+// scopeSnapshot stores a list of ScopeType that can be replaced while other goroutines read it. Every stored list is
+// a private copy that is never modified, so a loaded list remains consistent even if it's replaced mid-request.
+type scopeSnapshot struct {
+	scopes atomic.Pointer[[]ScopeType]
+}
+
+// load retrieves the current list. It must not be modified.
+func (s *scopeSnapshot) load() []ScopeType {
+	if p := s.scopes.Load(); p != nil {
+		return *p
+	}
+
+	return nil
+}
+
+// store replaces the current list with a copy of scopes.
+func (s *scopeSnapshot) store(scopes []ScopeType) {
+	c := slices.Clone(scopes)
+	s.scopes.Store(&c)
+}
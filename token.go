@@ -0,0 +1,179 @@
+﻿package go_twitchAuth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+/*
+TokenKind distinguishes app access tokens, issued via the client credentials grant flow, from user access tokens,
+issued via the authorization code and implicit grant flows.
+
+Twitch docs: https://dev.twitch.tv/docs/authentication/#access-tokens
+*/
+type TokenKind int
+
+const (
+	// TokenKindUser represents a user access token.
+	TokenKindUser TokenKind = iota + 1
+
+	// TokenKindApp represents an app access token.
+	TokenKindApp
+)
+
+var tokenKindId = map[string]TokenKind{
+	"user": TokenKindUser,
+	"app":  TokenKindApp,
+}
+
+var tokenKindName = map[TokenKind]string{
+	TokenKindUser: "user",
+	TokenKindApp:  "app",
+}
+
+func (k TokenKind) String() string {
+	return tokenKindName[k]
+}
+
+func (k TokenKind) MarshalJSON() ([]byte, error) {
+	buffer := bytes.NewBufferString(`"`)
+	buffer.WriteString(tokenKindName[k])
+	buffer.WriteString(`"`)
+	return buffer.Bytes(), nil
+}
+
+func (k *TokenKind) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+
+	*k = tokenKindId[s]
+	return nil
+}
+
+/*
+Token is an access token along with the details needed to use, refresh and store it. Unlike the expires_in value
+returned by Twitch, a Token records when it was received (IssuedAt) and when it expires (ExpiresAt), so it remains
+accurate after being stored.
+
+Tokens are returned by every flow: TokenResponse.Token, TokenValidationResponse.Token and
+ImplicitGrantAuthenticator.ParseRedirect. Token can be marshalled to and from JSON for storage.
+
+Some older tokens, and certain app access tokens, are reported by Twitch with an expires_in of 0. These are marked
+as NonExpiring, have a zero ExpiresAt, and are never considered expired by Valid, ExpiresWithin or any TokenSource.
+Twitch may still invalidate them at any time - see MigrateNonExpiringToken.
+*/
+type Token struct {
+	AccessToken  string      `json:"access_token"`
+	RefreshToken string      `json:"refresh_token,omitempty"`
+	TokenType    string      `json:"token_type,omitempty"`
+	Kind         TokenKind   `json:"kind"`
+	Scopes       []ScopeType `json:"scopes"`
+	ClientId     string      `json:"client_id,omitempty"`
+	// UserId and Login are only known once a user access token has been validated.
+	UserId    string    `json:"user_id,omitempty"`
+	Login     string    `json:"login,omitempty"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// NonExpiring is true if Twitch reported the token with an expires_in of 0.
+	NonExpiring bool `json:"non_expiring,omitempty"`
+}
+
+// Valid reports whether the Token has an access token that has not expired.
+func (t *Token) Valid() bool {
+	return t != nil && t.AccessToken != "" && (t.NonExpiring || time.Now().Before(t.ExpiresAt))
+}
+
+// ExpiresWithin reports whether the Token expires within the supplied duration. It always returns false for
+// NonExpiring tokens, and true for a nil Token, as there is no token to use.
+func (t *Token) ExpiresWithin(d time.Duration) bool {
+	return t == nil || !t.NonExpiring && !time.Now().Add(d).Before(t.ExpiresAt)
+}
+
+// HasScopes reports whether the Token has been granted every supplied scope.
+func (t *Token) HasScopes(scopes ...ScopeType) bool {
+	for _, s := range scopes {
+		found := false
+		for _, g := range t.Scopes {
+			if g == s {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsApp reports whether the Token is an app access token.
+func (t *Token) IsApp() bool {
+	return t.Kind == TokenKindApp
+}
+
+// IsUser reports whether the Token is a user access token.
+func (t *Token) IsUser() bool {
+	return t.Kind == TokenKindUser
+}
+
+// SetAuthHeader sets the Authorization header of the supplied request to the Token's bearer token.
+func (t *Token) SetAuthHeader(r *http.Request) {
+	r.Header.Set("Authorization", "Bearer "+t.AccessToken)
+}
+
+// newToken builds a Token from a successful token request response received at issuedAt.
+func newToken(d *AccessTokenRequestResponse, kind TokenKind, clientId string, issuedAt time.Time) *Token {
+	t := Token{
+		AccessToken:  d.AccessToken,
+		RefreshToken: d.RefreshToken,
+		TokenType:    d.TokenType,
+		Kind:         kind,
+		Scopes:       d.Scopes,
+		ClientId:     clientId,
+		IssuedAt:     issuedAt,
+	}
+	t.setExpiry(d.ExpiresIn)
+
+	return &t
+}
+
+// newValidatedToken builds a Token from a successful validation response received at validatedAt.
+func newValidatedToken(accessToken string, d *ValidTokenResponse, validatedAt time.Time) *Token {
+	kind := TokenKindUser
+	if d.UserId == "" {
+		kind = TokenKindApp
+	}
+
+	t := Token{
+		AccessToken: accessToken,
+		TokenType:   "bearer",
+		Kind:        kind,
+		Scopes:      d.Scopes,
+		ClientId:    d.ClientId,
+		UserId:      d.UserId,
+		Login:       d.Login,
+		IssuedAt:    validatedAt,
+	}
+	t.setExpiry(d.ExpiresIn)
+
+	return &t
+}
+
+// setExpiry sets ExpiresAt using the expires_in value returned by Twitch, marking the Token as NonExpiring if it is 0.
+func (t *Token) setExpiry(expiresIn int) {
+	if expiresIn == 0 {
+		t.NonExpiring = true
+		t.ExpiresAt = time.Time{}
+		return
+	}
+
+	t.NonExpiring = false
+	t.ExpiresAt = t.IssuedAt.Add(time.Duration(expiresIn) * time.Second)
+}
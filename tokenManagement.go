@@ -1,39 +1,42 @@
 ﻿package go_twitchAuth
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"time"
+	"net/url"
 )
 
 // ValidateToken confirms, using the Twitch Helix API, whether the supplied bearer token is valid.
 func ValidateToken(token string) (*TokenValidationResponse, error) {
-	var t TokenValidationResponse
-
-	req, err := http.NewRequest("GET", validationUrl, nil)
-	if err != nil {
-		return nil, err
-	}
+	return ValidateTokenWithContext(context.Background(), token)
+}
 
-	req.Header.Add("Authorization", "Bearer "+token)
-	client := http.Client{Timeout: 60 * time.Second}
+// ValidateTokenWithContext behaves like ValidateToken, but stops waiting on the RateLimiter and cancels the request
+// once ctx is done.
+func ValidateTokenWithContext(ctx context.Context, token string) (*TokenValidationResponse, error) {
+	return validateToken(ctx, token, requestConfig{endpoints: DefaultEndpoints()})
+}
 
-	res, err := client.Do(req)
+// validateToken validates the supplied token using the supplied requestConfig.
+func validateToken(ctx context.Context, token string, c requestConfig) (*TokenValidationResponse, error) {
+	res, err := doRequest(ctx, c.apply(apiRequest{
+		endpoint: EndpointValidation,
+		method:   "GET",
+		url:      c.urls().ValidationUrl,
+		header:   http.Header{"Authorization": {"Bearer " + token}},
+	}))
 	if err != nil {
 		return nil, err
 	}
 
-	b, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
+	t := TokenValidationResponse{Meta: res.meta()}
 
-	if res.StatusCode != 200 {
+	if res.statusCode != 200 {
 		t.ValidationStatus = StatusFailure
-		err = json.Unmarshal(b, &t.FailureData)
+		err = json.Unmarshal(res.body, &t.FailureData)
 		if err != nil {
 			e := fmt.Sprintf("error while parsing failed request response: %s", err)
 			return nil, errors.New(e)
@@ -43,45 +46,52 @@ func ValidateToken(token string) (*TokenValidationResponse, error) {
 	}
 
 	t.ValidationStatus = StatusSuccess
-	err = json.Unmarshal(b, &t.ValidationData)
+	err = json.Unmarshal(res.body, &t.ValidationData)
 	if err != nil {
 		e := fmt.Sprintf("error while parsing valid token response: %s", err)
 		return nil, errors.New(e)
 	}
 
+	t.Token = newValidatedToken(token, t.ValidationData, res.receivedAt)
+
 	return &t, nil
 }
 
 // RevokeToken revokes the supplied active bearer token.
 func RevokeToken(clientId string, token string) (*TokenRevocationResponse, error) {
-	var t TokenRevocationResponse
-
-	req, err := http.NewRequest("POST", revocationUrl, nil)
-	if err != nil {
-		return nil, err
-	}
+	return RevokeTokenWithContext(context.Background(), clientId, token)
+}
 
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+// RevokeTokenWithContext behaves like RevokeToken, but stops waiting on the RateLimiter and cancels the request
+// once ctx is done.
+func RevokeTokenWithContext(ctx context.Context, clientId string, token string) (*TokenRevocationResponse, error) {
+	return revokeToken(ctx, clientId, token, requestConfig{endpoints: DefaultEndpoints()})
+}
 
-	q := req.URL.Query()
+// revokeToken revokes the supplied token using the supplied requestConfig.
+func revokeToken(ctx context.Context, clientId string, token string, c requestConfig) (*TokenRevocationResponse, error) {
+	q := url.Values{}
 	q.Add("token", token)
 	q.Add("client_id", clientId)
-	req.URL.RawQuery = q.Encode()
 
-	client := http.Client{Timeout: 60 * time.Second}
-	res, err := client.Do(req)
+	res, err := doRequest(ctx, c.apply(apiRequest{
+		endpoint: EndpointRevocation,
+		method:   "POST",
+		url:      c.urls().RevocationUrl,
+		form:     q,
+	}))
 	if err != nil {
 		return nil, err
 	}
 
-	b, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
+	// Whether or not Twitch accepted the revocation, the token should no longer be trusted.
+	evictFromValidationCaches(token)
+
+	t := TokenRevocationResponse{Meta: res.meta()}
 
-	if res.StatusCode != 200 {
+	if res.statusCode != 200 {
 		t.RevocationStatus = StatusFailure
-		err = json.Unmarshal(b, &t.FailureData)
+		err = json.Unmarshal(res.body, &t.FailureData)
 		if err != nil {
 			e := fmt.Sprintf("error while parsing failed request response: %s", err)
 			return nil, errors.New(e)
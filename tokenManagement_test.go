@@ -0,0 +1,107 @@
+﻿package go_twitchAuth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/adamsurek/go-twitchAuth/twitchauthtest"
+)
+
+func TestValidateToken(t *testing.T) {
+	tests := map[string]struct {
+		prepare    func(s *twitchauthtest.Server, accessToken string) error
+		failure    *twitchauthtest.Failure
+		wantStatus int
+	}{
+		"valid":        {wantStatus: http.StatusOK},
+		"expired":      {prepare: (*twitchauthtest.Server).Expire, wantStatus: http.StatusUnauthorized},
+		"revoked":      {prepare: (*twitchauthtest.Server).Revoke, wantStatus: http.StatusUnauthorized},
+		"server error": {failure: &twitchauthtest.Failure{Status: http.StatusInternalServerError, Message: "internal error"}, wantStatus: http.StatusInternalServerError},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := useFakeServer(t)
+			issued := s.IssueToken(twitchauthtest.TokenOptions{
+				ClientId: "client-id",
+				User:     &twitchauthtest.User{Id: "42", Login: "viewer"},
+				Scopes:   []string{"chat:read"},
+			})
+
+			if tt.prepare != nil {
+				err := tt.prepare(s, issued.AccessToken)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.failure != nil {
+				s.InjectFailure("/oauth2/validate", *tt.failure)
+			}
+
+			res, err := ValidateToken(issued.AccessToken)
+			if err != nil {
+				t.Fatalf("ValidateToken() error = %v", err)
+			}
+
+			if res.Meta.StatusCode != tt.wantStatus {
+				t.Fatalf("ValidateToken() status code = %d, want %d", res.Meta.StatusCode, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				if res.ValidationStatus != StatusFailure || res.FailureData == nil || res.FailureData.Status != tt.wantStatus {
+					t.Fatalf("ValidateToken() = %+v, want a failed validation", res)
+				}
+				return
+			}
+
+			v := res.ValidationData
+			if res.ValidationStatus != StatusSuccess || v.ClientId != "client-id" || v.UserId != "42" || v.Login != "viewer" {
+				t.Fatalf("ValidateToken() = %+v, want a successful validation", v)
+			}
+
+			if res.Token == nil || !res.Token.NonExpiring || !res.Token.HasScopes(ScopeChatRead) {
+				t.Errorf("ValidateToken() token = %+v, want a non-expiring token granted chat:read", res.Token)
+			}
+		})
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	tests := map[string]struct {
+		clientId   string
+		failure    *twitchauthtest.Failure
+		wantStatus int
+	}{
+		"success":         {clientId: "client-id", wantStatus: http.StatusOK},
+		"wrong client id": {clientId: "other-client-id", wantStatus: http.StatusForbidden},
+		"server error":    {clientId: "client-id", failure: &twitchauthtest.Failure{Status: http.StatusServiceUnavailable, Message: "unavailable"}, wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := useFakeServer(t)
+			s.RegisterApp("client-id", "client-secret")
+			s.RegisterApp("other-client-id", "client-secret")
+			issued := s.IssueToken(twitchauthtest.TokenOptions{ClientId: "client-id"})
+
+			if tt.failure != nil {
+				s.InjectFailure("/oauth2/revoke", *tt.failure)
+			}
+
+			res, err := RevokeToken(tt.clientId, issued.AccessToken)
+			if err != nil {
+				t.Fatalf("RevokeToken() error = %v", err)
+			}
+
+			if res.Meta.StatusCode != tt.wantStatus {
+				t.Fatalf("RevokeToken() status code = %d, want %d", res.Meta.StatusCode, tt.wantStatus)
+			}
+
+			revoked := tt.wantStatus == http.StatusOK
+			if (res.RevocationStatus == StatusSuccess) != revoked || s.Valid(issued.AccessToken) == revoked {
+				t.Errorf("RevokeToken() = %+v, token valid = %t", res, s.Valid(issued.AccessToken))
+			}
+		})
+	}
+}
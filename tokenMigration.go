@@ -0,0 +1,59 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// ErrReauthorizationRequired is returned when a NonExpiring user access token has no refresh token. The user must
+// authorize the app again, via GenerateAuthorizationUrl, to obtain a refreshable token.
+var ErrReauthorizationRequired = errors.New("non-expiring token has no refresh token; the user must reauthorize the app")
+
+/*
+MigrateNonExpiringToken replaces a NonExpiring user access token with an expiring, refreshable one by refreshing it.
+Twitch can invalidate non-expiring tokens at any time as its token policies change, so they should be migrated
+before that happens.
+
+Tokens that already expire are returned unchanged. If the token has no refresh token, ErrReauthorizationRequired is
+returned.
+*/
+func (a *AuthorizationCodeGrantAuthenticator) MigrateNonExpiringToken(ctx context.Context, t *Token) (*Token, error) {
+	if !t.NonExpiring {
+		return t, nil
+	}
+
+	if t.RefreshToken == "" {
+		return nil, ErrReauthorizationRequired
+	}
+
+	r, err := a.RefreshTokenWithContext(ctx, t.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.TokenRequestStatus != StatusSuccess {
+		warnNonExpiringRejected(ctx, t)
+		return nil, tokenRequestError(r.FailureData)
+	}
+
+	m := r.Token
+	m.UserId = t.UserId
+	m.Login = t.Login
+
+	return m, nil
+}
+
+// warnNonExpiringRejected logs a warning when Twitch rejects a NonExpiring token, which usually means that a change
+// to Twitch's token policies has invalidated it.
+func warnNonExpiringRejected(ctx context.Context, t *Token) {
+	if !t.NonExpiring {
+		return
+	}
+
+	logWarn(ctx, "non-expiring twitch token was rejected; twitch may have invalidated it following a policy change",
+		slog.String("kind", t.Kind.String()),
+		slog.String("client_id", t.ClientId),
+		slog.String("user_id", t.UserId),
+	)
+}
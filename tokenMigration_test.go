@@ -0,0 +1,136 @@
+﻿package go_twitchAuth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adamsurek/go-twitchAuth/twitchauthtest"
+)
+
+// captureWarnings installs a logger recording warnings for the duration of the test.
+func captureWarnings(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	SetLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	t.Cleanup(func() { SetLogger(nil) })
+
+	return &buf
+}
+
+func TestMigrateNonExpiringToken(t *testing.T) {
+	tests := map[string]struct {
+		nonExpiring bool
+		refreshable bool
+		revoke      bool
+		wantErr     bool
+		// wantSentinel is the error wrapped by the returned error, if any.
+		wantSentinel error
+		wantWarning  bool
+	}{
+		"non-expiring":     {nonExpiring: true, refreshable: true},
+		"already expiring": {refreshable: true},
+		// Expiring tokens are not refreshed, so Twitch is never asked about the revoked token.
+		"expiring and revoked": {refreshable: true, revoke: true},
+		"no refresh token":     {nonExpiring: true, wantErr: true, wantSentinel: ErrReauthorizationRequired},
+		"invalidated":          {nonExpiring: true, refreshable: true, revoke: true, wantErr: true, wantWarning: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := useFakeServer(t)
+			s.RegisterApp("client-id", "client-secret", "http://localhost/callback")
+			warnings := captureWarnings(t)
+
+			opts := twitchauthtest.TokenOptions{
+				ClientId:    "client-id",
+				User:        &twitchauthtest.User{Id: "42", Login: "viewer"},
+				Scopes:      []string{"chat:read"},
+				Refreshable: tt.refreshable,
+			}
+			if !tt.nonExpiring {
+				opts.ExpiresIn = time.Hour
+			}
+			issued := s.IssueToken(opts)
+
+			old := &Token{
+				AccessToken:  issued.AccessToken,
+				RefreshToken: issued.RefreshToken,
+				Kind:         TokenKindUser,
+				ClientId:     "client-id",
+				UserId:       "42",
+				Login:        "viewer",
+				NonExpiring:  tt.nonExpiring,
+			}
+			if !tt.nonExpiring {
+				old.ExpiresAt = time.Now().Add(time.Hour)
+			}
+
+			if tt.revoke {
+				err := s.Revoke(issued.AccessToken)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			a := NewAuthorizationCodeGrantAuthenticator("client-id", "client-secret", false, "http://localhost/callback", []ScopeType{ScopeChatRead}, "state")
+			got, err := a.MigrateNonExpiringToken(context.Background(), old)
+
+			if (err != nil) != tt.wantErr || (err != nil && got != nil) {
+				t.Fatalf("MigrateNonExpiringToken() = %+v, %v; wantErr %t", got, err, tt.wantErr)
+			}
+
+			if tt.wantSentinel != nil && !errors.Is(err, tt.wantSentinel) {
+				t.Errorf("MigrateNonExpiringToken() error = %v, want %v", err, tt.wantSentinel)
+			}
+
+			switch {
+			case tt.wantErr:
+			case !tt.nonExpiring:
+				if got != old {
+					t.Errorf("MigrateNonExpiringToken() = %+v, want the expiring token unchanged", got)
+				}
+			default:
+				if got.NonExpiring || got.ExpiresAt.IsZero() || got.AccessToken == old.AccessToken || got.RefreshToken == "" {
+					t.Errorf("MigrateNonExpiringToken() = %+v, want a new expiring, refreshable token", got)
+				}
+
+				if got.UserId != "42" || got.Login != "viewer" {
+					t.Errorf("MigrateNonExpiringToken() user = %q/%q, want 42/viewer", got.UserId, got.Login)
+				}
+
+				if s.Valid(old.AccessToken) {
+					t.Error("the non-expiring token is still valid after migration")
+				}
+			}
+
+			warned := strings.Contains(warnings.String(), "non-expiring twitch token was rejected")
+			if warned != tt.wantWarning {
+				t.Errorf("warning logged = %t, want %t:\n%s", warned, tt.wantWarning, warnings)
+			}
+
+			if warned && (!strings.Contains(warnings.String(), "user_id=42") || !strings.Contains(warnings.String(), "client_id=client-id")) {
+				t.Errorf("warning does not identify the token:\n%s", warnings)
+			}
+		})
+	}
+}
+
+func TestWarnNonExpiringRejected(t *testing.T) {
+	warnings := captureWarnings(t)
+
+	warnNonExpiringRejected(context.Background(), &Token{Kind: TokenKindApp, ClientId: "client-id", ExpiresAt: time.Now().Add(time.Hour)})
+	if warnings.Len() != 0 {
+		t.Fatalf("warning logged for an expiring token:\n%s", warnings)
+	}
+
+	warnNonExpiringRejected(context.Background(), &Token{Kind: TokenKindApp, ClientId: "client-id", NonExpiring: true})
+	if !strings.Contains(warnings.String(), "level=WARN") || !strings.Contains(warnings.String(), "kind=app") {
+		t.Errorf("warning for a non-expiring app token = %q, want a warning identifying the app token", warnings)
+	}
+}
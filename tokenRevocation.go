@@ -0,0 +1,225 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// defaultRevocationConcurrency is the number of concurrent requests used by RevokeTokens when no concurrency is
+// supplied.
+const defaultRevocationConcurrency = 4
+
+// TokenStore is implemented by applications that persist user tokens, allowing DisconnectUser to remove them.
+type TokenStore interface {
+	// DeleteUserTokens removes every stored token belonging to the supplied user.
+	DeleteUserTokens(ctx context.Context, userId string) error
+}
+
+// RevocationOption configures the requests sent by DisconnectUser and RevokeTokens.
+type RevocationOption func(*requestConfig) error
+
+// WithRevocationHTTPClient sets the http.Client used to send revocation and validation requests in place of the one
+// installed via SetHTTPClient.
+func WithRevocationHTTPClient(hc *http.Client) RevocationOption {
+	return func(c *requestConfig) error {
+		if hc == nil {
+			return errors.New("WithRevocationHTTPClient: client must not be nil")
+		}
+
+		c.httpClient = hc
+		return nil
+	}
+}
+
+// WithRevocationEndpoints sets the Twitch OAuth endpoints used to send revocation and validation requests. Only
+// RevocationUrl and ValidationUrl are used, and fall back to Twitch's production endpoints if empty.
+func WithRevocationEndpoints(e Endpoints) RevocationOption {
+	return func(c *requestConfig) error {
+		err := checkEndpoints("WithRevocationEndpoints", e)
+		if err != nil {
+			return err
+		}
+
+		c.endpoints = e
+		return nil
+	}
+}
+
+// newRevocationConfig builds the requestConfig used by DisconnectUser and RevokeTokens from the supplied options.
+func newRevocationConfig(opts []RevocationOption) (requestConfig, error) {
+	c := requestConfig{endpoints: DefaultEndpoints()}
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+
+		err := opt(&c)
+		if err != nil {
+			return requestConfig{}, err
+		}
+	}
+
+	return c, nil
+}
+
+// UserTokens stores the tokens that an app holds for a single user.
+type UserTokens struct {
+	UserId       string
+	AccessToken  string
+	RefreshToken string
+}
+
+// DisconnectResult stores the outcome of each step taken by DisconnectUser.
+type DisconnectResult struct {
+	// AccessTokenRevocation is nil if no access token was supplied.
+	AccessTokenRevocation *TokenRevocationResponse
+	// RefreshTokenRevocation is nil if no refresh token was supplied.
+	RefreshTokenRevocation *TokenRevocationResponse
+	// Removed is true once the user's tokens have been deleted from the TokenStore.
+	Removed bool
+	// Verified is true once a validation request has confirmed that the access token is no longer valid.
+	Verified bool
+}
+
+// RevocationResult stores the outcome of revoking a single token via RevokeTokens.
+type RevocationResult struct {
+	Token    string
+	Response *TokenRevocationResponse
+	Err      error
+}
+
+/*
+DisconnectUser revokes every token an app holds for a user, for example when a broadcaster disconnects the app or
+requests that their data is deleted. The access token is revoked first, followed by the refresh token. Twitch
+rejects a token with a 400 "Invalid token" failure if it has already been invalidated, ex. a refresh token revoked
+along with its access token, or a token the user already disconnected. Such a token is no longer usable, so the
+failure is not treated as an error for either token.
+
+If store is not nil, the user's tokens are then deleted from it. Finally, the access token is validated to confirm
+that it is no longer accepted by Twitch.
+
+Every step is attempted even if an earlier one fails. Any errors encountered, including revocations that Twitch
+rejected for other reasons, are joined and returned alongside the DisconnectResult. Requests are sent using the
+package-level http.Client and Twitch's production endpoints, unless overridden via opts. The DisconnectResult is
+only nil if an option is invalid.
+*/
+func DisconnectUser(ctx context.Context, clientId string, user UserTokens, store TokenStore, opts ...RevocationOption) (*DisconnectResult, error) {
+	c, err := newRevocationConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var r DisconnectResult
+	var errs []error
+
+	if user.AccessToken != "" {
+		res, err := revokeToken(ctx, clientId, user.AccessToken, c)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error while revoking access token: %w", err))
+		} else if res.RevocationStatus != StatusSuccess && !isInvalidTokenFailure(res) {
+			errs = append(errs, revocationFailure("access token", res))
+		}
+		r.AccessTokenRevocation = res
+	}
+
+	if user.RefreshToken != "" {
+		res, err := revokeToken(ctx, clientId, user.RefreshToken, c)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error while revoking refresh token: %w", err))
+		} else if res.RevocationStatus != StatusSuccess && !isInvalidTokenFailure(res) {
+			errs = append(errs, revocationFailure("refresh token", res))
+		}
+		r.RefreshTokenRevocation = res
+	}
+
+	if store != nil {
+		err := store.DeleteUserTokens(ctx, user.UserId)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error while deleting stored tokens: %w", err))
+		} else {
+			r.Removed = true
+		}
+	}
+
+	if user.AccessToken != "" {
+		v, err := validateToken(ctx, user.AccessToken, c)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error while verifying access token revocation: %w", err))
+		} else {
+			r.Verified = v.ValidationStatus == StatusFailure && v.FailureData != nil && v.FailureData.Status == 401
+		}
+	}
+
+	return &r, errors.Join(errs...)
+}
+
+// revocationFailure describes a revocation request that Twitch rejected.
+func revocationFailure(token string, res *TokenRevocationResponse) error {
+	if res.FailureData == nil {
+		e := fmt.Sprintf("twitch rejected %s revocation: %d", token, res.Meta.StatusCode)
+		return errors.New(e)
+	}
+
+	e := fmt.Sprintf("twitch rejected %s revocation: %d - %s", token, res.FailureData.Status, res.FailureData.Message)
+	return errors.New(e)
+}
+
+// isInvalidTokenFailure reports whether Twitch rejected a revocation because the token was already invalid.
+func isInvalidTokenFailure(res *TokenRevocationResponse) bool {
+	return res.FailureData != nil && res.FailureData.Status == 400 && res.FailureData.Message == "Invalid token"
+}
+
+/*
+RevokeTokens revokes every supplied token, sending at most concurrency requests at once. A concurrency of 0 or less
+uses a default of 4. The returned results are in the same order as tokens.
+
+As with DisconnectUser, a Response rejected with a 400 "Invalid token" failure means that the token had already been
+invalidated. Requests are sent using the package-level http.Client and Twitch's production endpoints, unless
+overridden via opts; invalid options fail every result.
+*/
+func RevokeTokens(ctx context.Context, clientId string, tokens []string, concurrency int, opts ...RevocationOption) []RevocationResult {
+	if concurrency <= 0 {
+		concurrency = defaultRevocationConcurrency
+	}
+
+	results := make([]RevocationResult, len(tokens))
+
+	c, err := newRevocationConfig(opts)
+	if err != nil {
+		for i, t := range tokens {
+			results[i] = RevocationResult{Token: t, Err: err}
+		}
+
+		return results
+	}
+
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i, t := range tokens {
+		results[i].Token = t
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, t string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			results[i].Response, results[i].Err = revokeToken(ctx, clientId, t, c)
+		}(i, t)
+	}
+
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,136 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/adamsurek/go-twitchAuth/twitchauthtest"
+)
+
+func TestDisconnectUser(t *testing.T) {
+	tests := map[string]struct {
+		accessToken bool
+		failure     *twitchauthtest.Failure
+		wantErr     bool
+	}{
+		// The fake revokes the refresh token along with the access token, so the refresh token's revocation is
+		// rejected with "Invalid token", which is not an error.
+		"success":                {accessToken: true},
+		"access token rejected":  {accessToken: true, failure: &twitchauthtest.Failure{Status: http.StatusInternalServerError, Message: "internal error"}, wantErr: true},
+		"access token invalid":   {accessToken: true, failure: &twitchauthtest.Failure{Status: http.StatusBadRequest, Message: "Invalid token"}},
+		"refresh token rejected": {failure: &twitchauthtest.Failure{Status: http.StatusForbidden, Message: "the client id does not match the token"}, wantErr: true},
+		"refresh token only":     {},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := useFakeServer(t)
+			s.RegisterApp("client-id", "client-secret")
+			issued := s.IssueToken(twitchauthtest.TokenOptions{
+				ClientId:    "client-id",
+				User:        &twitchauthtest.User{Id: "42", Login: "viewer"},
+				Refreshable: true,
+			})
+
+			user := UserTokens{UserId: "42", RefreshToken: issued.RefreshToken}
+			if tt.accessToken {
+				user.AccessToken = issued.AccessToken
+			}
+
+			if tt.failure != nil {
+				s.InjectFailure("/oauth2/revoke", *tt.failure)
+			}
+
+			_, err := DisconnectUser(context.Background(), "client-id", user, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DisconnectUser() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDisconnectUserUsesOptions(t *testing.T) {
+	// The package-level client is left pointing at Twitch, so requests only reach the fake via the supplied client.
+	s := twitchauthtest.NewServer()
+	t.Cleanup(s.Close)
+	s.RegisterApp("client-id", "client-secret")
+
+	issued := s.IssueToken(twitchauthtest.TokenOptions{ClientId: "client-id", User: &twitchauthtest.User{Id: "42", Login: "viewer"}, Refreshable: true})
+	user := UserTokens{UserId: "42", AccessToken: issued.AccessToken, RefreshToken: issued.RefreshToken}
+
+	r, err := DisconnectUser(context.Background(), "client-id", user, nil, WithRevocationHTTPClient(s.Client()))
+	if err != nil {
+		t.Fatalf("DisconnectUser() error = %v", err)
+	}
+
+	if !r.Verified || s.Valid(issued.AccessToken) {
+		t.Errorf("DisconnectUser() = %+v, want the access token revoked and verified", r)
+	}
+
+	_, err = DisconnectUser(context.Background(), "client-id", user, nil, WithRevocationEndpoints(Endpoints{RevocationUrl: "/oauth2/revoke"}))
+	if err == nil {
+		t.Error("DisconnectUser() with a relative endpoint error = nil, want an error")
+	}
+}
+
+func TestRevokeTokens(t *testing.T) {
+	s := twitchauthtest.NewServer()
+	t.Cleanup(s.Close)
+	s.RegisterApp("client-id", "client-secret")
+
+	var tokens []string
+	for i := 0; i < 5; i++ {
+		tokens = append(tokens, s.IssueToken(twitchauthtest.TokenOptions{ClientId: "client-id"}).AccessToken)
+	}
+	tokens = append(tokens, "unknown-token")
+
+	results := RevokeTokens(context.Background(), "client-id", tokens, 2, WithRevocationHTTPClient(s.Client()))
+	if len(results) != len(tokens) {
+		t.Fatalf("RevokeTokens() returned %d results for %d tokens", len(results), len(tokens))
+	}
+
+	for i, r := range results {
+		if r.Token != tokens[i] || r.Err != nil {
+			t.Fatalf("result %d = %+v, want the result for %q without an error", i, r, tokens[i])
+		}
+
+		if r.Token == "unknown-token" {
+			if r.Response.RevocationStatus != StatusFailure || !isInvalidTokenFailure(r.Response) {
+				t.Errorf("revocation of an unknown token = %+v, want an \"Invalid token\" failure", r.Response)
+			}
+			continue
+		}
+
+		if r.Response.RevocationStatus != StatusSuccess || s.Valid(r.Token) {
+			t.Errorf("revocation %d = %v, want the token revoked", i, r.Response.RevocationStatus)
+		}
+	}
+}
+
+func TestRevokeTokensStops(t *testing.T) {
+	s := twitchauthtest.NewServer()
+	t.Cleanup(s.Close)
+	s.RegisterApp("client-id", "client-secret")
+
+	tokens := []string{
+		s.IssueToken(twitchauthtest.TokenOptions{ClientId: "client-id"}).AccessToken,
+		s.IssueToken(twitchauthtest.TokenOptions{ClientId: "client-id"}).AccessToken,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for i, r := range RevokeTokens(ctx, "client-id", tokens, 1, WithRevocationHTTPClient(s.Client())) {
+		if !errors.Is(r.Err, context.Canceled) || !s.Valid(tokens[i]) {
+			t.Errorf("result %d after cancellation = %+v, want context.Canceled with the token left valid", i, r)
+		}
+	}
+
+	for i, r := range RevokeTokens(context.Background(), "client-id", tokens, 1, WithRevocationHTTPClient(nil)) {
+		if r.Token != tokens[i] || r.Err == nil || r.Response != nil {
+			t.Errorf("result %d with an invalid option = %+v, want an error", i, r)
+		}
+	}
+}
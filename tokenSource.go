@@ -0,0 +1,225 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tokenRenewalMargin is how long before expiry a TokenSource replaces its cached token.
+const tokenRenewalMargin = time.Minute
+
+// ErrNoRefreshToken is returned when a user access token needs to be refreshed but has no refresh token.
+var ErrNoRefreshToken = errors.New("token has no refresh token")
+
+// ErrRefreshTokenRejected is returned when Twitch rejects the refresh token of a user access token, meaning that the
+// user must authorize the app again.
+var ErrRefreshTokenRejected = errors.New("refresh token was rejected")
+
+// ErrNoToken is returned by a UserTokenSource that was created without a token.
+var ErrNoToken = errors.New("token source has no token")
+
+/*
+TokenSource supplies tokens to a Transport, replacing them as they expire. NonExpiring tokens are only replaced
+once Twitch rejects them, at which point a warning is logged.
+
+Implementations must be safe for concurrent use.
+*/
+type TokenSource interface {
+	// Token returns a valid token, replacing the cached one if it has expired or is about to.
+	Token(ctx context.Context) (*Token, error)
+
+	// RenewToken replaces the supplied token, which has been rejected by Twitch. If the token has already been
+	// replaced by another caller, the replacement is returned instead.
+	RenewToken(ctx context.Context, stale *Token) (*Token, error)
+}
+
+// AppTokenSource is a TokenSource that manages an app access token, requesting a new one via the client
+// credentials grant flow as needed.
+//
+// New instances of AppTokenSource should be created via NewAppTokenSource.
+type AppTokenSource struct {
+	mu            sync.Mutex
+	authenticator *ClientCredentialsGrantAuthenticator
+	token         *Token
+}
+
+// NewAppTokenSource generates a new AppTokenSource instance. No token is requested until one is needed.
+func NewAppTokenSource(a *ClientCredentialsGrantAuthenticator) *AppTokenSource {
+	return &AppTokenSource{authenticator: a}
+}
+
+// Token returns the cached app access token, requesting a new one if it has expired or is about to.
+func (s *AppTokenSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != nil && !s.token.ExpiresWithin(tokenRenewalMargin) {
+		return s.token, nil
+	}
+
+	return s.fetch(ctx)
+}
+
+// RenewToken requests a new app access token, unless the supplied token has already been replaced.
+func (s *AppTokenSource) RenewToken(ctx context.Context, stale *Token) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != nil && (stale == nil || s.token.AccessToken != stale.AccessToken) {
+		return s.token, nil
+	}
+
+	if stale != nil {
+		warnNonExpiringRejected(ctx, stale)
+	}
+
+	return s.fetch(ctx)
+}
+
+// TokenExpiries reports the expiry of the cached app access token.
+func (s *AppTokenSource) TokenExpiries() []TokenExpiry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == nil {
+		return nil
+	}
+
+	return []TokenExpiry{{ClientId: s.token.ClientId, ExpiresAt: s.token.ExpiresAt}}
+}
+
+// fetch requests and caches a new app access token. The caller must hold s.mu.
+func (s *AppTokenSource) fetch(ctx context.Context) (*Token, error) {
+	r, err := s.authenticator.GetTokenWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.TokenRequestStatus != StatusSuccess {
+		return nil, tokenRequestError(r.FailureData)
+	}
+
+	s.token = r.Token
+
+	return s.token, nil
+}
+
+// UserTokenSource is a TokenSource that manages a user access token, refreshing it via the authorization code grant
+// flow as needed.
+//
+// New instances of UserTokenSource should be created via NewUserTokenSource.
+type UserTokenSource struct {
+	mu            sync.Mutex
+	authenticator *AuthorizationCodeGrantAuthenticator
+	token         *Token
+	onRefresh     func(*Token)
+}
+
+// NewUserTokenSource generates a new UserTokenSource instance that starts with the supplied token. If onRefresh is
+// not nil, it is called with every refreshed token, allowing it to be persisted. If the token is nil, every request for
+// a token fails with ErrNoToken.
+func NewUserTokenSource(a *AuthorizationCodeGrantAuthenticator, t *Token, onRefresh func(*Token)) *UserTokenSource {
+	return &UserTokenSource{
+		authenticator: a,
+		token:         t,
+		onRefresh:     onRefresh,
+	}
+}
+
+// Token returns the cached user access token, refreshing it if it has expired or is about to.
+func (s *UserTokenSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.token.ExpiresWithin(tokenRenewalMargin) {
+		return s.token, nil
+	}
+
+	return s.refresh(ctx)
+}
+
+// RenewToken refreshes the user access token, unless the supplied token has already been replaced.
+func (s *UserTokenSource) RenewToken(ctx context.Context, stale *Token) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == nil {
+		return nil, ErrNoToken
+	}
+
+	if stale == nil || s.token.AccessToken != stale.AccessToken {
+		return s.token, nil
+	}
+
+	warnNonExpiringRejected(ctx, stale)
+
+	return s.refresh(ctx)
+}
+
+// TokenExpiries reports the expiry of the cached user access token.
+func (s *UserTokenSource) TokenExpiries() []TokenExpiry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == nil {
+		return nil
+	}
+
+	return []TokenExpiry{{UserId: s.token.UserId, ClientId: s.token.ClientId, ExpiresAt: s.token.ExpiresAt}}
+}
+
+// refresh refreshes and caches the user access token. The caller must hold s.mu.
+func (s *UserTokenSource) refresh(ctx context.Context) (*Token, error) {
+	if s.token == nil {
+		return nil, ErrNoToken
+	}
+
+	if s.token.RefreshToken == "" {
+		return nil, ErrNoRefreshToken
+	}
+
+	r, err := s.authenticator.RefreshTokenWithContext(ctx, s.token.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.TokenRequestStatus != StatusSuccess {
+		return nil, refreshRequestError(r.FailureData)
+	}
+
+	// Refresh responses don't identify the user, so carry over what is already known.
+	t := r.Token
+	t.UserId = s.token.UserId
+	t.Login = s.token.Login
+	s.token = t
+
+	if s.onRefresh != nil {
+		s.onRefresh(t)
+	}
+
+	return t, nil
+}
+
+// tokenRequestError builds the error returned when Twitch rejects a token request.
+func tokenRequestError(f *FailedRequestResponse) error {
+	if f == nil {
+		return errors.New("token request failed")
+	}
+
+	e := fmt.Sprintf("token request failed: %d - %s", f.Status, f.Message)
+	return errors.New(e)
+}
+
+// refreshRequestError builds the error returned when Twitch rejects a refresh request. Twitch responds with a 400 or
+// 401 if the refresh token itself is invalid, in which case the error wraps ErrRefreshTokenRejected.
+func refreshRequestError(f *FailedRequestResponse) error {
+	if f == nil || (f.Status != http.StatusBadRequest && f.Status != http.StatusUnauthorized) {
+		return tokenRequestError(f)
+	}
+
+	return fmt.Errorf("%w: %d - %s", ErrRefreshTokenRejected, f.Status, f.Message)
+}
@@ -0,0 +1,175 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/adamsurek/go-twitchAuth/twitchauthtest"
+)
+
+func TestAppTokenSourceToken(t *testing.T) {
+	s := useFakeServer(t)
+	s.RegisterApp("client-id", "client-secret")
+
+	source := NewAppTokenSource(NewClientCredentialsGrantAuthenticator("client-id", "client-secret"))
+
+	first, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+
+	cached, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+
+	if cached != first {
+		t.Errorf("Token() requested a new token while the cached one was valid")
+	}
+
+	// A token expiring within the renewal margin is replaced.
+	first.ExpiresAt = time.Now().Add(tokenRenewalMargin / 2)
+
+	renewed, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+
+	if renewed.AccessToken == first.AccessToken {
+		t.Errorf("Token() returned a token expiring within the renewal margin")
+	}
+
+	expiries := source.TokenExpiries()
+	if len(expiries) != 1 || !expiries[0].ExpiresAt.Equal(renewed.ExpiresAt) {
+		t.Errorf("TokenExpiries() = %v, want the expiry of the renewed token", expiries)
+	}
+}
+
+func TestAppTokenSourceRenewToken(t *testing.T) {
+	s := useFakeServer(t)
+	s.RegisterApp("client-id", "client-secret")
+
+	source := NewAppTokenSource(NewClientCredentialsGrantAuthenticator("client-id", "client-secret"))
+
+	if source.TokenExpiries() != nil {
+		t.Errorf("TokenExpiries() before the first request = %v, want nil", source.TokenExpiries())
+	}
+
+	stale, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+
+	renewed, err := source.RenewToken(context.Background(), stale)
+	if err != nil {
+		t.Fatalf("RenewToken() error = %v", err)
+	}
+
+	if renewed.AccessToken == stale.AccessToken {
+		t.Fatalf("RenewToken() returned the rejected token")
+	}
+
+	// Renewing the same stale token again, ex. from a concurrent request, reuses its replacement.
+	again, err := source.RenewToken(context.Background(), stale)
+	if err != nil {
+		t.Fatalf("RenewToken() error = %v", err)
+	}
+
+	if again != renewed {
+		t.Errorf("RenewToken() of an already replaced token requested another token")
+	}
+}
+
+func TestUserTokenSourceToken(t *testing.T) {
+	s := useFakeServer(t)
+	s.RegisterApp("client-id", "client-secret", "http://localhost/callback")
+	issued := s.IssueToken(twitchauthtest.TokenOptions{
+		ClientId:    "client-id",
+		User:        &twitchauthtest.User{Id: "1", Login: "user"},
+		ExpiresIn:   time.Hour,
+		Refreshable: true,
+	})
+
+	a := NewAuthorizationCodeGrantAuthenticator("client-id", "client-secret", false, "http://localhost/callback", nil, "")
+	current := &Token{
+		AccessToken:  issued.AccessToken,
+		RefreshToken: issued.RefreshToken,
+		Kind:         TokenKindUser,
+		ClientId:     "client-id",
+		UserId:       "1",
+		Login:        "user",
+		ExpiresAt:    time.Now().Add(time.Hour),
+	}
+
+	var refreshed []*Token
+	source := NewUserTokenSource(a, current, func(t *Token) { refreshed = append(refreshed, t) })
+
+	cached, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+
+	if cached != current || len(refreshed) != 0 {
+		t.Fatalf("Token() refreshed a token that was not about to expire")
+	}
+
+	current.ExpiresAt = time.Now().Add(tokenRenewalMargin / 2)
+
+	renewed, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+
+	if renewed.AccessToken == current.AccessToken || len(refreshed) != 1 || refreshed[0] != renewed {
+		t.Fatalf("Token() did not refresh a token expiring within the renewal margin")
+	}
+
+	if renewed.UserId != "1" || renewed.Login != "user" {
+		t.Errorf("refreshed token user = %q/%q, want %q/%q", renewed.UserId, renewed.Login, "1", "user")
+	}
+
+	// The original token is stale, so renewing it returns its replacement without refreshing again.
+	again, err := source.RenewToken(context.Background(), current)
+	if err != nil {
+		t.Fatalf("RenewToken() error = %v", err)
+	}
+
+	if again != renewed || len(refreshed) != 1 {
+		t.Errorf("RenewToken() of an already replaced token refreshed it again")
+	}
+}
+
+func TestUserTokenSourceWithoutUsableToken(t *testing.T) {
+	a := NewAuthorizationCodeGrantAuthenticator("client-id", "client-secret", false, "http://localhost/callback", nil, "")
+	expiring := &Token{AccessToken: "access-token", Kind: TokenKindUser, ExpiresAt: time.Now().Add(time.Second)}
+
+	tests := map[string]struct {
+		token *Token
+		want  error
+	}{
+		"nil token":        {token: nil, want: ErrNoToken},
+		"no refresh token": {token: expiring, want: ErrNoRefreshToken},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			source := NewUserTokenSource(a, tt.token, nil)
+
+			_, err := source.Token(context.Background())
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Token() error = %v, want %v", err, tt.want)
+			}
+
+			_, err = source.RenewToken(context.Background(), tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("RenewToken() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if got := NewUserTokenSource(a, nil, nil).TokenExpiries(); got != nil {
+		t.Errorf("TokenExpiries() without a token = %v, want nil", got)
+	}
+}
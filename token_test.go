@@ -0,0 +1,25 @@
+﻿package go_twitchAuth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenExpiresWithin(t *testing.T) {
+	tests := map[string]struct {
+		token *Token
+		want  bool
+	}{
+		"nil":          {token: nil, want: true},
+		"expired":      {token: &Token{ExpiresAt: time.Now().Add(-time.Minute)}, want: true},
+		"expiring":     {token: &Token{ExpiresAt: time.Now().Add(time.Minute)}, want: true},
+		"not expiring": {token: &Token{ExpiresAt: time.Now().Add(time.Hour)}, want: false},
+		"non-expiring": {token: &Token{NonExpiring: true}, want: false},
+	}
+
+	for name, tt := range tests {
+		if got := tt.token.ExpiresWithin(5 * time.Minute); got != tt.want {
+			t.Errorf("%s: ExpiresWithin() = %t, want %t", name, got, tt.want)
+		}
+	}
+}
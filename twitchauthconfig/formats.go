@@ -0,0 +1,52 @@
+﻿/*
+Package twitchauthconfig adds YAML and TOML support to go_twitchAuth's config loading.
+
+go_twitchAuth only reads JSON config files by default, so that it does not depend on a YAML or TOML library. Importing
+this package for its side effects registers the .yaml, .yml and .toml extensions via
+go_twitchAuth.RegisterConfigFormat, after which LoadConfig, LoadConfigFile and ReadConfig accept files in either
+format:
+
+	import _ "github.com/adamsurek/go-twitchAuth/twitchauthconfig"
+
+As with JSON, fields that go_twitchAuth.Config does not define are rejected.
+*/
+package twitchauthconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+	ta "github.com/adamsurek/go-twitchAuth"
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	ta.RegisterConfigFormat(".yaml", DecodeYaml)
+	ta.RegisterConfigFormat(".yml", DecodeYaml)
+	ta.RegisterConfigFormat(".toml", DecodeToml)
+}
+
+// DecodeYaml is the go_twitchAuth.ConfigDecoder for YAML files.
+func DecodeYaml(b []byte, c *ta.Config) error {
+	d := yaml.NewDecoder(bytes.NewReader(b))
+	d.KnownFields(true)
+
+	return d.Decode(c)
+}
+
+// DecodeToml is the go_twitchAuth.ConfigDecoder for TOML files.
+func DecodeToml(b []byte, c *ta.Config) error {
+	md, err := toml.Decode(string(b), c)
+	if err != nil {
+		return err
+	}
+
+	if len(md.Undecoded()) > 0 {
+		e := fmt.Sprintf("unknown field %q", md.Undecoded()[0].String())
+		return errors.New(e)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+﻿package twitchauthconfig
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	ta "github.com/adamsurek/go-twitchAuth"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	for _, k := range []string{ta.EnvClientId, ta.EnvClientSecret, ta.EnvRedirectUri, ta.EnvScopes, ta.EnvForceVerify} {
+		t.Setenv(k, "")
+	}
+
+	const yamlConfig = `client_id: abcdefghijklmnopqrstuvwxyz0123
+client_secret: secret
+redirect_uri: http://localhost/callback
+scopes:
+  - chat:read
+  - user:read:chat
+force_verify: true
+`
+	const tomlConfig = `client_id = "abcdefghijklmnopqrstuvwxyz0123"
+client_secret = "secret"
+redirect_uri = "http://localhost/callback"
+scopes = ["chat:read", "user:read:chat"]
+force_verify = true
+`
+
+	tests := map[string]struct {
+		name     string
+		contents string
+		wantErr  string
+	}{
+		"yaml":               {name: "config.yaml", contents: yamlConfig},
+		"yml":                {name: "config.YML", contents: yamlConfig},
+		"toml":               {name: "config.toml", contents: tomlConfig},
+		"yaml unknown field": {name: "config.yaml", contents: yamlConfig + "scope: chat:read\n", wantErr: "field scope not found"},
+		"toml unknown field": {name: "config.toml", contents: tomlConfig + "scope = \"chat:read\"\n", wantErr: `unknown field "scope"`},
+		"yaml malformed":     {name: "config.yaml", contents: "client_id: [", wantErr: "error while parsing config file"},
+		"toml malformed":     {name: "config.toml", contents: "client_id = ", wantErr: "error while parsing config file"},
+		"yaml invalid scope": {name: "config.yaml", contents: "client_id: abcdefghijklmnopqrstuvwxyz0123\nscopes: [chat:shout]\n", wantErr: "invalid scope"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.name)
+			err := os.WriteFile(path, []byte(tt.contents), 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := ta.LoadConfigFile(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("LoadConfigFile() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("LoadConfigFile() error = %v", err)
+			}
+
+			if c.ClientId != "abcdefghijklmnopqrstuvwxyz0123" || c.ClientSecret != "secret" || c.RedirectUri != "http://localhost/callback" ||
+				!slices.Equal(c.Scopes, []string{"chat:read", "user:read:chat"}) || !c.ForceVerify {
+				t.Errorf("LoadConfigFile() = %+v, want every field of the file", *c)
+			}
+		})
+	}
+}
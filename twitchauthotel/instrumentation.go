@@ -0,0 +1,226 @@
+﻿/*
+Package twitchauthotel instruments go_twitchAuth with OpenTelemetry tracing and metrics.
+
+Create an Instrumentation via New and install it with go_twitchAuth.SetRequestObservers. Every request sent by
+ValidateToken, RevokeToken and the authenticators is then recorded as a span, along with the following metrics:
+
+  - twitchauth.request.duration: histogram of request latency, in seconds
+  - twitchauth.token.refreshes: counter of refresh token requests
+  - twitchauth.token.validation_failures: counter of token validation requests that did not succeed
+  - twitchauth.token.revocations: counter of token revocation requests
+  - twitchauth.tokens.expiring: gauge of managed tokens that expire within the configured threshold
+*/
+package twitchauthotel
+
+import (
+	"context"
+	"time"
+
+	ta "github.com/adamsurek/go-twitchAuth"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/adamsurek/go-twitchAuth/twitchauthotel"
+
+var _ ta.RequestObserver = (*Instrumentation)(nil)
+
+// defaultExpiryThreshold is the default window in which a managed token is considered to be nearing expiry.
+const defaultExpiryThreshold = 5 * time.Minute
+
+// Instrumentation is a go_twitchAuth.RequestObserver that records OpenTelemetry spans and metrics.
+//
+// New instances of Instrumentation should be created via New.
+type Instrumentation struct {
+	tracer             trace.Tracer
+	latency            metric.Float64Histogram
+	refreshes          metric.Int64Counter
+	validationFailures metric.Int64Counter
+	revocations        metric.Int64Counter
+}
+
+// config stores the settings applied by each Option.
+type config struct {
+	tracerProvider  trace.TracerProvider
+	meterProvider   metric.MeterProvider
+	expirySources   []ta.TokenExpirySource
+	expiryThreshold time.Duration
+}
+
+// Option configures an Instrumentation.
+type Option func(*config)
+
+// WithTracerProvider sets the trace.TracerProvider used to create spans. The global provider is used by default.
+func WithTracerProvider(p trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracerProvider = p
+	}
+}
+
+// WithMeterProvider sets the metric.MeterProvider used to record metrics. The global provider is used by default.
+func WithMeterProvider(p metric.MeterProvider) Option {
+	return func(c *config) {
+		c.meterProvider = p
+	}
+}
+
+// WithTokenExpirySource adds a token cache whose tokens are reported by the twitchauth.tokens.expiring gauge.
+func WithTokenExpirySource(s ta.TokenExpirySource) Option {
+	return func(c *config) {
+		c.expirySources = append(c.expirySources, s)
+	}
+}
+
+// WithExpiryThreshold sets the window in which a managed token is considered to be nearing expiry. Defaults to
+// 5 minutes.
+func WithExpiryThreshold(d time.Duration) Option {
+	return func(c *config) {
+		c.expiryThreshold = d
+	}
+}
+
+// New generates a new Instrumentation instance.
+func New(opts ...Option) (*Instrumentation, error) {
+	c := config{
+		tracerProvider:  otel.GetTracerProvider(),
+		meterProvider:   otel.GetMeterProvider(),
+		expiryThreshold: defaultExpiryThreshold,
+	}
+
+	for _, o := range opts {
+		o(&c)
+	}
+
+	meter := c.meterProvider.Meter(instrumentationName)
+	i := Instrumentation{
+		tracer: c.tracerProvider.Tracer(instrumentationName),
+	}
+
+	var err error
+	i.latency, err = meter.Float64Histogram("twitchauth.request.duration",
+		metric.WithDescription("Duration of requests sent to the Twitch OAuth endpoints."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	i.refreshes, err = meter.Int64Counter("twitchauth.token.refreshes",
+		metric.WithDescription("Number of refresh token requests."),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	i.validationFailures, err = meter.Int64Counter("twitchauth.token.validation_failures",
+		metric.WithDescription("Number of token validation requests that did not succeed."),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	i.revocations, err = meter.Int64Counter("twitchauth.token.revocations",
+		metric.WithDescription("Number of token revocation requests."),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.expirySources) > 0 {
+		sources := c.expirySources
+		threshold := c.expiryThreshold
+
+		_, err = meter.Int64ObservableGauge("twitchauth.tokens.expiring",
+			metric.WithDescription("Number of managed tokens that expire within the configured threshold."),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				o.Observe(countExpiring(sources, threshold, time.Now()))
+				return nil
+			}),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &i, nil
+}
+
+// RequestStarted starts a span named after the endpoint and, for token requests, the grant type.
+func (i *Instrumentation) RequestStarted(ctx context.Context, info ta.RequestInfo) context.Context {
+	name := "twitchauth " + info.Endpoint.String()
+	if info.GrantType != "" {
+		name += " " + info.GrantType
+	}
+
+	ctx, _ = i.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(requestAttributes(info)...),
+	)
+
+	return ctx
+}
+
+// RequestFinished ends the request's span and records its metrics.
+func (i *Instrumentation) RequestFinished(ctx context.Context, info ta.RequestInfo, result ta.RequestResult) {
+	attrs := requestAttributes(info)
+	if result.ErrorKind != ta.ErrorKindNone {
+		attrs = append(attrs, attribute.String("error.type", result.ErrorKind.String()))
+	}
+
+	span := trace.SpanFromContext(ctx)
+	if result.StatusCode != 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", result.StatusCode))
+	}
+
+	if result.ErrorKind != ta.ErrorKindNone {
+		span.SetAttributes(attribute.String("error.type", result.ErrorKind.String()))
+		if result.Err != nil {
+			span.RecordError(result.Err)
+		}
+		span.SetStatus(codes.Error, result.ErrorKind.String())
+	}
+	span.End()
+
+	set := metric.WithAttributes(attrs...)
+	i.latency.Record(ctx, result.Latency.Seconds(), set)
+
+	switch {
+	case info.GrantType == "refresh_token":
+		i.refreshes.Add(ctx, 1, set)
+	case info.Endpoint == ta.EndpointValidation && result.ErrorKind != ta.ErrorKindNone:
+		i.validationFailures.Add(ctx, 1, set)
+	case info.Endpoint == ta.EndpointRevocation:
+		i.revocations.Add(ctx, 1, set)
+	}
+}
+
+// requestAttributes builds the attributes shared by a request's span and metrics.
+func requestAttributes(info ta.RequestInfo) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("twitch.oauth.endpoint", info.Endpoint.String()),
+	}
+
+	if info.GrantType != "" {
+		attrs = append(attrs, attribute.String("twitch.oauth.grant_type", info.GrantType))
+	}
+
+	return attrs
+}
+
+// countExpiring counts the tokens across every source that expire within threshold of now. Tokens without an expiry
+// are ignored.
+func countExpiring(sources []ta.TokenExpirySource, threshold time.Duration, now time.Time) int64 {
+	var n int64
+	for _, s := range sources {
+		for _, e := range s.TokenExpiries() {
+			if !e.ExpiresAt.IsZero() && e.ExpiresAt.Sub(now) <= threshold {
+				n++
+			}
+		}
+	}
+
+	return n
+}
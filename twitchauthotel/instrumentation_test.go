@@ -0,0 +1,259 @@
+﻿package twitchauthotel
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	ta "github.com/adamsurek/go-twitchAuth"
+	"github.com/adamsurek/go-twitchAuth/twitchauthtest"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// expirySource is a ta.TokenExpirySource reporting a fixed list of expiries.
+type expirySource []ta.TokenExpiry
+
+func (s expirySource) TokenExpiries() []ta.TokenExpiry {
+	return s
+}
+
+// instrument installs an Instrumentation backed by in-memory span and metric readers, and routes the package's
+// requests to a new fake Twitch server.
+func instrument(t *testing.T, opts ...Option) (*twitchauthtest.Server, *tracetest.SpanRecorder, *sdkmetric.ManualReader) {
+	t.Helper()
+
+	spans := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+
+	opts = append(opts,
+		WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))),
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+	)
+
+	i, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := twitchauthtest.NewServer()
+	ta.SetHTTPClient(s.Client())
+	ta.SetRequestObservers(i)
+	t.Cleanup(func() {
+		ta.SetRequestObservers()
+		ta.SetHTTPClient(nil)
+		s.Close()
+	})
+
+	return s, spans, reader
+}
+
+// sendRequests sends a successful validation, a failed validation, a refresh and a revocation, returning every
+// secret involved.
+func sendRequests(t *testing.T, s *twitchauthtest.Server) []string {
+	t.Helper()
+
+	s.RegisterApp("client-id", "client-secret", "http://localhost/callback")
+	tok := s.IssueToken(twitchauthtest.TokenOptions{
+		ClientId:    "client-id",
+		User:        &twitchauthtest.User{Id: "1", Login: "user"},
+		Scopes:      []string{"chat:read"},
+		ExpiresIn:   time.Hour,
+		Refreshable: true,
+	})
+
+	_, err := ta.ValidateToken(tok.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ta.ValidateToken("not-a-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := ta.NewAuthorizationCodeGrantAuthenticator("client-id", "client-secret", false, "http://localhost/callback", nil, "")
+	res, err := a.RefreshToken(tok.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.TokenRequestStatus != ta.StatusSuccess {
+		t.Fatalf("RefreshToken() status = %s, want %s", res.TokenRequestStatus, ta.StatusSuccess)
+	}
+
+	_, err = ta.RevokeToken("client-id", res.Token.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []string{tok.AccessToken, tok.RefreshToken, res.Token.AccessToken, res.Token.RefreshToken, "client-secret", "not-a-token"}
+}
+
+func TestInstrumentationSpans(t *testing.T) {
+	s, spans, _ := instrument(t)
+	secrets := sendRequests(t, s)
+
+	type want struct {
+		name       string
+		attrs      map[attribute.Key]attribute.Value
+		statusCode codes.Code
+	}
+
+	wants := []want{
+		{
+			name: "twitchauth validate",
+			attrs: map[attribute.Key]attribute.Value{
+				"twitch.oauth.endpoint":     attribute.StringValue("validate"),
+				"http.response.status_code": attribute.IntValue(200),
+			},
+			statusCode: codes.Unset,
+		},
+		{
+			name: "twitchauth validate",
+			attrs: map[attribute.Key]attribute.Value{
+				"twitch.oauth.endpoint":     attribute.StringValue("validate"),
+				"http.response.status_code": attribute.IntValue(401),
+				"error.type":                attribute.StringValue("unauthorized"),
+			},
+			statusCode: codes.Error,
+		},
+		{
+			name: "twitchauth token refresh_token",
+			attrs: map[attribute.Key]attribute.Value{
+				"twitch.oauth.endpoint":     attribute.StringValue("token"),
+				"twitch.oauth.grant_type":   attribute.StringValue("refresh_token"),
+				"http.response.status_code": attribute.IntValue(200),
+			},
+			statusCode: codes.Unset,
+		},
+		{
+			name: "twitchauth revoke",
+			attrs: map[attribute.Key]attribute.Value{
+				"twitch.oauth.endpoint":     attribute.StringValue("revoke"),
+				"http.response.status_code": attribute.IntValue(200),
+			},
+			statusCode: codes.Unset,
+		},
+	}
+
+	ended := spans.Ended()
+	if len(ended) != len(wants) {
+		t.Fatalf("recorded %d spans, want %d", len(ended), len(wants))
+	}
+
+	for n, w := range wants {
+		span := ended[n]
+		if span.Name() != w.name {
+			t.Errorf("span %d name = %q, want %q", n, span.Name(), w.name)
+		}
+
+		got := map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes() {
+			got[kv.Key] = kv.Value
+		}
+
+		for k, v := range w.attrs {
+			if got[k] != v {
+				t.Errorf("span %d (%s) attribute %s = %v, want %v", n, span.Name(), k, got[k].Emit(), v.Emit())
+			}
+		}
+
+		if _, ok := got["error.type"]; ok && w.attrs["error.type"].Type() == attribute.INVALID {
+			t.Errorf("span %d (%s) has an unexpected error.type attribute", n, span.Name())
+		}
+
+		if span.Status().Code != w.statusCode {
+			t.Errorf("span %d (%s) status = %v, want %v", n, span.Name(), span.Status().Code, w.statusCode)
+		}
+	}
+
+	// Spans are exported to third parties, so must never carry a token or secret.
+	for _, span := range ended {
+		values := []string{span.Name(), span.Status().Description}
+		for _, kv := range span.Attributes() {
+			values = append(values, kv.Value.Emit())
+		}
+
+		for _, e := range span.Events() {
+			values = append(values, e.Name)
+			for _, kv := range e.Attributes {
+				values = append(values, kv.Value.Emit())
+			}
+		}
+
+		for _, v := range values {
+			for _, secret := range secrets {
+				if strings.Contains(v, secret) {
+					t.Errorf("span %q leaks a secret in %q", span.Name(), v)
+				}
+			}
+		}
+	}
+}
+
+func TestInstrumentationMetrics(t *testing.T) {
+	now := time.Now()
+	s, _, reader := instrument(t,
+		WithExpiryThreshold(time.Minute),
+		WithTokenExpirySource(expirySource{
+			{UserId: "1", ClientId: "client-id", ExpiresAt: now.Add(30 * time.Second)},
+			{UserId: "2", ClientId: "client-id", ExpiresAt: now.Add(time.Hour)},
+			{ClientId: "client-id"},
+		}),
+	)
+	sendRequests(t, s)
+
+	var rm metricdata.ResourceMetrics
+	err := reader.Collect(context.Background(), &rm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sums := map[string]int64{}
+	var latencyCount uint64
+	for _, sm := range rm.ScopeMetrics {
+		if sm.Scope.Name != instrumentationName {
+			t.Errorf("scope name = %q, want %q", sm.Scope.Name, instrumentationName)
+		}
+
+		for _, m := range sm.Metrics {
+			switch d := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, p := range d.DataPoints {
+					sums[m.Name] += p.Value
+				}
+			case metricdata.Gauge[int64]:
+				for _, p := range d.DataPoints {
+					sums[m.Name] += p.Value
+				}
+			case metricdata.Histogram[float64]:
+				for _, p := range d.DataPoints {
+					latencyCount += p.Count
+				}
+			}
+		}
+	}
+
+	wantSums := map[string]int64{
+		"twitchauth.token.refreshes":           1,
+		"twitchauth.token.validation_failures": 1,
+		"twitchauth.token.revocations":         1,
+		"twitchauth.tokens.expiring":           1,
+	}
+
+	for name, want := range wantSums {
+		if sums[name] != want {
+			t.Errorf("%s = %d, want %d", name, sums[name], want)
+		}
+	}
+
+	if latencyCount != 4 {
+		t.Errorf("twitchauth.request.duration count = %d, want 4", latencyCount)
+	}
+}
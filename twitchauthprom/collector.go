@@ -0,0 +1,128 @@
+﻿/*
+Package twitchauthprom exposes go_twitchAuth token lifecycle metrics to Prometheus.
+
+Create a Collector via New, register it with a prometheus.Registerer and install it with
+go_twitchAuth.SetRequestObservers. The Collector exports the following metrics:
+
+  - twitchauth_requests_total: counter of requests, labelled by endpoint and outcome
+  - twitchauth_refresh_duration_seconds: histogram of refresh token request latency
+  - twitchauth_validation_duration_seconds: histogram of token validation request latency
+  - twitchauth_token_expiry_seconds: seconds until each managed token expires, labelled by user ID and client ID.
+    Where several tokens share both labels, the one expiring soonest is reported.
+*/
+package twitchauthprom
+
+import (
+	"context"
+	"time"
+
+	ta "github.com/adamsurek/go-twitchAuth"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	_ prometheus.Collector = (*Collector)(nil)
+	_ ta.RequestObserver   = (*Collector)(nil)
+)
+
+// Collector is a prometheus.Collector and go_twitchAuth.RequestObserver that records token lifecycle metrics.
+//
+// New instances of Collector should be created via New.
+type Collector struct {
+	requests           *prometheus.CounterVec
+	refreshLatency     prometheus.Histogram
+	validationLatency  prometheus.Histogram
+	tokenExpiry        *prometheus.Desc
+	tokenExpirySources []ta.TokenExpirySource
+}
+
+// New generates a new Collector instance. The expiry of every token held by the supplied sources is reported each
+// time the Collector is scraped.
+func New(sources ...ta.TokenExpirySource) *Collector {
+	return &Collector{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "twitchauth_requests_total",
+			Help: "Number of requests sent to the Twitch OAuth endpoints.",
+		}, []string{"endpoint", "outcome"}),
+		refreshLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "twitchauth_refresh_duration_seconds",
+			Help:    "Duration of refresh token requests.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		validationLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "twitchauth_validation_duration_seconds",
+			Help:    "Duration of token validation requests.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		tokenExpiry: prometheus.NewDesc(
+			"twitchauth_token_expiry_seconds",
+			"Seconds until a managed token expires. Negative values indicate an expired token.",
+			[]string{"user_id", "client_id"}, nil,
+		),
+		tokenExpirySources: sources,
+	}
+}
+
+// Describe sends the descriptors of every metric exported by the Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.requests.Describe(ch)
+	c.refreshLatency.Describe(ch)
+	c.validationLatency.Describe(ch)
+	ch <- c.tokenExpiry
+}
+
+// Collect sends the current value of every metric exported by the Collector. Tokens without an expiry are not
+// reported. If several tokens share a user ID and client ID (ex. the same user is cached by two sources), only the
+// one expiring soonest is reported, as Prometheus rejects duplicate label sets.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.requests.Collect(ch)
+	c.refreshLatency.Collect(ch)
+	c.validationLatency.Collect(ch)
+
+	type tokenKey struct {
+		userId   string
+		clientId string
+	}
+
+	expiries := map[tokenKey]time.Time{}
+	for _, s := range c.tokenExpirySources {
+		for _, e := range s.TokenExpiries() {
+			if e.ExpiresAt.IsZero() {
+				continue
+			}
+
+			k := tokenKey{userId: e.UserId, clientId: e.ClientId}
+			if t, ok := expiries[k]; !ok || e.ExpiresAt.Before(t) {
+				expiries[k] = e.ExpiresAt
+			}
+		}
+	}
+
+	now := time.Now()
+	for k, t := range expiries {
+		ch <- prometheus.MustNewConstMetric(c.tokenExpiry, prometheus.GaugeValue,
+			t.Sub(now).Seconds(), k.userId, k.clientId)
+	}
+}
+
+// RequestStarted is a no-op; metrics are recorded once a request has finished.
+func (c *Collector) RequestStarted(ctx context.Context, _ ta.RequestInfo) context.Context {
+	return ctx
+}
+
+// RequestFinished records the outcome and latency of a completed request.
+func (c *Collector) RequestFinished(_ context.Context, info ta.RequestInfo, result ta.RequestResult) {
+	outcome := "success"
+	if result.ErrorKind != ta.ErrorKindNone {
+		outcome = result.ErrorKind.String()
+	}
+
+	c.requests.WithLabelValues(info.Endpoint.String(), outcome).Inc()
+
+	switch {
+	case info.GrantType == "refresh_token":
+		c.refreshLatency.Observe(result.Latency.Seconds())
+	case info.Endpoint == ta.EndpointValidation:
+		c.validationLatency.Observe(result.Latency.Seconds())
+	}
+}
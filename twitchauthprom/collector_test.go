@@ -0,0 +1,246 @@
+﻿package twitchauthprom
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	ta "github.com/adamsurek/go-twitchAuth"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// expirySource is a ta.TokenExpirySource reporting a fixed list of expiries.
+type expirySource []ta.TokenExpiry
+
+func (s expirySource) TokenExpiries() []ta.TokenExpiry {
+	return s
+}
+
+func TestCollectorDeduplicatesTokenExpiry(t *testing.T) {
+	now := time.Now()
+	c := New(
+		expirySource{
+			{UserId: "1", ClientId: "client-id", ExpiresAt: now.Add(time.Hour)},
+			{UserId: "2", ClientId: "client-id", ExpiresAt: now.Add(time.Hour)},
+		},
+		expirySource{
+			{UserId: "1", ClientId: "client-id", ExpiresAt: now.Add(10 * time.Minute)},
+			{UserId: "1", ClientId: "other-client-id", ExpiresAt: now.Add(time.Hour)},
+			{UserId: "3", ClientId: "client-id"},
+		},
+	)
+
+	r := prometheus.NewPedanticRegistry()
+	r.MustRegister(c)
+
+	families, err := r.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+
+	got := map[[2]string]float64{}
+	for _, f := range families {
+		if f.GetName() != "twitchauth_token_expiry_seconds" {
+			continue
+		}
+
+		for _, m := range f.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			got[[2]string{labels["user_id"], labels["client_id"]}] = m.GetGauge().GetValue()
+		}
+	}
+
+	if len(got) != 3 {
+		t.Fatalf("reported %d token expiries, want 3: %v", len(got), got)
+	}
+
+	// The soonest expiry is reported for a user cached by both sources.
+	if v := got[[2]string{"1", "client-id"}]; v > (10 * time.Minute).Seconds() {
+		t.Errorf("expiry for user 1 = %v, want at most %v", v, (10 * time.Minute).Seconds())
+	}
+}
+
+// gather collects the metrics exported by c, returning the value of each sample keyed by its metric name and labels,
+// ex. twitchauth_requests_total{endpoint=token,outcome=success}. Histograms report their sample count.
+func gather(t *testing.T, c *Collector) map[string]float64 {
+	t.Helper()
+
+	r := prometheus.NewPedanticRegistry()
+	r.MustRegister(c)
+
+	families, err := r.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+
+	got := map[string]float64{}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, l.GetName()+"="+l.GetValue())
+			}
+
+			key := f.GetName()
+			if len(labels) > 0 {
+				key += "{" + strings.Join(labels, ",") + "}"
+			}
+
+			switch {
+			case m.GetCounter() != nil:
+				got[key] = m.GetCounter().GetValue()
+			case m.GetHistogram() != nil:
+				got[key] = float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+
+	return got
+}
+
+// useCollector installs a new Collector as the only RequestObserver for the duration of the test.
+func useCollector(t *testing.T) *Collector {
+	t.Helper()
+
+	c := New()
+	ta.SetRequestObservers(c)
+	t.Cleanup(func() { ta.SetRequestObservers() })
+
+	return c
+}
+
+// newStatusServer starts a server responding to /{status} with that HTTP status.
+func newStatusServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		if err != nil {
+			status = http.StatusOK
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			_, _ = fmt.Fprint(w, `{"access_token":"access","refresh_token":"refresh","expires_in":3600,"token_type":"bearer",`+
+				`"client_id":"client-id","scopes":[]}`)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"status":%d,"message":"failed"}`, status)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestCollectorRequestOutcomes(t *testing.T) {
+	srv := newStatusServer(t)
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := map[string]struct {
+		tokenUrl string
+		want     string
+	}{
+		"success":         {tokenUrl: srv.URL + "/200", want: "success"},
+		"client error":    {tokenUrl: srv.URL + "/400", want: "client_error"},
+		"unauthorized":    {tokenUrl: srv.URL + "/401", want: "unauthorized"},
+		"server error":    {tokenUrl: srv.URL + "/503", want: "server_error"},
+		"transport error": {tokenUrl: closed.URL, want: "transport"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := useCollector(t)
+
+			a, err := ta.NewClientCredentialsGrantAuthenticatorWithOptions("client-id", "client-secret",
+				ta.WithHTTPClient(srv.Client()),
+				ta.WithEndpoints(ta.Endpoints{TokenUrl: tt.tokenUrl}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = a.GetToken()
+
+			got := gather(t, c)
+
+			key := "twitchauth_requests_total{endpoint=token,outcome=" + tt.want + "}"
+			if got[key] != 1 {
+				t.Errorf("%s = %v, want 1; gathered %v", key, got[key], got)
+			}
+
+			var total float64
+			for k, v := range got {
+				if strings.HasPrefix(k, "twitchauth_requests_total") {
+					total += v
+				}
+			}
+			if total != 1 {
+				t.Errorf("twitchauth_requests_total sums to %v, want 1; gathered %v", total, got)
+			}
+		})
+	}
+}
+
+func TestCollectorObservesLatency(t *testing.T) {
+	srv := newStatusServer(t)
+	c := useCollector(t)
+
+	endpoints := ta.Endpoints{TokenUrl: srv.URL + "/200", ValidationUrl: srv.URL + "/200"}
+
+	// Issuing a token is counted, but is neither a refresh nor a validation.
+	cc, err := ta.NewClientCredentialsGrantAuthenticatorWithOptions("client-id", "client-secret",
+		ta.WithHTTPClient(srv.Client()), ta.WithEndpoints(endpoints))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = cc.GetToken()
+
+	got := gather(t, c)
+	if got["twitchauth_refresh_duration_seconds"] != 0 || got["twitchauth_validation_duration_seconds"] != 0 {
+		t.Fatalf("latency observed for a client credentials request: %v", got)
+	}
+
+	ac, err := ta.NewAuthorizationCodeGrantAuthenticatorWithOptions("client-id", "client-secret", "http://localhost/callback",
+		ta.WithHTTPClient(srv.Client()), ta.WithEndpoints(endpoints), ta.WithScopes(ta.ScopeChatRead))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ac.RefreshToken("refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vc, err := ta.NewValidationCache(ta.WithValidationCacheHTTPClient(srv.Client()), ta.WithValidationCacheEndpoints(endpoints))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vc.Close()
+
+	_, err = vc.ValidateToken(context.Background(), "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got = gather(t, c)
+	for _, name := range []string{"twitchauth_refresh_duration_seconds", "twitchauth_validation_duration_seconds"} {
+		if got[name] != 1 {
+			t.Errorf("%s observed %v times, want 1; gathered %v", name, got[name], got)
+		}
+	}
+
+	if got["twitchauth_requests_total{endpoint=token,outcome=success}"] != 2 ||
+		got["twitchauth_requests_total{endpoint=validate,outcome=success}"] != 1 {
+		t.Errorf("twitchauth_requests_total = %v, want 2 token and 1 validation requests", got)
+	}
+}
@@ -0,0 +1,399 @@
+﻿package twitchauthtest
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// appTokenExpiresIn is the lifetime of app access tokens issued via the client credentials grant flow.
+	appTokenExpiresIn = 60 * 24 * time.Hour
+
+	// authCodeExpiresIn is the lifetime of authorization codes issued via the authorization endpoint.
+	authCodeExpiresIn = 10 * time.Minute
+
+	// deviceCodeExpiresIn is the lifetime of device codes issued via the device endpoint.
+	deviceCodeExpiresIn = 30 * time.Minute
+
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// handler builds the http.Handler serving every endpoint of the fake.
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/oauth2/authorize", s.withFailures("/oauth2/authorize", s.handleAuthorize))
+	mux.Handle("/oauth2/token", s.withFailures("/oauth2/token", s.handleToken))
+	mux.Handle("/oauth2/validate", s.withFailures("/oauth2/validate", s.handleValidate))
+	mux.Handle("/oauth2/revoke", s.withFailures("/oauth2/revoke", s.handleRevoke))
+	mux.Handle("/oauth2/device", s.withFailures("/oauth2/device", s.handleDevice))
+
+	return mux
+}
+
+// withFailures responds with the next failure injected for path, if any, before calling next.
+func (s *Server) withFailures(path string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f, ok := s.nextFailure(path); ok {
+			for k, v := range f.Header {
+				w.Header()[k] = v
+			}
+			writeError(w, f.Status, f.Message)
+			return
+		}
+
+		next(w, r)
+	})
+}
+
+// handleAuthorize approves the authorization request on behalf of the current user and redirects back to the app.
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	redirectUri, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirectUri.IsAbs() {
+		writeError(w, http.StatusBadRequest, "invalid redirect uri")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.apps[q.Get("client_id")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid client")
+		return
+	}
+
+	if len(a.redirectUris) > 0 && !slices.Contains(a.redirectUris, q.Get("redirect_uri")) {
+		writeError(w, http.StatusBadRequest, "redirect_mismatch")
+		return
+	}
+
+	scopes := strings.Fields(q.Get("scope"))
+	params := url.Values{}
+	params.Set("scope", strings.Join(scopes, " "))
+	if state := q.Get("state"); state != "" {
+		params.Set("state", state)
+	}
+
+	switch q.Get("response_type") {
+	case "code":
+		code := randomString(15)
+		s.codes[code] = &authCode{
+			clientId:    a.clientId,
+			redirectUri: q.Get("redirect_uri"),
+			scopes:      scopes,
+			user:        s.user,
+			expiresAt:   s.now().Add(authCodeExpiresIn),
+			challenge:   q.Get("code_challenge"),
+		}
+
+		params.Set("code", code)
+		redirectUri.RawQuery = mergeQuery(redirectUri.Query(), params).Encode()
+	case "token":
+		u := s.user
+		t := s.issue(a.clientId, &u, scopes, s.now().Add(DefaultExpiresIn), false)
+
+		params.Set("access_token", t.accessToken)
+		params.Set("token_type", "bearer")
+		redirectUri.Fragment = params.Encode()
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported response type")
+		return
+	}
+
+	http.Redirect(w, r, redirectUri.String(), http.StatusFound)
+}
+
+// handleToken issues tokens for every grant type supported by Twitch.
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	clientId, clientSecret, ok := clientCredentials(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid client credentials")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, found := s.apps[clientId]
+	if !found {
+		writeError(w, http.StatusForbidden, "invalid client")
+		return
+	}
+
+	grantType := r.Form.Get("grant_type")
+	if grantType != deviceCodeGrantType && a.clientSecret != clientSecret {
+		writeError(w, http.StatusForbidden, "invalid client secret")
+		return
+	}
+
+	switch grantType {
+	case "client_credentials":
+		t := s.issue(a.clientId, nil, nil, s.now().Add(appTokenExpiresIn), false)
+		s.writeToken(w, t, false)
+	case "authorization_code":
+		c, ok := s.codes[r.Form.Get("code")]
+		if !ok || c.clientId != a.clientId || !s.now().Before(c.expiresAt) {
+			writeError(w, http.StatusBadRequest, "Invalid authorization code")
+			return
+		}
+
+		if c.redirectUri != r.Form.Get("redirect_uri") {
+			writeError(w, http.StatusBadRequest, "Parameter redirect_uri does not match registered URI")
+			return
+		}
+
+		if c.challenge != "" {
+			sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+			if base64.RawURLEncoding.EncodeToString(sum[:]) != c.challenge {
+				writeError(w, http.StatusBadRequest, "Invalid code verifier")
+				return
+			}
+		}
+
+		delete(s.codes, r.Form.Get("code"))
+
+		u := c.user
+		t := s.issue(a.clientId, &u, c.scopes, s.now().Add(DefaultExpiresIn), true)
+		s.writeToken(w, t, true)
+	case "refresh_token":
+		old, ok := s.refreshTokens[r.Form.Get("refresh_token")]
+		if !ok || old.clientId != a.clientId {
+			writeError(w, http.StatusBadRequest, "Invalid refresh token")
+			return
+		}
+
+		s.remove(old)
+
+		t := s.issue(a.clientId, old.user, old.scopes, s.now().Add(DefaultExpiresIn), true)
+		s.writeToken(w, t, true)
+	case deviceCodeGrantType:
+		d, ok := s.devices[r.Form.Get("device_code")]
+		if !ok || d.clientId != a.clientId || !s.now().Before(d.expiresAt) {
+			writeError(w, http.StatusBadRequest, "invalid device code")
+			return
+		}
+
+		if d.user == nil {
+			writeError(w, http.StatusBadRequest, "authorization_pending")
+			return
+		}
+
+		delete(s.devices, d.deviceCode)
+
+		t := s.issue(a.clientId, d.user, d.scopes, s.now().Add(DefaultExpiresIn), true)
+		s.writeToken(w, t, true)
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported grant type")
+	}
+}
+
+// handleValidate reports the details of the access token supplied in the Authorization header.
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	auth := r.Header.Get("Authorization")
+	tok, ok := strings.CutPrefix(auth, "OAuth ")
+	if !ok {
+		tok, ok = strings.CutPrefix(auth, "Bearer ")
+	}
+
+	if !ok || tok == "" {
+		writeError(w, http.StatusUnauthorized, "missing authorization token")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.accessTokens[tok]
+	if !ok || s.expired(t) {
+		writeError(w, http.StatusUnauthorized, "invalid access token")
+		return
+	}
+
+	res := map[string]any{
+		"client_id":  t.clientId,
+		"scopes":     t.scopes,
+		"expires_in": s.expiresIn(t),
+	}
+
+	if t.user != nil {
+		res["login"] = t.user.Login
+		res["user_id"] = t.user.Id
+	}
+
+	writeJson(w, http.StatusOK, res)
+}
+
+// handleRevoke revokes the supplied access or refresh token.
+func (s *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.apps[r.Form.Get("client_id")]; !ok {
+		writeError(w, http.StatusNotFound, "client does not exist")
+		return
+	}
+
+	t := s.lookup(r.Form.Get("token"))
+	if t == nil {
+		writeError(w, http.StatusBadRequest, "Invalid token")
+		return
+	}
+
+	if t.clientId != r.Form.Get("client_id") {
+		writeError(w, http.StatusForbidden, "the client id does not match the token")
+		return
+	}
+
+	s.remove(t)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleDevice starts a device code authorization, which is approved via Server.ApproveDevice.
+func (s *Server) handleDevice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.apps[r.Form.Get("client_id")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid client")
+		return
+	}
+
+	d := &deviceAuth{
+		clientId:   a.clientId,
+		deviceCode: randomString(20),
+		userCode:   strings.ToUpper(randomString(4)),
+		scopes:     strings.Fields(r.Form.Get("scopes")),
+		expiresAt:  s.now().Add(deviceCodeExpiresIn),
+	}
+	s.devices[d.deviceCode] = d
+
+	writeJson(w, http.StatusOK, map[string]any{
+		"device_code":      d.deviceCode,
+		"expires_in":       int(deviceCodeExpiresIn.Seconds()),
+		"interval":         1,
+		"user_code":        d.userCode,
+		"verification_uri": "https://www.twitch.tv/activate?public=true&device-code=" + d.userCode,
+	})
+}
+
+// writeToken writes a successful token response. The caller must hold s.mu.
+func (s *Server) writeToken(w http.ResponseWriter, t *token, withScopes bool) {
+	res := map[string]any{
+		"access_token": t.accessToken,
+		"expires_in":   s.expiresIn(t),
+		"token_type":   "bearer",
+	}
+
+	if t.refreshToken != "" {
+		res["refresh_token"] = t.refreshToken
+	}
+
+	if withScopes {
+		res["scope"] = t.scopes
+	}
+
+	writeJson(w, http.StatusOK, res)
+}
+
+// expiresIn calculates the number of seconds until the supplied token expires. Tokens that never expire report 0.
+// The caller must hold s.mu.
+func (s *Server) expiresIn(t *token) int {
+	if t.expiresAt.IsZero() {
+		return 0
+	}
+
+	return int(t.expiresAt.Sub(s.now()).Seconds())
+}
+
+// clientCredentials retrieves the client ID and secret from the form-encoded body or, if present, the HTTP Basic
+// Authorization header. Basic credentials are form-encoded as described in RFC 6749.
+func clientCredentials(r *http.Request) (string, string, bool) {
+	user, pass, basic := r.BasicAuth()
+	if !basic {
+		return r.Form.Get("client_id"), r.Form.Get("client_secret"), true
+	}
+
+	clientId, err := url.QueryUnescape(user)
+	if err != nil {
+		return "", "", false
+	}
+
+	clientSecret, err := url.QueryUnescape(pass)
+	if err != nil {
+		return "", "", false
+	}
+
+	return clientId, clientSecret, true
+}
+
+// mergeQuery adds every parameter in extra to q.
+func mergeQuery(q url.Values, extra url.Values) url.Values {
+	for k, v := range extra {
+		q[k] = v
+	}
+
+	return q
+}
+
+// writeError writes a failed response in the format used by Twitch.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJson(w, status, map[string]any{
+		"status":  status,
+		"message": message,
+	})
+}
+
+// writeJson writes v as a JSON response.
+func writeJson(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
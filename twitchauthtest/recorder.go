@@ -0,0 +1,280 @@
+﻿package twitchauthtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/adamsurek/go-twitchAuth/internal/redact"
+)
+
+// ErrNoInteraction is returned when a Recorder in ModeReplay receives a request that does not match any unused
+// recorded interaction.
+var ErrNoInteraction = errors.New("twitchauthtest: no recorded interaction matches request")
+
+// Mode determines whether a Recorder records new interactions or replays previously recorded ones.
+type Mode int
+
+const (
+	// ModeReplay serves responses from a golden file without sending any requests.
+	ModeReplay Mode = iota
+	// ModeRecord sends requests to the real server and stores the exchanges once Save is called.
+	ModeRecord
+)
+
+// Interaction is a single recorded request and response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest stores the parts of a request used to match it during replay. Sensitive form parameters are
+// redacted.
+type RecordedRequest struct {
+	Method string     `json:"method"`
+	Path   string     `json:"path"`
+	Form   url.Values `json:"form,omitempty"`
+}
+
+// RecordedResponse stores a response. Tokens in JSON bodies are redacted; any other body is stored as-is in
+// BodyText.
+type RecordedResponse struct {
+	StatusCode int             `json:"status_code"`
+	Header     http.Header     `json:"header,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+	BodyText   string          `json:"body_text,omitempty"`
+}
+
+/*
+Recorder is an http.RoundTripper that records exchanges with id.twitch.tv to a golden file and replays them
+offline. Requests are matched on their method, path and normalized form parameters, taken from both the query string
+and a form-encoded body.
+
+Client secrets, authorization codes, PKCE code verifiers and tokens are redacted before anything is stored, so golden
+files are safe to commit. Requests are redacted the same way before they are matched, so a replayed request may carry
+any secret.
+
+New instances of Recorder should be created via NewRecorder.
+*/
+type Recorder struct {
+	mode Mode
+	path string
+	next http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewRecorder generates a new Recorder instance backed by the golden file at path. In ModeReplay the file is loaded
+// immediately. In ModeRecord requests are sent via next, or http.DefaultTransport if next is nil.
+func NewRecorder(path string, mode Mode, next http.RoundTripper) (*Recorder, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	r := Recorder{
+		mode: mode,
+		path: path,
+		next: next,
+	}
+
+	if mode == ModeReplay {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(b, &r.interactions)
+		if err != nil {
+			e := fmt.Sprintf("error while parsing golden file %s: %s", path, err)
+			return nil, errors.New(e)
+		}
+
+		r.used = make([]bool, len(r.interactions))
+	}
+
+	return &r, nil
+}
+
+// Client returns an http.Client that sends every request via the Recorder. Pass it to go_twitchAuth.SetHTTPClient
+// to record or replay the package's requests.
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// Interactions returns a copy of every interaction held by the Recorder.
+func (r *Recorder) Interactions() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Interaction(nil), r.interactions...)
+}
+
+// RoundTrip records or replays the supplied request, depending on the Recorder's Mode.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec, body, err := recordRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.mode == ModeReplay {
+		return r.replay(req, rec)
+	}
+
+	out := req
+	if body != nil {
+		out = req.Clone(req.Context())
+		out.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	res, err := r.next.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(b))
+
+	header := res.Header.Clone()
+	header.Del("Set-Cookie")
+	header.Del("Content-Length")
+
+	recorded := RecordedResponse{
+		StatusCode: res.StatusCode,
+		Header:     header,
+	}
+
+	if json.Valid(b) {
+		recorded.Body = redactJson(b)
+	} else {
+		recorded.BodyText = string(b)
+	}
+
+	r.mu.Lock()
+	r.interactions = append(r.interactions, Interaction{Request: rec, Response: recorded})
+	r.mu.Unlock()
+
+	return res, nil
+}
+
+// Save writes every recorded interaction to the Recorder's golden file.
+func (r *Recorder) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, err := json.MarshalIndent(r.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(r.path, append(b, '\n'), 0o644)
+}
+
+// replay serves the first unused interaction matching rec.
+func (r *Recorder) replay(req *http.Request, rec RecordedRequest) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, in := range r.interactions {
+		if r.used[i] || !matches(in.Request, rec) {
+			continue
+		}
+
+		r.used[i] = true
+
+		body := []byte(in.Response.BodyText)
+		if in.Response.Body != nil {
+			body = in.Response.Body
+		}
+
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.StatusCode, http.StatusText(in.Response.StatusCode)),
+			StatusCode:    in.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        in.Response.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, rec.Method, rec.Path)
+}
+
+// recordRequest captures the method, path and redacted form parameters of req. If the body of req was consumed, it
+// is returned so that it can be sent on.
+func recordRequest(req *http.Request) (RecordedRequest, []byte, error) {
+	var b []byte
+
+	form := url.Values{}
+	for k, v := range req.URL.Query() {
+		form[k] = v
+	}
+
+	if req.Body != nil && strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		var err error
+		b, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return RecordedRequest{}, nil, err
+		}
+
+		body, err := url.ParseQuery(string(b))
+		if err != nil {
+			return RecordedRequest{}, nil, err
+		}
+
+		for k, v := range body {
+			form[k] = append(form[k], v...)
+		}
+	}
+
+	if len(form) == 0 {
+		form = nil
+	} else {
+		form = redact.Values(form)
+	}
+
+	return RecordedRequest{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Form:   form,
+	}, b, nil
+}
+
+// matches reports whether two recorded requests are equivalent.
+func matches(a RecordedRequest, b RecordedRequest) bool {
+	return a.Method == b.Method && a.Path == b.Path && a.Form.Encode() == b.Form.Encode()
+}
+
+// redactJson replaces the values of sensitive fields in a valid JSON body.
+func redactJson(b []byte) json.RawMessage {
+	var v any
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		return b
+	}
+
+	redact.Decoded(v)
+
+	r, err := json.Marshal(v)
+	if err != nil {
+		return b
+	}
+
+	return r
+}
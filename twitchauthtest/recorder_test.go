@@ -0,0 +1,43 @@
+﻿package twitchauthtest
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/adamsurek/go-twitchAuth/internal/redact"
+)
+
+func TestRecordRequestRedactsSecrets(t *testing.T) {
+	form := url.Values{
+		"client_id":     {"client-id"},
+		"client_secret": {"client-secret"},
+		"code":          {"auth-code"},
+		"code_verifier": {"verifier"},
+		"grant_type":    {"authorization_code"},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "https://id.twitch.tv/oauth2/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rec, _, err := recordRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"client_secret", "code", "code_verifier"} {
+		if got := rec.Form.Get(k); got != redact.Value {
+			t.Errorf("Form[%q] = %q, want %q", k, got, redact.Value)
+		}
+	}
+
+	for _, k := range []string{"client_id", "grant_type"} {
+		if got := rec.Form.Get(k); got != form.Get(k) {
+			t.Errorf("Form[%q] = %q, want %q", k, got, form.Get(k))
+		}
+	}
+}
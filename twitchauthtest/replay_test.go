@@ -0,0 +1,249 @@
+﻿package twitchauthtest_test
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ta "github.com/adamsurek/go-twitchAuth"
+	"github.com/adamsurek/go-twitchAuth/twitchauthtest"
+)
+
+// update regenerates the golden files in testdata by recording the flows against a fake Server.
+var update = flag.Bool("update", false, "regenerate golden files in testdata")
+
+// goldenFile holds the exchanges recorded by runFlows.
+var goldenFile = filepath.Join("testdata", "flows.json")
+
+// flowResults stores the responses received by runFlows.
+type flowResults struct {
+	token          *ta.TokenResponse
+	validated      *ta.TokenValidationResponse
+	invalid        *ta.TokenValidationResponse
+	failedRefresh  *ta.TokenResponse
+	revoked        *ta.TokenRevocationResponse
+	clientSecret   string
+	refreshToken   string
+	validateTarget string
+}
+
+// runFlows sends an app access token request, a successful and a failed validation, a failed refresh and a
+// revocation via the package-level http.Client.
+func runFlows(t *testing.T, clientSecret string) flowResults {
+	t.Helper()
+
+	r := flowResults{clientSecret: clientSecret, refreshToken: "unknown-refresh-token"}
+
+	var err error
+	r.token, err = ta.NewClientCredentialsGrantAuthenticator("client-id", clientSecret).GetToken()
+	if err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+
+	r.validateTarget = r.token.Token.AccessToken
+	r.validated, err = ta.ValidateToken(r.validateTarget)
+	if err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+
+	r.invalid, err = ta.ValidateToken("invalid-access-token")
+	if err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+
+	a := ta.NewAuthorizationCodeGrantAuthenticator("client-id", clientSecret, false, "http://localhost/callback", nil, "")
+	r.failedRefresh, err = a.RefreshToken(r.refreshToken)
+	if err != nil {
+		t.Fatalf("RefreshToken() error = %v", err)
+	}
+
+	r.revoked, err = ta.RevokeToken("client-id", r.validateTarget)
+	if err != nil {
+		t.Fatalf("RevokeToken() error = %v", err)
+	}
+
+	return r
+}
+
+// record runs the flows against a fake Server through a Recorder in ModeRecord, saving the exchanges to path.
+func record(t *testing.T, path string) (*twitchauthtest.Server, flowResults) {
+	t.Helper()
+
+	s := twitchauthtest.NewServer()
+	t.Cleanup(s.Close)
+	s.RegisterApp("client-id", "client-secret")
+
+	rec, err := twitchauthtest.NewRecorder(path, twitchauthtest.ModeRecord, s.Client().Transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ta.SetHTTPClient(rec.Client())
+	t.Cleanup(func() { ta.SetHTTPClient(nil) })
+
+	results := runFlows(t, "client-secret")
+
+	err = rec.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, results
+}
+
+func TestRecorderReplay(t *testing.T) {
+	if *update {
+		record(t, goldenFile)
+	}
+
+	rec, err := twitchauthtest.NewRecorder(goldenFile, twitchauthtest.ModeReplay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ta.SetHTTPClient(rec.Client())
+	t.Cleanup(func() { ta.SetHTTPClient(nil) })
+
+	// Secrets are redacted before requests are matched, so replay works with any secret.
+	r := runFlows(t, "a-different-secret")
+
+	tests := map[string]struct {
+		ok  bool
+		got any
+	}{
+		"AccessTokenRequestResponse": {
+			ok: r.token.TokenRequestStatus == ta.StatusSuccess && r.token.TokenData.AccessToken == "[REDACTED]" &&
+				r.token.TokenData.ExpiresIn > 0 && r.token.TokenData.TokenType == "bearer",
+			got: r.token.TokenData,
+		},
+		"ValidTokenResponse": {
+			ok: r.validated.ValidationStatus == ta.StatusSuccess && r.validated.ValidationData.ClientId == "client-id" &&
+				r.validated.ValidationData.ExpiresIn > 0,
+			got: r.validated.ValidationData,
+		},
+		"FailedRequestResponse (validate)": {
+			ok: r.invalid.ValidationStatus == ta.StatusFailure && r.invalid.FailureData.Status == http.StatusUnauthorized &&
+				r.invalid.FailureData.Message == "invalid access token",
+			got: r.invalid.FailureData,
+		},
+		"FailedRequestResponse (refresh)": {
+			ok: r.failedRefresh.TokenRequestStatus == ta.StatusFailure && r.failedRefresh.FailureData.Status == http.StatusBadRequest &&
+				r.failedRefresh.FailureData.Message == "Invalid refresh token",
+			got: r.failedRefresh.FailureData,
+		},
+		"revocation": {
+			ok:  r.revoked.RevocationStatus == ta.StatusSuccess,
+			got: r.revoked,
+		},
+	}
+
+	for name, tt := range tests {
+		if !tt.ok {
+			t.Errorf("%s parsed from golden file = %+v", name, tt.got)
+		}
+	}
+
+	// Every interaction has been consumed, so a repeated request no longer matches.
+	req, err := http.NewRequest(http.MethodGet, "https://id.twitch.tv/oauth2/validate", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = rec.RoundTrip(req)
+	if !errors.Is(err, twitchauthtest.ErrNoInteraction) {
+		t.Errorf("RoundTrip() of a consumed interaction error = %v, want %v", err, twitchauthtest.ErrNoInteraction)
+	}
+}
+
+func TestRecorderReplayRejectsUnrecordedRequest(t *testing.T) {
+	rec, err := twitchauthtest.NewRecorder(goldenFile, twitchauthtest.ModeReplay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]*http.Request{
+		"unknown path":   mustRequest(t, http.MethodPost, "https://id.twitch.tv/oauth2/device", "client_id=client-id"),
+		"wrong method":   mustRequest(t, http.MethodPost, "https://id.twitch.tv/oauth2/validate", ""),
+		"different form": mustRequest(t, http.MethodPost, "https://id.twitch.tv/oauth2/token", "client_id=other-client-id&grant_type=client_credentials"),
+	}
+
+	for name, req := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := rec.RoundTrip(req)
+			if !errors.Is(err, twitchauthtest.ErrNoInteraction) {
+				t.Errorf("RoundTrip() error = %v, want %v", err, twitchauthtest.ErrNoInteraction)
+			}
+		})
+	}
+}
+
+func TestRecorderRedactsGoldenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flows.json")
+	s, r := record(t, path)
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secrets := []string{r.clientSecret, r.refreshToken, r.validateTarget, "invalid-access-token"}
+	for _, secret := range secrets {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("golden file contains secret %q", secret)
+		}
+	}
+
+	if s.Valid(r.validateTarget) {
+		t.Error("recorded revocation did not reach the fake")
+	}
+
+	// The checked-in golden file must also be free of secrets.
+	var interactions []twitchauthtest.Interaction
+	golden, err := os.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = json.Unmarshal(golden, &interactions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, in := range interactions {
+		for _, k := range []string{"client_secret", "refresh_token", "token", "code", "code_verifier", "device_code"} {
+			if v := in.Request.Form.Get(k); v != "" && v != "[REDACTED]" {
+				t.Errorf("%s %s form %s = %q, want it redacted", in.Request.Method, in.Request.Path, k, v)
+			}
+		}
+
+		var body map[string]any
+		if json.Unmarshal(in.Response.Body, &body) == nil {
+			for _, k := range []string{"access_token", "refresh_token"} {
+				if v, ok := body[k]; ok && v != "[REDACTED]" {
+					t.Errorf("%s %s response %s = %q, want it redacted", in.Request.Method, in.Request.Path, k, v)
+				}
+			}
+		}
+	}
+}
+
+// mustRequest builds a request with an optional form-encoded body.
+func mustRequest(t *testing.T, method string, url string, form string) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(form))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if form != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	return req
+}
@@ -0,0 +1,374 @@
+﻿/*
+Package twitchauthtest provides an in-process fake of the Twitch identity server (id.twitch.tv) for testing code
+built on go_twitchAuth without network access.
+
+The fake implements the /oauth2/authorize, /oauth2/token, /oauth2/validate, /oauth2/revoke and /oauth2/device
+endpoints. It is scriptable: apps and users are registered up front, tokens can be issued with any scopes and
+expiry, revoked or expired at any time, and failures can be injected into any endpoint.
+
+	s := twitchauthtest.NewServer()
+	defer s.Close()
+
+	s.RegisterApp("client-id", "client-secret", "http://localhost:3000/callback")
+	go_twitchAuth.SetHTTPClient(s.Client())
+
+	t, err := go_twitchAuth.NewClientCredentialsGrantAuthenticator("client-id", "client-secret").GetToken()
+*/
+package twitchauthtest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultExpiresIn is the lifetime of tokens issued by the fake's endpoints, matching the lifetime of Twitch's user
+// access tokens.
+const DefaultExpiresIn = 4 * time.Hour
+
+// ErrUnknownToken is returned when a token passed to a Server method was not issued by the Server.
+var ErrUnknownToken = errors.New("twitchauthtest: unknown token")
+
+// ErrUnknownDeviceCode is returned when a user code passed to ApproveDevice does not match a pending device
+// authorization.
+var ErrUnknownDeviceCode = errors.New("twitchauthtest: unknown device user code")
+
+/*
+Server is a fake Twitch identity server backed by an httptest.Server.
+
+New instances of Server should be created via NewServer and closed via Close once they are no longer needed.
+*/
+type Server struct {
+	srv *httptest.Server
+
+	mu            sync.Mutex
+	apps          map[string]*app
+	accessTokens  map[string]*token
+	refreshTokens map[string]*token
+	codes         map[string]*authCode
+	devices       map[string]*deviceAuth
+	failures      map[string][]Failure
+	user          User
+	now           func() time.Time
+}
+
+// User is the Twitch account that authorizes apps via the fake's authorization and device endpoints.
+type User struct {
+	Id    string
+	Login string
+}
+
+// TokenOptions configures a token issued via Server.IssueToken.
+type TokenOptions struct {
+	ClientId string
+	// User is the owner of the token. App access tokens are issued when User is nil.
+	User   *User
+	Scopes []string
+	// ExpiresIn is the lifetime of the token. A zero value issues a token that never expires.
+	ExpiresIn time.Duration
+	// Refreshable issues a refresh token alongside the access token.
+	Refreshable bool
+}
+
+// IssuedToken stores the tokens issued via Server.IssueToken.
+type IssuedToken struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+// Failure describes an error injected into one of the fake's endpoints via Server.InjectFailure.
+type Failure struct {
+	// Status is the HTTP status code of the failed response.
+	Status int
+	// Message is the message included in the failed response.
+	Message string
+	// Header is added to the failed response (ex. Ratelimit-Reset).
+	Header http.Header
+}
+
+// app is an application registered with the fake.
+type app struct {
+	clientId     string
+	clientSecret string
+	redirectUris []string
+}
+
+// token is an access token issued by the fake, along with its optional refresh token.
+type token struct {
+	accessToken  string
+	refreshToken string
+	clientId     string
+	user         *User
+	scopes       []string
+	expiresAt    time.Time
+}
+
+// authCode is an authorization code issued by the fake's authorization endpoint.
+type authCode struct {
+	clientId    string
+	redirectUri string
+	scopes      []string
+	user        User
+	expiresAt   time.Time
+	// challenge is the S256 PKCE code challenge, if one was supplied.
+	challenge string
+}
+
+// deviceAuth is a pending device code authorization.
+type deviceAuth struct {
+	clientId   string
+	deviceCode string
+	userCode   string
+	scopes     []string
+	user       *User
+	expiresAt  time.Time
+}
+
+// NewServer generates and starts a new Server instance. Authorization requests are approved on behalf of a user
+// with ID "12345" and login "twitchauthtest" until SetUser is called.
+func NewServer() *Server {
+	s := &Server{
+		apps:          make(map[string]*app),
+		accessTokens:  make(map[string]*token),
+		refreshTokens: make(map[string]*token),
+		codes:         make(map[string]*authCode),
+		devices:       make(map[string]*deviceAuth),
+		failures:      make(map[string][]Failure),
+		user:          User{Id: "12345", Login: "twitchauthtest"},
+		now:           time.Now,
+	}
+
+	s.srv = httptest.NewServer(s.handler())
+
+	return s
+}
+
+// URL returns the base URL of the fake (ex. http://127.0.0.1:53412).
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Close shuts down the fake and blocks until every outstanding request has completed.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Client returns an http.Client that sends every request addressed to id.twitch.tv to the fake instead. Pass it to
+// go_twitchAuth.SetHTTPClient to test the package's authenticators against the fake.
+func (s *Server) Client() *http.Client {
+	target, _ := url.Parse(s.srv.URL)
+
+	return &http.Client{
+		Transport: &rewriteTransport{target: target, next: s.srv.Client().Transport},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// SetClock replaces the function used by the fake to determine the current time, allowing tests to control token
+// expiry.
+func (s *Server) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.now = now
+}
+
+// SetUser sets the Twitch account that approves authorization and device code requests.
+func (s *Server) SetUser(u User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.user = u
+}
+
+// RegisterApp registers an application with the fake. Authorization requests are only accepted for registered
+// applications and redirect URIs. Apps registered with an empty clientSecret are treated as public clients, which
+// may refresh tokens without a secret.
+func (s *Server) RegisterApp(clientId string, clientSecret string, redirectUris ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.apps[clientId] = &app{
+		clientId:     clientId,
+		clientSecret: clientSecret,
+		redirectUris: redirectUris,
+	}
+}
+
+// IssueToken issues a token without going through any of the OAuth flows.
+func (s *Server) IssueToken(opts TokenOptions) IssuedToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expiresAt time.Time
+	if opts.ExpiresIn > 0 {
+		expiresAt = s.now().Add(opts.ExpiresIn)
+	}
+
+	t := s.issue(opts.ClientId, opts.User, opts.Scopes, expiresAt, opts.Refreshable)
+
+	return IssuedToken{
+		AccessToken:  t.accessToken,
+		RefreshToken: t.refreshToken,
+		ExpiresAt:    t.expiresAt,
+	}
+}
+
+// Revoke revokes the supplied access or refresh token, along with the token it was issued with.
+func (s *Server) Revoke(tok string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.lookup(tok)
+	if t == nil {
+		return ErrUnknownToken
+	}
+
+	s.remove(t)
+
+	return nil
+}
+
+// Expire immediately expires the supplied access token. Its refresh token, if any, remains usable.
+func (s *Server) Expire(accessToken string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.accessTokens[accessToken]
+	if !ok {
+		return ErrUnknownToken
+	}
+
+	t.expiresAt = s.now().Add(-time.Second)
+
+	return nil
+}
+
+// Valid reports whether the supplied access token is known to the fake and has not expired.
+func (s *Server) Valid(accessToken string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.accessTokens[accessToken]
+
+	return ok && !s.expired(t)
+}
+
+// ApproveDevice approves the pending device code authorization with the supplied user code on behalf of the
+// current user.
+func (s *Server) ApproveDevice(userCode string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.devices {
+		if d.userCode == userCode {
+			u := s.user
+			d.user = &u
+			return nil
+		}
+	}
+
+	return ErrUnknownDeviceCode
+}
+
+// InjectFailure queues a failed response for the next request sent to path (ex. "/oauth2/token"). Failures are
+// consumed in the order they were injected.
+func (s *Server) InjectFailure(path string, f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[path] = append(s.failures[path], f)
+}
+
+// issue creates and stores a new token. The caller must hold s.mu.
+func (s *Server) issue(clientId string, user *User, scopes []string, expiresAt time.Time, refreshable bool) *token {
+	t := &token{
+		accessToken: randomString(15),
+		clientId:    clientId,
+		user:        user,
+		scopes:      slices.Clone(scopes),
+		expiresAt:   expiresAt,
+	}
+
+	s.accessTokens[t.accessToken] = t
+
+	if refreshable {
+		t.refreshToken = randomString(25)
+		s.refreshTokens[t.refreshToken] = t
+	}
+
+	return t
+}
+
+// lookup finds the token matching the supplied access or refresh token. The caller must hold s.mu.
+func (s *Server) lookup(tok string) *token {
+	if t, ok := s.accessTokens[tok]; ok {
+		return t
+	}
+
+	return s.refreshTokens[tok]
+}
+
+// remove deletes the supplied token and its refresh token. The caller must hold s.mu.
+func (s *Server) remove(t *token) {
+	delete(s.accessTokens, t.accessToken)
+	if t.refreshToken != "" {
+		delete(s.refreshTokens, t.refreshToken)
+	}
+}
+
+// expired reports whether the supplied token has expired. The caller must hold s.mu.
+func (s *Server) expired(t *token) bool {
+	return !t.expiresAt.IsZero() && !s.now().Before(t.expiresAt)
+}
+
+// nextFailure pops the next failure injected for path, if any.
+func (s *Server) nextFailure(path string) (Failure, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f := s.failures[path]
+	if len(f) == 0 {
+		return Failure{}, false
+	}
+
+	s.failures[path] = f[1:]
+
+	return f[0], true
+}
+
+// randomString generates a random hex string from n random bytes.
+func randomString(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// rewriteTransport sends every request addressed to id.twitch.tv to target instead.
+type rewriteTransport struct {
+	target *url.URL
+	next   http.RoundTripper
+}
+
+func (t *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != "id.twitch.tv" {
+		return t.next.RoundTrip(req)
+	}
+
+	r := req.Clone(req.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	r.Host = t.target.Host
+
+	return t.next.RoundTrip(r)
+}
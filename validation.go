@@ -0,0 +1,122 @@
+﻿package go_twitchAuth
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Errors returned when an authenticator is supplied values that Twitch would reject. They are wrapped with details
+// of the offending value, so should be checked for via errors.Is.
+var (
+	ErrInvalidClientId          = errors.New("invalid client ID")
+	ErrInvalidClientSecret      = errors.New("invalid client secret")
+	ErrInvalidRedirectUri       = errors.New("invalid redirect URI")
+	ErrRedirectUriNotRegistered = errors.New("redirect URI is not registered")
+	ErrInvalidScopes            = errors.New("invalid scopes")
+)
+
+// ValidateClientId confirms that the supplied client ID is not empty and contains no whitespace or control
+// characters, which usually indicate a client ID that was copied or loaded incorrectly.
+func ValidateClientId(clientId string) error {
+	if clientId == "" {
+		return fmt.Errorf("%w: client ID must not be empty", ErrInvalidClientId)
+	}
+
+	if strings.IndexFunc(clientId, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return fmt.Errorf("%w: %q contains whitespace or control characters", ErrInvalidClientId, clientId)
+	}
+
+	return nil
+}
+
+// validateClientSecret confirms that the supplied client secret is not empty.
+func validateClientSecret(clientSecret string) error {
+	if clientSecret == "" {
+		return fmt.Errorf("%w: client secret must not be empty", ErrInvalidClientSecret)
+	}
+
+	return nil
+}
+
+/*
+ValidateRedirectUri confirms that the supplied redirect URI follows Twitch's rules: it must be an absolute URL
+without a fragment and use HTTPS, unless its host is localhost, in which case HTTP is also allowed.
+
+Twitch docs: https://dev.twitch.tv/docs/authentication/register-app/
+*/
+func ValidateRedirectUri(redirectUri string) error {
+	u, err := url.Parse(redirectUri)
+	if err != nil {
+		return fmt.Errorf("%w: %q could not be parsed: %s", ErrInvalidRedirectUri, redirectUri, err)
+	}
+
+	if !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("%w: %q is not an absolute URL", ErrInvalidRedirectUri, redirectUri)
+	}
+
+	if u.Fragment != "" {
+		return fmt.Errorf("%w: %q must not contain a fragment", ErrInvalidRedirectUri, redirectUri)
+	}
+
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if u.Hostname() != "localhost" {
+			return fmt.Errorf("%w: %q must use https; http is only allowed for http://localhost", ErrInvalidRedirectUri, redirectUri)
+		}
+	default:
+		return fmt.Errorf("%w: %q must use https", ErrInvalidRedirectUri, redirectUri)
+	}
+
+	return nil
+}
+
+// validateRegisteredRedirectUri confirms that the supplied redirect URI is valid and, if registered is not empty,
+// exactly matches one of the registered redirect URIs.
+func validateRegisteredRedirectUri(redirectUri string, registered []string) error {
+	err := ValidateRedirectUri(redirectUri)
+	if err != nil {
+		return err
+	}
+
+	if len(registered) > 0 && !slices.Contains(registered, redirectUri) {
+		return fmt.Errorf("%w: %q does not match any of %q", ErrRedirectUriNotRegistered, redirectUri, registered)
+	}
+
+	return nil
+}
+
+// validateAuthorizationRequest validates the values included in an authorization URL.
+func validateAuthorizationRequest(clientId string, redirectUri string, registered []string, scopes []ScopeType) error {
+	err := ValidateClientId(clientId)
+	if err != nil {
+		return err
+	}
+
+	err = validateRegisteredRedirectUri(redirectUri, registered)
+	if err != nil {
+		return err
+	}
+
+	return ValidateScopes(scopes)
+}
+
+// ValidateScopes confirms that the supplied list of scopes is not empty and only contains ScopeType values known to
+// this package. The zero ScopeType, which has no scope string, is rejected.
+func ValidateScopes(scopes []ScopeType) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("%w: at least one scope must be requested", ErrInvalidScopes)
+	}
+
+	for i, s := range scopes {
+		if _, ok := scopeTypeName[s]; !ok {
+			return fmt.Errorf("%w: scope %d is an unknown ScopeType (%d)", ErrInvalidScopes, i, s)
+		}
+	}
+
+	return nil
+}
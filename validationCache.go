@@ -0,0 +1,355 @@
+﻿package go_twitchAuth
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Defaults used by ValidationCache when the matching option is not supplied.
+const (
+	defaultValidationCacheTTL         = time.Minute
+	defaultValidationCacheNegativeTTL = 5 * time.Second
+	defaultValidationCacheSize        = 10000
+)
+
+/*
+validationCaches stores the results of every open ValidationCache, so that tokens can be evicted once they are
+revoked.
+
+Only the validationCacheStore is registered, never the ValidationCache holding it, so that a cache which is dropped
+without being closed can still be garbage collected. Its finalizer then removes the store from the registry.
+*/
+var validationCaches = struct {
+	mu     sync.Mutex
+	caches map[*validationCacheStore]struct{}
+}{caches: map[*validationCacheStore]struct{}{}}
+
+// ValidationCacheOption configures a ValidationCache created via NewValidationCache.
+type ValidationCacheOption func(*ValidationCache) error
+
+// WithValidationCacheTTL sets how long a successful validation is reused. Defaults to one minute. Results are never
+// reused beyond the token's expiry.
+func WithValidationCacheTTL(ttl time.Duration) ValidationCacheOption {
+	return func(c *ValidationCache) error {
+		if ttl <= 0 {
+			return errors.New("WithValidationCacheTTL: ttl must be positive")
+		}
+
+		c.ttl = ttl
+		return nil
+	}
+}
+
+// WithNegativeValidationCacheTTL sets how long Twitch's rejection of an invalid token is reused. Defaults to five
+// seconds. A ttl of 0 disables negative caching.
+func WithNegativeValidationCacheTTL(ttl time.Duration) ValidationCacheOption {
+	return func(c *ValidationCache) error {
+		if ttl < 0 {
+			return errors.New("WithNegativeValidationCacheTTL: ttl must not be negative")
+		}
+
+		c.negativeTTL = ttl
+		return nil
+	}
+}
+
+// WithValidationCacheHTTPClient sets the http.Client used to send validation requests in place of the one installed
+// via SetHTTPClient.
+func WithValidationCacheHTTPClient(hc *http.Client) ValidationCacheOption {
+	return func(c *ValidationCache) error {
+		if hc == nil {
+			return errors.New("WithValidationCacheHTTPClient: client must not be nil")
+		}
+
+		c.httpClient = hc
+		return nil
+	}
+}
+
+// WithValidationCacheEndpoints sets the Twitch OAuth endpoints used to send validation requests. Only ValidationUrl
+// is used, and falls back to Twitch's production endpoint if empty.
+func WithValidationCacheEndpoints(e Endpoints) ValidationCacheOption {
+	return func(c *ValidationCache) error {
+		err := checkEndpoints("WithValidationCacheEndpoints", e)
+		if err != nil {
+			return err
+		}
+
+		c.endpoints = e
+		return nil
+	}
+}
+
+// WithValidationCacheSize sets the maximum number of cached results. Once full, the least recently used result is
+// discarded. Defaults to 10,000.
+func WithValidationCacheSize(size int) ValidationCacheOption {
+	return func(c *ValidationCache) error {
+		if size <= 0 {
+			return errors.New("WithValidationCacheSize: size must be positive")
+		}
+
+		c.size = size
+		return nil
+	}
+}
+
+/*
+ValidationCache reduces the number of ValidateToken requests sent for tokens that are checked repeatedly, ex. on
+every request to an app's own API.
+
+Results are keyed by a SHA-256 hash of the token, so raw tokens are never held by the cache. Successful validations
+are reused until the token expires or the TTL elapses, whichever comes first, while tokens Twitch rejects as invalid
+are remembered briefly. Concurrent lookups of the same token share a single request. Tokens are evicted as soon as
+they are revoked via RevokeToken (or any function built on it).
+
+New instances of ValidationCache should be created via NewValidationCache, and closed via Close once they are no
+longer needed. A cache that is dropped without being closed stops being notified of revoked tokens once it is garbage
+collected, but Close releases its results immediately.
+*/
+type ValidationCache struct {
+	ttl         time.Duration
+	negativeTTL time.Duration
+	size        int
+	requestConfig
+
+	*validationCacheStore
+}
+
+// validationCacheStore holds the results of a ValidationCache. It is kept separate from the ValidationCache so that
+// the registry of open caches does not keep them from being garbage collected. See validationCaches.
+type validationCacheStore struct {
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*list.Element
+	lru     *list.List
+	calls   map[[sha256.Size]byte]*validationCall
+}
+
+// validationCacheEntry is a single cached result. Elements of the LRU list hold a *validationCacheEntry.
+type validationCacheEntry struct {
+	key       [sha256.Size]byte
+	res       *TokenValidationResponse
+	expiresAt time.Time
+}
+
+// validationCall is a validation request shared by concurrent lookups of the same token.
+type validationCall struct {
+	done chan struct{}
+	res  *TokenValidationResponse
+	err  error
+	// evicted is set if the token is evicted while the request is in flight, so that its result is not cached.
+	evicted bool
+}
+
+// NewValidationCache generates a new ValidationCache instance.
+func NewValidationCache(opts ...ValidationCacheOption) (*ValidationCache, error) {
+	c := &ValidationCache{
+		ttl:         defaultValidationCacheTTL,
+		negativeTTL: defaultValidationCacheNegativeTTL,
+		size:        defaultValidationCacheSize,
+		validationCacheStore: &validationCacheStore{
+			entries: map[[sha256.Size]byte]*list.Element{},
+			lru:     list.New(),
+			calls:   map[[sha256.Size]byte]*validationCall{},
+		},
+	}
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+
+		err := opt(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	store := c.validationCacheStore
+	validationCaches.mu.Lock()
+	validationCaches.caches[store] = struct{}{}
+	validationCaches.mu.Unlock()
+
+	runtime.SetFinalizer(c, func(*ValidationCache) { unregisterValidationCache(store) })
+
+	return c, nil
+}
+
+/*
+ValidateToken behaves like ValidateTokenWithContext, but reuses cached results and sends requests using the cache's
+HTTP client and endpoints. The ValidationData and FailureData of the returned TokenValidationResponse may be shared
+with other callers, so must not be modified.
+
+Only successful validations and Twitch's 401 rejections are cached. Other failures (ex. rate limiting or server
+errors) and request errors are returned to every waiting caller but not cached.
+*/
+func (c *ValidationCache) ValidateToken(ctx context.Context, token string) (*TokenValidationResponse, error) {
+	key := sha256.Sum256([]byte(token))
+
+	c.mu.Lock()
+	if res, ok := c.lookup(key); ok {
+		c.mu.Unlock()
+		return withAccessToken(res, token), nil
+	}
+
+	call, ok := c.calls[key]
+	if !ok {
+		call = &validationCall{done: make(chan struct{})}
+		c.calls[key] = call
+
+		// The request is detached from ctx, so that a caller giving up does not fail the others waiting on it.
+		go c.validate(context.WithoutCancel(ctx), key, token, call)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.res, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Evict removes any cached result for the supplied token.
+func (c *ValidationCache) Evict(token string) {
+	c.evict(sha256.Sum256([]byte(token)))
+}
+
+// Len retrieves the number of cached results, including any that have expired but not yet been discarded.
+func (c *ValidationCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// Close discards every cached result and stops the ValidationCache from being notified of revoked tokens.
+func (c *ValidationCache) Close() {
+	runtime.SetFinalizer(c, nil)
+	unregisterValidationCache(c.validationCacheStore)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = map[[sha256.Size]byte]*list.Element{}
+	c.lru.Init()
+}
+
+// validate sends the validation request for a call and caches its result.
+func (c *ValidationCache) validate(ctx context.Context, key [sha256.Size]byte, token string, call *validationCall) {
+	call.res, call.err = validateToken(ctx, token, c.requestConfig)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.calls, key)
+	close(call.done)
+
+	if call.err != nil || call.evicted {
+		return
+	}
+
+	now := time.Now()
+	var expiresAt time.Time
+	switch {
+	case call.res.ValidationStatus == StatusSuccess:
+		expiresAt = now.Add(c.ttl)
+		if !call.res.Token.NonExpiring && call.res.Token.ExpiresAt.Before(expiresAt) {
+			expiresAt = call.res.Token.ExpiresAt
+		}
+	case call.res.FailureData != nil && call.res.FailureData.Status == 401 && c.negativeTTL > 0:
+		expiresAt = now.Add(c.negativeTTL)
+	default:
+		return
+	}
+
+	c.add(key, withAccessToken(call.res, ""), expiresAt)
+}
+
+// lookup retrieves the unexpired result cached for key, marking it as recently used. The caller must hold c.mu.
+func (c *ValidationCache) lookup(key [sha256.Size]byte) (*TokenValidationResponse, bool) {
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := e.Value.(*validationCacheEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		c.remove(e)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(e)
+
+	return entry.res, true
+}
+
+// add caches a result, discarding the least recently used results if the cache is full. The caller must hold c.mu.
+func (c *ValidationCache) add(key [sha256.Size]byte, res *TokenValidationResponse, expiresAt time.Time) {
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+
+	for c.lru.Len() >= c.size {
+		c.remove(c.lru.Back())
+	}
+
+	c.entries[key] = c.lru.PushFront(&validationCacheEntry{key: key, res: res, expiresAt: expiresAt})
+}
+
+// evict removes any cached result for key, and stops an in-flight request for it from being cached.
+func (s *validationCacheStore) evict(key [sha256.Size]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok {
+		s.remove(e)
+	}
+
+	if call, ok := s.calls[key]; ok {
+		call.evicted = true
+	}
+}
+
+// remove discards a cached result. The caller must hold s.mu.
+func (s *validationCacheStore) remove(e *list.Element) {
+	s.lru.Remove(e)
+	delete(s.entries, e.Value.(*validationCacheEntry).key)
+}
+
+// withAccessToken copies a TokenValidationResponse, setting the access token of its Token. Cached responses are
+// stored without their access token.
+func withAccessToken(res *TokenValidationResponse, token string) *TokenValidationResponse {
+	r := *res
+	if r.Token != nil {
+		t := *r.Token
+		t.AccessToken = token
+		r.Token = &t
+	}
+
+	return &r
+}
+
+// unregisterValidationCache stops the supplied store from being notified of revoked tokens.
+func unregisterValidationCache(s *validationCacheStore) {
+	validationCaches.mu.Lock()
+	delete(validationCaches.caches, s)
+	validationCaches.mu.Unlock()
+}
+
+// evictFromValidationCaches removes the supplied token from every open ValidationCache.
+func evictFromValidationCaches(token string) {
+	key := sha256.Sum256([]byte(token))
+
+	validationCaches.mu.Lock()
+	defer validationCaches.mu.Unlock()
+
+	for s := range validationCaches.caches {
+		s.evict(key)
+	}
+}
@@ -0,0 +1,331 @@
+﻿package go_twitchAuth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adamsurek/go-twitchAuth/twitchauthtest"
+)
+
+func TestValidationCacheUsesConfiguredClient(t *testing.T) {
+	s := twitchauthtest.NewServer()
+	t.Cleanup(s.Close)
+
+	tok := s.IssueToken(twitchauthtest.TokenOptions{ClientId: "client-id", Scopes: []string{"chat:read"}})
+
+	// The package-level client is left pointing at Twitch, so the request only reaches the fake via the cache's
+	// own client and endpoints.
+	c, err := NewValidationCache(
+		WithValidationCacheHTTPClient(&http.Client{}),
+		WithValidationCacheEndpoints(Endpoints{ValidationUrl: s.URL() + "/oauth2/validate"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+
+	res, err := c.ValidateToken(context.Background(), tok.AccessToken)
+	if err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+
+	if res.ValidationStatus != StatusSuccess || res.ValidationData.ClientId != "client-id" {
+		t.Fatalf("ValidateToken() = %+v, want a successful validation for client-id", res)
+	}
+
+	if c.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", c.Len())
+	}
+}
+
+func TestValidationCacheEndpointsRejectsRelativeUrl(t *testing.T) {
+	_, err := NewValidationCache(WithValidationCacheEndpoints(Endpoints{ValidationUrl: "/oauth2/validate"}))
+	if err == nil {
+		t.Fatal("NewValidationCache() error = nil, want an invalid endpoint error")
+	}
+}
+
+// validationStub serves Twitch's validation and revocation endpoints, counting validation requests. Tokens prefixed
+// "invalid" or revoked via the stub are rejected with a 401, and those prefixed "unavailable" with a 503. Other tokens
+// are reported as issued to clientId with the chat:read scope. Validation requests wait on release, if supplied.
+type validationStub struct {
+	clientId string
+	requests atomic.Int32
+	release  chan struct{}
+
+	mu      sync.Mutex
+	revoked map[string]bool
+}
+
+// newValidationStub starts a validationStub, returning the requestConfig that sends requests to it.
+func newValidationStub(t *testing.T) (*validationStub, requestConfig) {
+	t.Helper()
+
+	v := &validationStub{clientId: "client-id", revoked: map[string]bool{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if r.URL.Path == "/revoke" {
+			_ = r.ParseForm()
+			v.mu.Lock()
+			v.revoked[r.PostForm.Get("token")] = true
+			v.mu.Unlock()
+			return
+		}
+
+		v.requests.Add(1)
+		if v.release != nil {
+			<-v.release
+		}
+
+		v.mu.Lock()
+		revoked := v.revoked[token]
+		v.mu.Unlock()
+
+		switch {
+		case revoked || strings.HasPrefix(token, "invalid"):
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"status":401,"message":"invalid access token"}`))
+		case strings.HasPrefix(token, "unavailable"):
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"status":503,"message":"service unavailable"}`))
+		default:
+			_, _ = fmt.Fprintf(w, `{"client_id":%q,"login":"twitchauthtest","scopes":["chat:read"],"user_id":"12345","expires_in":3600}`, v.clientId)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return v, requestConfig{
+		httpClient: srv.Client(),
+		endpoints:  Endpoints{ValidationUrl: srv.URL + "/validate", RevocationUrl: srv.URL + "/revoke"},
+	}
+}
+
+// newStubValidationCache creates a ValidationCache sending requests to a validationStub.
+func newStubValidationCache(t *testing.T, c requestConfig, opts ...ValidationCacheOption) *ValidationCache {
+	t.Helper()
+
+	opts = append([]ValidationCacheOption{
+		WithValidationCacheHTTPClient(c.httpClient),
+		WithValidationCacheEndpoints(c.endpoints),
+	}, opts...)
+
+	cache, err := NewValidationCache(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cache.Close)
+
+	return cache
+}
+
+// validateN validates token n times via the cache, failing the test on error.
+func validateN(t *testing.T, c *ValidationCache, token string, n int) *TokenValidationResponse {
+	t.Helper()
+
+	var res *TokenValidationResponse
+	for i := 0; i < n; i++ {
+		var err error
+		res, err = c.ValidateToken(context.Background(), token)
+		if err != nil {
+			t.Fatalf("ValidateToken() error = %v", err)
+		}
+	}
+
+	return res
+}
+
+func TestValidationCacheTTL(t *testing.T) {
+	tests := map[string]struct {
+		token        string
+		opts         []ValidationCacheOption
+		wantStatus   responseStatus
+		wantRequests int32
+		// wantAfterTTL is the number of requests sent once the TTL has elapsed and the token is validated again.
+		wantAfterTTL int32
+	}{
+		"valid": {
+			token:        "valid-token",
+			opts:         []ValidationCacheOption{WithValidationCacheTTL(50 * time.Millisecond)},
+			wantStatus:   StatusSuccess,
+			wantRequests: 1,
+			wantAfterTTL: 2,
+		},
+		"invalid": {
+			token:        "invalid-token",
+			opts:         []ValidationCacheOption{WithNegativeValidationCacheTTL(50 * time.Millisecond)},
+			wantStatus:   StatusFailure,
+			wantRequests: 1,
+			wantAfterTTL: 2,
+		},
+		"invalid without negative caching": {
+			token:        "invalid-token",
+			opts:         []ValidationCacheOption{WithNegativeValidationCacheTTL(0)},
+			wantStatus:   StatusFailure,
+			wantRequests: 3,
+			wantAfterTTL: 4,
+		},
+		"unavailable": {
+			token:        "unavailable-token",
+			wantStatus:   StatusFailure,
+			wantRequests: 3,
+			wantAfterTTL: 4,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			v, rc := newValidationStub(t)
+			c := newStubValidationCache(t, rc, tt.opts...)
+
+			res := validateN(t, c, tt.token, 3)
+			if res.ValidationStatus != tt.wantStatus {
+				t.Fatalf("ValidateToken() status = %v, want %v", res.ValidationStatus, tt.wantStatus)
+			}
+
+			if res.Token != nil && res.Token.AccessToken != tt.token {
+				t.Errorf("ValidateToken().Token.AccessToken = %q, want the validated token", res.Token.AccessToken)
+			}
+
+			if got := v.requests.Load(); got != tt.wantRequests {
+				t.Errorf("3 lookups sent %d requests, want %d", got, tt.wantRequests)
+			}
+
+			time.Sleep(60 * time.Millisecond)
+			validateN(t, c, tt.token, 1)
+
+			if got := v.requests.Load(); got != tt.wantAfterTTL {
+				t.Errorf("lookup after the TTL brought the total to %d requests, want %d", got, tt.wantAfterTTL)
+			}
+		})
+	}
+}
+
+func TestValidationCacheSharesConcurrentRequests(t *testing.T) {
+	v, rc := newValidationStub(t)
+	v.release = make(chan struct{})
+	c := newStubValidationCache(t, rc)
+
+	// The token is not cached once validated, so every lookup that does not share the first request sends its own.
+	const lookups = 10
+	results := make(chan *TokenValidationResponse, lookups)
+	for i := 0; i < lookups; i++ {
+		go func() {
+			res, err := c.ValidateToken(context.Background(), "unavailable-token")
+			if err != nil {
+				t.Error(err)
+			}
+			results <- res
+		}()
+	}
+
+	// Wait for the shared request to arrive, and for every lookup to join it.
+	for v.requests.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(v.release)
+
+	first := <-results
+	for i := 1; i < lookups; i++ {
+		if res := <-results; res != first {
+			t.Errorf("lookup %d did not share the first lookup's result", i)
+		}
+	}
+
+	if got := v.requests.Load(); got != 1 {
+		t.Errorf("%d concurrent lookups sent %d requests, want 1", lookups, got)
+	}
+}
+
+func TestValidationCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	v, rc := newValidationStub(t)
+	c := newStubValidationCache(t, rc, WithValidationCacheSize(2))
+
+	validateN(t, c, "token-a", 1)
+	validateN(t, c, "token-b", 1)
+	validateN(t, c, "token-a", 1)
+	validateN(t, c, "token-c", 1)
+
+	if c.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", c.Len())
+	}
+
+	sent := v.requests.Load()
+	validateN(t, c, "token-a", 1)
+	validateN(t, c, "token-c", 1)
+	if got := v.requests.Load(); got != sent {
+		t.Errorf("lookups of the most recently used tokens sent %d requests, want 0", got-sent)
+	}
+
+	validateN(t, c, "token-b", 1)
+	if got := v.requests.Load(); got != sent+1 {
+		t.Errorf("lookup of the least recently used token sent %d requests, want 1", got-sent)
+	}
+}
+
+func TestValidationCacheEvictsRevokedTokens(t *testing.T) {
+	v, rc := newValidationStub(t)
+	c := newStubValidationCache(t, rc)
+
+	res := validateN(t, c, "valid-token", 2)
+	if res.ValidationStatus != StatusSuccess || v.requests.Load() != 1 {
+		t.Fatalf("ValidateToken() = %v after %d requests, want a cached success", res.ValidationStatus, v.requests.Load())
+	}
+
+	_, err := revokeToken(context.Background(), "client-id", "valid-token", rc)
+	if err != nil {
+		t.Fatalf("revokeToken() error = %v", err)
+	}
+
+	if c.Len() != 0 {
+		t.Errorf("Len() = %d after revocation, want 0", c.Len())
+	}
+
+	res = validateN(t, c, "valid-token", 1)
+	if res.ValidationStatus != StatusFailure || v.requests.Load() != 2 {
+		t.Errorf("ValidateToken() after revocation = %v after %d requests, want a new failed validation", res.ValidationStatus, v.requests.Load())
+	}
+}
+
+func TestValidationCacheUnregisteredOnceUnreachable(t *testing.T) {
+	registered := func(s *validationCacheStore) bool {
+		validationCaches.mu.Lock()
+		defer validationCaches.mu.Unlock()
+
+		_, ok := validationCaches.caches[s]
+		return ok
+	}
+
+	store := func() *validationCacheStore {
+		c, err := NewValidationCache()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return c.validationCacheStore
+	}()
+
+	if !registered(store) {
+		t.Fatal("NewValidationCache() did not register the cache")
+	}
+
+	// The cache was dropped without being closed, so is unregistered by its finalizer once collected.
+	deadline := time.Now().Add(5 * time.Second)
+	for registered(store) && time.Now().Before(deadline) {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if registered(store) {
+		t.Error("an unreachable ValidationCache is still registered")
+	}
+}
@@ -0,0 +1,124 @@
+﻿package go_twitchAuth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateClientId(t *testing.T) {
+	tests := map[string]struct {
+		clientId string
+		wantErr  bool
+	}{
+		"valid":              {clientId: "abcdefghijklmnopqrstuvwxyz0123"},
+		"empty":              {clientId: "", wantErr: true},
+		"leading space":      {clientId: " abcdefghijklmnopqrstuvwxyz0123", wantErr: true},
+		"trailing newline":   {clientId: "abcdefghijklmnopqrstuvwxyz0123\n", wantErr: true},
+		"tab":                {clientId: "abcdefghijklmno\tpqrstuvwxyz0123", wantErr: true},
+		"control character":  {clientId: "abcdefghijklmnopqrstuvwxyz0123\x00", wantErr: true},
+		"unicode whitespace": {clientId: "abcdefghijklmnopqrstuvwxyz0123\u00a0", wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateClientId(tt.clientId)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateClientId() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if err != nil && !errors.Is(err, ErrInvalidClientId) {
+				t.Errorf("ValidateClientId() error = %v, want %v", err, ErrInvalidClientId)
+			}
+		})
+	}
+}
+
+func TestValidateRedirectUri(t *testing.T) {
+	tests := map[string]struct {
+		redirectUri string
+		wantErr     bool
+	}{
+		"https":                 {redirectUri: "https://example.com/callback"},
+		"https with query":      {redirectUri: "https://example.com/callback?source=twitch"},
+		"http localhost":        {redirectUri: "http://localhost/callback"},
+		"http localhost port":   {redirectUri: "http://localhost:3000/callback"},
+		"https localhost":       {redirectUri: "https://localhost/callback"},
+		"http loopback address": {redirectUri: "http://127.0.0.1/callback", wantErr: true},
+		"http ipv6 loopback":    {redirectUri: "http://[::1]/callback", wantErr: true},
+		"http other host":       {redirectUri: "http://example.com/callback", wantErr: true},
+		"http localhost suffix": {redirectUri: "http://localhost.example.com/callback", wantErr: true},
+		"fragment":              {redirectUri: "https://example.com/callback#token", wantErr: true},
+		"localhost fragment":    {redirectUri: "http://localhost/callback#token", wantErr: true},
+		"relative":              {redirectUri: "/callback", wantErr: true},
+		"missing host":          {redirectUri: "https:///callback", wantErr: true},
+		"other scheme":          {redirectUri: "myapp://callback", wantErr: true},
+		"empty":                 {redirectUri: "", wantErr: true},
+		"unparseable":           {redirectUri: "https://example.com/%zz", wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateRedirectUri(tt.redirectUri)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateRedirectUri(%q) error = %v, wantErr %t", tt.redirectUri, err, tt.wantErr)
+			}
+
+			if err != nil && !errors.Is(err, ErrInvalidRedirectUri) {
+				t.Errorf("ValidateRedirectUri() error = %v, want %v", err, ErrInvalidRedirectUri)
+			}
+		})
+	}
+}
+
+func TestValidateRegisteredRedirectUri(t *testing.T) {
+	registered := []string{"https://example.com/callback", "http://localhost:3000/callback"}
+
+	tests := map[string]struct {
+		redirectUri string
+		registered  []string
+		want        error
+	}{
+		"registered":          {redirectUri: "http://localhost:3000/callback", registered: registered},
+		"nothing registered":  {redirectUri: "https://example.com/other"},
+		"not registered":      {redirectUri: "https://example.com/other", registered: registered, want: ErrRedirectUriNotRegistered},
+		"different port":      {redirectUri: "http://localhost:3001/callback", registered: registered, want: ErrRedirectUriNotRegistered},
+		"trailing slash":      {redirectUri: "https://example.com/callback/", registered: registered, want: ErrRedirectUriNotRegistered},
+		"invalid and missing": {redirectUri: "http://example.com/callback", registered: registered, want: ErrInvalidRedirectUri},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validateRegisteredRedirectUri(tt.redirectUri, tt.registered)
+			if !errors.Is(err, tt.want) || (err != nil) != (tt.want != nil) {
+				t.Errorf("validateRegisteredRedirectUri() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateScopes(t *testing.T) {
+	tests := map[string]struct {
+		scopes  []ScopeType
+		wantErr bool
+	}{
+		"known":          {scopes: []ScopeType{ScopeChatRead, ScopeChatEdit}},
+		"none":           {scopes: nil, wantErr: true},
+		"empty":          {scopes: []ScopeType{}, wantErr: true},
+		"unknown":        {scopes: []ScopeType{ScopeChatRead, ScopeType(100000)}, wantErr: true},
+		"zero ScopeType": {scopes: []ScopeType{ScopeType(0)}, wantErr: true},
+		"negative":       {scopes: []ScopeType{ScopeType(-1)}, wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateScopes(tt.scopes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateScopes() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if err != nil && !errors.Is(err, ErrInvalidScopes) {
+				t.Errorf("ValidateScopes() error = %v, want %v", err, ErrInvalidScopes)
+			}
+		})
+	}
+}